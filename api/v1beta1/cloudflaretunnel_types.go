@@ -63,6 +63,46 @@ type CloudflareTunnelSpec struct {
 	// +optional
 	Settings CloudflareTunnelSettings `json:"settings,omitempty"`
 
+	// TunnelID is the ID of an existing remotely-managed Cloudflare Tunnel to adopt instead of creating a new one.
+	// The existing ingress rules of the tunnel are preserved.
+	// If the tunnel is deleted in Cloudflare, the CloudflareTunnel is not Ready until this field is removed,
+	// and then a new tunnel is created.
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="tunnelID is immutable"
+	// +optional
+	TunnelID string `json:"tunnelID,omitempty"`
+
+	// AdoptExisting specifies whether to adopt an existing remotely-managed Cloudflare Tunnel with the same name
+	// (settings.nameOverride or metadata.name) instead of creating a new one.
+	// If no such tunnel exists, a new one is created.
+	// +kubebuilder:default=false
+	// +optional
+	AdoptExisting bool `json:"adoptExisting,omitempty"`
+
+	// DeletionPolicy specifies what happens to the Cloudflare Tunnel and its DNS records when this resource is deleted.
+	// "Delete" deletes them. "Retain" keeps them so that they can be adopted again with spec.tunnelID.
+	// +kubebuilder:validation:Enum=Delete;Retain
+	// +kubebuilder:default=Delete
+	// +optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// CredentialsRef references a Secret in the same namespace that holds the Cloudflare credentials for this tunnel.
+	// The Secret must contain the keys "cloudflareAPIToken" and "cloudflareAccountID",
+	// and may contain "cloudflareZones", a comma-separated list of zone names or IDs used when spec.zones is empty.
+	// If unset, the credentials of the operator are used.
+	// +optional
+	CredentialsRef *corev1.LocalObjectReference `json:"credentialsRef,omitempty"`
+
+	// Zones restricts the Cloudflare zones in which DNS records are managed for this tunnel.
+	// Each entry is either a zone name (e.g. example.com) or a zone ID.
+	// If empty, all zones visible to the API token are used, and each hostname is assigned to the zone with the longest matching suffix.
+	// +optional
+	Zones []string `json:"zones,omitempty"`
+
+	// TokenRotation rotates the tunnel secret periodically and restarts the cloudflared Pods with the new token.
+	// A rotation can also be requested once with the cf-tunnel-operator.walnuts.dev/rotate-token annotation.
+	// +optional
+	TokenRotation *TokenRotation `json:"tokenRotation,omitempty"`
+
 	// +optional
 	PodSecurityContext *PodSecurityContextApplyConfiguration `json:"podSecurityContext,omitempty"`
 
@@ -70,6 +110,34 @@ type CloudflareTunnelSpec struct {
 	SecurityContext *SecurityContextApplyConfiguration `json:"securityContext,omitempty"`
 }
 
+type TokenRotation struct {
+	// Interval is the time between rotations of the tunnel secret, e.g. "720h".
+	// +kubebuilder:validation:XValidation:rule="duration(self) >= duration('1h')",message="interval must be at least 1h"
+	Interval metav1.Duration `json:"interval"`
+}
+
+// PendingTokenRotation records a rotation of the tunnel secret before it is made in Cloudflare,
+// so that a retry after a failure neither skips nor repeats the rotation.
+type PendingTokenRotation struct {
+	// RequestedTime is the time when the rotation was started.
+	RequestedTime metav1.Time `json:"requestedTime"`
+
+	// PreviousTokenChecksum is the SHA-256 checksum of the token before the rotation.
+	// The secret is not rotated again once the token in Cloudflare differs from it.
+	PreviousTokenChecksum string `json:"previousTokenChecksum"`
+
+	// Reason is why the rotation was started.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+}
+
+type DeletionPolicy string
+
+const (
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+)
+
 type PDBSpec struct {
 	// MinAvailable is the minimum number of pods that must be available at any given time.
 	// +optional
@@ -80,14 +148,36 @@ type PDBSpec struct {
 	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
 }
 
+type RoutingMode string
+
+const (
+	RoutingModeLoadBalancer RoutingMode = "LoadBalancer"
+	RoutingModeService      RoutingMode = "Service"
+)
+
 type CloudflareTunnelSettings struct {
 	// +optional
 	NameOverride string `json:"nameOverride,omitempty"`
 
+	// RoutingMode specifies where the tunnel sends the traffic for Ingress hosts.
+	// "LoadBalancer" sends it to the load balancer address of the Ingress (i.e. the ingress controller),
+	// and "Service" sends each path directly to the backend Service, so that path matching happens inside the tunnel.
+	// It can be overridden per Ingress with the cf-tunnel-operator.walnuts.dev/routing-mode annotation.
+	// +kubebuilder:validation:Enum=LoadBalancer;Service
+	// +kubebuilder:default=LoadBalancer
+	// +optional
+	RoutingMode RoutingMode `json:"routingMode,omitempty"`
+
 	// +kubebuilder:default="http_status:404"
 	// +optional
 	CatchAllRule string `json:"catchAllRule,omitempty"`
 
+	// Exclusive removes the tunnel ingress rules that were not added by the operator, e.g. the ones added on the dashboard.
+	// Otherwise, they are left as they are.
+	// +kubebuilder:default=false
+	// +optional
+	Exclusive bool `json:"exclusive,omitempty"`
+
 	// Path to the certificate authority (CA) for the certificate of your origin. This option should be used only if your certificate is not signed by Cloudflare.
 	// +optional
 	CAPool *string `json:"caPool,omitempty"`
@@ -135,10 +225,74 @@ type CloudflareTunnelSettings struct {
 	// +kubebuilder:default=100
 	// +optional
 	KeepAliveConnections int32 `json:"keepAliveConnections,omitempty"`
+
+	// The timeout after which a TCP keepalive packet is sent on a connection between Tunnel and the origin server.
+	// +kubebuilder:default=30
+	// +optional
+	TCPKeepAliveSeconds int32 `json:"tcpKeepAliveSeconds,omitempty"`
+
+	// Keeps the Host header of the request instead of setting httpHostHeader to the hostname of the rule.
+	// Useful for wildcard hostnames, whose origin needs to know which subdomain was requested.
+	// +kubebuilder:default=false
+	// +optional
+	PreserveHostHeader bool `json:"preserveHostHeader,omitempty"`
+
+	// Runs the origin as a jump host, so that clients can choose the destination with cloudflared access.
+	// +kubebuilder:default=false
+	// +optional
+	BastionMode bool `json:"bastionMode,omitempty"`
+
+	// Listen address of the proxy server that cloudflared starts for non-HTTP origins.
+	// +optional
+	ProxyAddress string `json:"proxyAddress,omitempty"`
+
+	// Listen port of the proxy server that cloudflared starts for non-HTTP origins.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	ProxyPort int32 `json:"proxyPort,omitempty"`
+
+	// Rules that allow or deny the destinations of the proxy server. The first matching rule is used.
+	// +optional
+	IPRules []IPRule `json:"ipRules,omitempty"`
+
+	// Requires a valid Cloudflare Access JWT on every request to the origin.
+	// +optional
+	Access *AccessConfig `json:"access,omitempty"`
+}
+
+type IPRule struct {
+	// IP range in CIDR notation, e.g. "10.0.0.0/8".
+	Prefix string `json:"prefix"`
+
+	// Ports of the range. Every port matches if it is empty.
+	// +optional
+	Ports []int32 `json:"ports,omitempty"`
+
+	// +optional
+	Allow bool `json:"allow,omitempty"`
+}
+
+type AccessConfig struct {
+	// Rejects every request that does not have a valid Access JWT.
+	// +optional
+	Required bool `json:"required,omitempty"`
+
+	// Name of the Zero Trust organization to get the public keys from.
+	// +kubebuilder:validation:MinLength=1
+	TeamName string `json:"teamName"`
+
+	// Application Audience (AUD) tags that the JWT is verified against.
+	// +optional
+	AudTag []string `json:"audTag,omitempty"`
 }
 
 // CloudflareTunnelStatus defines the observed state of CloudflareTunnel.
 type CloudflareTunnelStatus struct {
+	// ObservedGeneration is the generation of the CloudflareTunnel that was reconciled last.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
 	// Replicas is copied from the underlying Deployment's status.replicas.
 	// +optional
 	Replicas int32 `json:"replicas,omitempty"`
@@ -149,21 +303,99 @@ type CloudflareTunnelStatus struct {
 	// +optional
 	TunnelID string `json:"tunnelID"`
 
+	// DriftedRules is the number of tunnel ingress rules that differed from the desired ones at the last resync and were repaired.
+	// +optional
+	DriftedRules int32 `json:"driftedRules,omitempty"`
+
+	// DriftedDNSRecords is the number of DNS records that did not point to the tunnel at the last resync and were repaired.
+	// +optional
+	DriftedDNSRecords int32 `json:"driftedDNSRecords,omitempty"`
+
+	// LastSyncTime is the time of the last resync of the tunnel ingress rules and DNS records.
+	// +optional
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+
+	// LastTokenRotationTime is the time when the tunnel secret was rotated last.
+	// +optional
+	LastTokenRotationTime *metav1.Time `json:"lastTokenRotationTime,omitempty"`
+
+	// PendingTokenRotation is the rotation of the tunnel secret that has been started but not completed yet.
+	// +optional
+	PendingTokenRotation *PendingTokenRotation `json:"pendingTokenRotation,omitempty"`
+
+	// ConnectedReplicas is the number of cloudflared connectors that have at least one active connection to the Cloudflare edge.
+	// +optional
+	ConnectedReplicas int32 `json:"connectedReplicas,omitempty"`
+
+	// Connections is the total number of active connections of the connectors to the Cloudflare edge.
+	// +optional
+	Connections int32 `json:"connections,omitempty"`
+
+	// Connectors are the cloudflared connectors registered to the tunnel, as reported by Cloudflare.
+	// +listType=map
+	// +listMapKey=id
+	// +optional
+	Connectors []TunnelConnectorStatus `json:"connectors,omitempty"`
+
+	// LastConnectorCheckTime is the time when the connectors of the tunnel were fetched from Cloudflare last.
+	// +optional
+	LastConnectorCheckTime *metav1.Time `json:"lastConnectorCheckTime,omitempty"`
+
 	// +listType=map
 	// +listMapKey=type
 	// +optional
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
+// TunnelConnectorStatus describes a cloudflared connector registered to the tunnel.
+type TunnelConnectorStatus struct {
+	// ID is the ID of the connector, which is assigned by cloudflared on startup.
+	ID string `json:"id"`
+
+	// Version is the version of cloudflared.
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// Colos are the Cloudflare data centers that the connector is connected to.
+	// +optional
+	Colos []string `json:"colos,omitempty"`
+
+	// OriginIPs are the IP addresses that the connector connects to the Cloudflare edge from.
+	// +optional
+	OriginIPs []string `json:"originIPs,omitempty"`
+
+	// Connections is the number of active connections of the connector to the Cloudflare edge.
+	Connections int32 `json:"connections"`
+}
+
 const (
+	// TypeCloudflareTunnelAvailable is True when the tunnel exists and at least one cloudflared Pod is available.
 	TypeCloudflareTunnelAvailable = "Available"
-	TypeCloudflareTunnelDegraded  = "Degraded"
+	// TypeCloudflareTunnelDegraded is True when one of TunnelReady, SecretReady, DeploymentReady and DNSReady is False.
+	TypeCloudflareTunnelDegraded = "Degraded"
+	// TypeCloudflareTunnelTunnelReady is True when the tunnel exists in Cloudflare and its token has been fetched.
+	TypeCloudflareTunnelTunnelReady = "TunnelReady"
+	// TypeCloudflareTunnelSecretReady is True when the Secret holding the tunnel token is up to date.
+	TypeCloudflareTunnelSecretReady = "SecretReady"
+	// TypeCloudflareTunnelDeploymentReady is True when every replica of the cloudflared Deployment is available,
+	// and its Service, ServiceMonitor and PodDisruptionBudget are up to date.
+	TypeCloudflareTunnelDeploymentReady = "DeploymentReady"
+	// TypeCloudflareTunnelDNSReady is True when the managed rules and DNS records were synchronized with Cloudflare at the last resync.
+	TypeCloudflareTunnelDNSReady = "DNSReady"
+	// TypeCloudflareTunnelRecovered is False while the rules and DNS records are re-published to a tunnel
+	// that replaced the one deleted outside of the operator, and True after that.
+	TypeCloudflareTunnelRecovered = "Recovered"
+	// TypeCloudflareTunnelConnected is True when every replica of cloudflared has active connections to the Cloudflare edge.
+	TypeCloudflareTunnelConnected = "Connected"
 )
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 // +kubebuilder:printcolumn:name="DEFAULT",type="boolean",JSONPath=".spec.default",description="Default Tunnel"
 // +kubebuilder:printcolumn:name="REPLICAS",type="string",JSONPath=".spec.replicas",description="Replica Count"
+// +kubebuilder:printcolumn:name="CONNECTED",type="integer",JSONPath=".status.connectedReplicas",description="Replicas connected to the Cloudflare edge"
+// +kubebuilder:printcolumn:name="AVAILABLE",type="string",JSONPath=".status.conditions[?(@.type==\"Available\")].status"
+// +kubebuilder:printcolumn:name="REASON",type="string",JSONPath=".status.conditions[?(@.type==\"Degraded\")].reason",priority=1
 // +kubebuilder:printcolumn:name="AGE",type="date",JSONPath=".metadata.creationTimestamp"
 
 // CloudflareTunnel is the Schema for the cloudflaretunnels API.
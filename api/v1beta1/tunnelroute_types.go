@@ -0,0 +1,163 @@
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TunnelRouteSpec defines the desired state of TunnelRoute.
+type TunnelRouteSpec struct {
+	// TunnelRef is the CloudflareTunnel that the route is added to.
+	// If it is not set, the default CloudflareTunnel is used.
+	// +optional
+	TunnelRef *TunnelReference `json:"tunnelRef,omitempty"`
+
+	// Hostname is the public hostname of the route. A DNS record is created for it.
+	// +kubebuilder:validation:MinLength=1
+	Hostname string `json:"hostname"`
+
+	// Path is a regular expression matched against the request path.
+	// If it is empty, every path of the hostname matches.
+	// +optional
+	Path string `json:"path,omitempty"`
+
+	// Service is the origin service URL, e.g. "http://web.default.svc:80", "ssh://bastion.default.svc:22" or "unix:/run/web.sock".
+	// +kubebuilder:validation:MinLength=1
+	Service string `json:"service"`
+
+	// OriginRequest overrides the origin settings of the CloudflareTunnel for this route.
+	// +optional
+	OriginRequest *OriginRequest `json:"originRequest,omitempty"`
+}
+
+type TunnelReference struct {
+	Name string `json:"name"`
+
+	// Namespace of the CloudflareTunnel. Defaults to the namespace of the TunnelRoute.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// OriginRequest holds per-rule overrides of the origin settings in CloudflareTunnelSettings.
+// Fields that are not set fall back to the CloudflareTunnel settings.
+type OriginRequest struct {
+	// Sets the HTTP Host header on requests sent to the origin. Defaults to the hostname of the rule.
+	// +optional
+	HTTPHostHeader *string `json:"httpHostHeader,omitempty"`
+
+	// Hostname that cloudflared should expect from your origin server certificate. Defaults to the hostname of the rule.
+	// +optional
+	OriginServerName *string `json:"originServerName,omitempty"`
+
+	// +optional
+	CAPool *string `json:"caPool,omitempty"`
+
+	// +optional
+	NoTLSVerify *bool `json:"noTLSVerify,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	TLSTimeoutSeconds *int32 `json:"tlsTimeoutSeconds,omitempty"`
+
+	// +optional
+	HTTP2Origin *bool `json:"http2Origin,omitempty"`
+
+	// +optional
+	DisableChunkedEncoding *bool `json:"disableChunkedEncoding,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	ConnectTimeoutSeconds *int32 `json:"connectTimeoutSeconds,omitempty"`
+
+	// +optional
+	NoHappyEyeballs *bool `json:"noHappyEyeballs,omitempty"`
+
+	// +optional
+	ProxyType *string `json:"proxyType,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	KeepAliveTimeoutSeconds *int32 `json:"keepAliveTimeoutSeconds,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	KeepAliveConnections *int32 `json:"keepAliveConnections,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	TCPKeepAliveSeconds *int32 `json:"tcpKeepAliveSeconds,omitempty"`
+
+	// Keeps the Host header of the request. httpHostHeader takes precedence over it.
+	// +optional
+	PreserveHostHeader *bool `json:"preserveHostHeader,omitempty"`
+
+	// +optional
+	BastionMode *bool `json:"bastionMode,omitempty"`
+
+	// +optional
+	ProxyAddress *string `json:"proxyAddress,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	ProxyPort *int32 `json:"proxyPort,omitempty"`
+
+	// Replaces the ipRules of the CloudflareTunnel settings if it is set.
+	// +optional
+	IPRules []IPRule `json:"ipRules,omitempty"`
+
+	// Replaces the access settings of the CloudflareTunnel if it is set.
+	// +optional
+	Access *AccessConfig `json:"access,omitempty"`
+}
+
+// TunnelRouteStatus defines the observed state of TunnelRoute.
+type TunnelRouteStatus struct {
+	// TunnelID is the ID of the Cloudflare Tunnel that the route was added to.
+	// +optional
+	TunnelID string `json:"tunnelID,omitempty"`
+
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+const (
+	// TypeTunnelRouteReady means that the rule is present in the tunnel configuration.
+	TypeTunnelRouteReady = "Ready"
+	// TypeTunnelRouteDNSHealthy means that the DNS record of the hostname points to the tunnel.
+	TypeTunnelRouteDNSHealthy = "DNSHealthy"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="HOSTNAME",type="string",JSONPath=".spec.hostname"
+// +kubebuilder:printcolumn:name="SERVICE",type="string",JSONPath=".spec.service"
+// +kubebuilder:printcolumn:name="READY",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+// +kubebuilder:printcolumn:name="DNS",type="string",JSONPath=".status.conditions[?(@.type==\"DNSHealthy\")].status"
+// +kubebuilder:printcolumn:name="AGE",type="date",JSONPath=".metadata.creationTimestamp"
+
+// TunnelRoute is the Schema for the tunnelroutes API.
+type TunnelRoute struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TunnelRouteSpec   `json:"spec,omitempty"`
+	Status TunnelRouteStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// TunnelRouteList contains a list of TunnelRoute.
+type TunnelRouteList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []TunnelRoute `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&TunnelRoute{}, &TunnelRouteList{})
+}
@@ -11,6 +11,26 @@ import (
 	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AccessConfig) DeepCopyInto(out *AccessConfig) {
+	*out = *in
+	if in.AudTag != nil {
+		in, out := &in.AudTag, &out.AudTag
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AccessConfig.
+func (in *AccessConfig) DeepCopy() *AccessConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(AccessConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AffinityApplyConfiguration) DeepCopyInto(out *AffinityApplyConfiguration) {
 	clone := in.DeepCopy()
@@ -84,6 +104,18 @@ func (in *CloudflareTunnelSettings) DeepCopyInto(out *CloudflareTunnelSettings)
 		*out = new(string)
 		**out = **in
 	}
+	if in.IPRules != nil {
+		in, out := &in.IPRules, &out.IPRules
+		*out = make([]IPRule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Access != nil {
+		in, out := &in.Access, &out.Access
+		*out = new(AccessConfig)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CloudflareTunnelSettings.
@@ -130,6 +162,21 @@ func (in *CloudflareTunnelSpec) DeepCopyInto(out *CloudflareTunnelSpec) {
 		(*in).DeepCopyInto(*out)
 	}
 	in.Settings.DeepCopyInto(&out.Settings)
+	if in.CredentialsRef != nil {
+		in, out := &in.CredentialsRef, &out.CredentialsRef
+		*out = new(v1.LocalObjectReference)
+		**out = **in
+	}
+	if in.Zones != nil {
+		in, out := &in.Zones, &out.Zones
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.TokenRotation != nil {
+		in, out := &in.TokenRotation, &out.TokenRotation
+		*out = new(TokenRotation)
+		**out = **in
+	}
 	if in.PodSecurityContext != nil {
 		in, out := &in.PodSecurityContext, &out.PodSecurityContext
 		*out = (*in).DeepCopy()
@@ -153,6 +200,30 @@ func (in *CloudflareTunnelSpec) DeepCopy() *CloudflareTunnelSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *CloudflareTunnelStatus) DeepCopyInto(out *CloudflareTunnelStatus) {
 	*out = *in
+	if in.LastSyncTime != nil {
+		in, out := &in.LastSyncTime, &out.LastSyncTime
+		*out = (*in).DeepCopy()
+	}
+	if in.LastTokenRotationTime != nil {
+		in, out := &in.LastTokenRotationTime, &out.LastTokenRotationTime
+		*out = (*in).DeepCopy()
+	}
+	if in.PendingTokenRotation != nil {
+		in, out := &in.PendingTokenRotation, &out.PendingTokenRotation
+		*out = new(PendingTokenRotation)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Connectors != nil {
+		in, out := &in.Connectors, &out.Connectors
+		*out = make([]TunnelConnectorStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.LastConnectorCheckTime != nil {
+		in, out := &in.LastConnectorCheckTime, &out.LastConnectorCheckTime
+		*out = (*in).DeepCopy()
+	}
 	if in.Conditions != nil {
 		in, out := &in.Conditions, &out.Conditions
 		*out = make([]metav1.Condition, len(*in))
@@ -181,6 +252,138 @@ func (in EnvVarApplyConfigurationList) DeepCopyInto(out *EnvVarApplyConfiguratio
 	}
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IPRule) DeepCopyInto(out *IPRule) {
+	*out = *in
+	if in.Ports != nil {
+		in, out := &in.Ports, &out.Ports
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPRule.
+func (in *IPRule) DeepCopy() *IPRule {
+	if in == nil {
+		return nil
+	}
+	out := new(IPRule)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OriginRequest) DeepCopyInto(out *OriginRequest) {
+	*out = *in
+	if in.HTTPHostHeader != nil {
+		in, out := &in.HTTPHostHeader, &out.HTTPHostHeader
+		*out = new(string)
+		**out = **in
+	}
+	if in.OriginServerName != nil {
+		in, out := &in.OriginServerName, &out.OriginServerName
+		*out = new(string)
+		**out = **in
+	}
+	if in.CAPool != nil {
+		in, out := &in.CAPool, &out.CAPool
+		*out = new(string)
+		**out = **in
+	}
+	if in.NoTLSVerify != nil {
+		in, out := &in.NoTLSVerify, &out.NoTLSVerify
+		*out = new(bool)
+		**out = **in
+	}
+	if in.TLSTimeoutSeconds != nil {
+		in, out := &in.TLSTimeoutSeconds, &out.TLSTimeoutSeconds
+		*out = new(int32)
+		**out = **in
+	}
+	if in.HTTP2Origin != nil {
+		in, out := &in.HTTP2Origin, &out.HTTP2Origin
+		*out = new(bool)
+		**out = **in
+	}
+	if in.DisableChunkedEncoding != nil {
+		in, out := &in.DisableChunkedEncoding, &out.DisableChunkedEncoding
+		*out = new(bool)
+		**out = **in
+	}
+	if in.ConnectTimeoutSeconds != nil {
+		in, out := &in.ConnectTimeoutSeconds, &out.ConnectTimeoutSeconds
+		*out = new(int32)
+		**out = **in
+	}
+	if in.NoHappyEyeballs != nil {
+		in, out := &in.NoHappyEyeballs, &out.NoHappyEyeballs
+		*out = new(bool)
+		**out = **in
+	}
+	if in.ProxyType != nil {
+		in, out := &in.ProxyType, &out.ProxyType
+		*out = new(string)
+		**out = **in
+	}
+	if in.KeepAliveTimeoutSeconds != nil {
+		in, out := &in.KeepAliveTimeoutSeconds, &out.KeepAliveTimeoutSeconds
+		*out = new(int32)
+		**out = **in
+	}
+	if in.KeepAliveConnections != nil {
+		in, out := &in.KeepAliveConnections, &out.KeepAliveConnections
+		*out = new(int32)
+		**out = **in
+	}
+	if in.TCPKeepAliveSeconds != nil {
+		in, out := &in.TCPKeepAliveSeconds, &out.TCPKeepAliveSeconds
+		*out = new(int32)
+		**out = **in
+	}
+	if in.PreserveHostHeader != nil {
+		in, out := &in.PreserveHostHeader, &out.PreserveHostHeader
+		*out = new(bool)
+		**out = **in
+	}
+	if in.BastionMode != nil {
+		in, out := &in.BastionMode, &out.BastionMode
+		*out = new(bool)
+		**out = **in
+	}
+	if in.ProxyAddress != nil {
+		in, out := &in.ProxyAddress, &out.ProxyAddress
+		*out = new(string)
+		**out = **in
+	}
+	if in.ProxyPort != nil {
+		in, out := &in.ProxyPort, &out.ProxyPort
+		*out = new(int32)
+		**out = **in
+	}
+	if in.IPRules != nil {
+		in, out := &in.IPRules, &out.IPRules
+		*out = make([]IPRule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Access != nil {
+		in, out := &in.Access, &out.Access
+		*out = new(AccessConfig)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OriginRequest.
+func (in *OriginRequest) DeepCopy() *OriginRequest {
+	if in == nil {
+		return nil
+	}
+	out := new(OriginRequest)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PDBSpec) DeepCopyInto(out *PDBSpec) {
 	*out = *in
@@ -206,6 +409,22 @@ func (in *PDBSpec) DeepCopy() *PDBSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PendingTokenRotation) DeepCopyInto(out *PendingTokenRotation) {
+	*out = *in
+	in.RequestedTime.DeepCopyInto(&out.RequestedTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PendingTokenRotation.
+func (in *PendingTokenRotation) DeepCopy() *PendingTokenRotation {
+	if in == nil {
+		return nil
+	}
+	out := new(PendingTokenRotation)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PodSecurityContextApplyConfiguration) DeepCopyInto(out *PodSecurityContextApplyConfiguration) {
 	clone := in.DeepCopy()
@@ -218,6 +437,22 @@ func (in *SecurityContextApplyConfiguration) DeepCopyInto(out *SecurityContextAp
 	*out = *clone
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TokenRotation) DeepCopyInto(out *TokenRotation) {
+	*out = *in
+	out.Interval = in.Interval
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TokenRotation.
+func (in *TokenRotation) DeepCopy() *TokenRotation {
+	if in == nil {
+		return nil
+	}
+	out := new(TokenRotation)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in TolerationApplyConfigurationList) DeepCopyInto(out *TolerationApplyConfigurationList) {
 	{
@@ -235,3 +470,149 @@ func (in TopologySpreadConstraintApplyConfigurationList) DeepCopyInto(out *Topol
 		*out = *clone
 	}
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TunnelConnectorStatus) DeepCopyInto(out *TunnelConnectorStatus) {
+	*out = *in
+	if in.Colos != nil {
+		in, out := &in.Colos, &out.Colos
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.OriginIPs != nil {
+		in, out := &in.OriginIPs, &out.OriginIPs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TunnelConnectorStatus.
+func (in *TunnelConnectorStatus) DeepCopy() *TunnelConnectorStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(TunnelConnectorStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TunnelReference) DeepCopyInto(out *TunnelReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TunnelReference.
+func (in *TunnelReference) DeepCopy() *TunnelReference {
+	if in == nil {
+		return nil
+	}
+	out := new(TunnelReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TunnelRoute) DeepCopyInto(out *TunnelRoute) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TunnelRoute.
+func (in *TunnelRoute) DeepCopy() *TunnelRoute {
+	if in == nil {
+		return nil
+	}
+	out := new(TunnelRoute)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TunnelRoute) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TunnelRouteList) DeepCopyInto(out *TunnelRouteList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]TunnelRoute, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TunnelRouteList.
+func (in *TunnelRouteList) DeepCopy() *TunnelRouteList {
+	if in == nil {
+		return nil
+	}
+	out := new(TunnelRouteList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TunnelRouteList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TunnelRouteSpec) DeepCopyInto(out *TunnelRouteSpec) {
+	*out = *in
+	if in.TunnelRef != nil {
+		in, out := &in.TunnelRef, &out.TunnelRef
+		*out = new(TunnelReference)
+		**out = **in
+	}
+	if in.OriginRequest != nil {
+		in, out := &in.OriginRequest, &out.OriginRequest
+		*out = new(OriginRequest)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TunnelRouteSpec.
+func (in *TunnelRouteSpec) DeepCopy() *TunnelRouteSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TunnelRouteSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TunnelRouteStatus) DeepCopyInto(out *TunnelRouteStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TunnelRouteStatus.
+func (in *TunnelRouteStatus) DeepCopy() *TunnelRouteStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(TunnelRouteStatus)
+	in.DeepCopyInto(out)
+	return out
+}
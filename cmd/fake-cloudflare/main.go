@@ -0,0 +1,51 @@
+// Command fake-cloudflare serves the fake Cloudflare API, so that the operator can be run locally without a Cloudflare account.
+//
+//	go run ./cmd/fake-cloudflare --zone example.com
+//	CLOUDFLARE_API_BASE_URL=http://localhost:8787 CLOUDFLARE_API_TOKEN=fake CLOUDFLARE_ACCOUNT_ID=fake-account make run
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/external/fake"
+)
+
+func main() {
+	var addr string
+	var accountID string
+	var zones string
+	flag.StringVar(&addr, "addr", ":8787", "The address the fake API binds to.")
+	flag.StringVar(&accountID, "account-id", "fake-account", "The account ID that the fake API accepts.")
+	flag.StringVar(&zones, "zone", "example.com", "Comma-separated names of the zones that the fake API serves.")
+	flag.Parse()
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		slog.Error("failed to listen", "addr", addr, "error", err)
+		os.Exit(1)
+	}
+
+	server := fake.NewUnstartedServer(accountID)
+	server.Listener.Close()
+	server.Listener = listener
+	for zone := range strings.SplitSeq(zones, ",") {
+		if zone = strings.TrimSpace(zone); zone != "" {
+			z := server.AddZone(zone)
+			slog.Info("added zone", "name", z.Name, "id", z.ID)
+		}
+	}
+	server.Start()
+	defer server.Close()
+	slog.Info("serving fake Cloudflare API", "url", server.URL, "accountID", accountID)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+}
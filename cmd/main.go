@@ -6,6 +6,7 @@ import (
 	"log/slog"
 	"os"
 	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -19,18 +20,23 @@ import (
 	cftunneloperatorv1beta1 "github.com/walnuts1018/cloudflare-tunnel-operator/api/v1beta1"
 	"github.com/walnuts1018/cloudflare-tunnel-operator/internal/controller"
 	webhookcftunneloperatorv1beta1 "github.com/walnuts1018/cloudflare-tunnel-operator/internal/webhook/v1beta1"
+	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/domain"
 	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/external"
 	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/utils/random"
+	corev1 "k8s.io/api/core/v1"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/klog/v2"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/metrics/filters"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+	gatewayv1beta1 "sigs.k8s.io/gateway-api/apis/v1beta1"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -44,14 +50,21 @@ func init() {
 	utilruntime.Must(cftunneloperatorv1beta1.AddToScheme(scheme))
 	utilruntime.Must(monitoringv1.AddToScheme(scheme))
 	utilruntime.Must(apiextensionsv1.AddToScheme(scheme))
+	utilruntime.Must(gatewayv1.Install(scheme))
+	utilruntime.Must(gatewayv1beta1.Install(scheme))
 	// +kubebuilder:scaffold:scheme
 }
 
 type Config struct {
-	CloudflareAPIToken  string `env:"CLOUDFLARE_API_TOKEN,required"`
-	CloudflareAccountID string `env:"CLOUDFLARE_ACCOUNT_ID,required"`
-	CloudflareZoneID    string `env:"CLOUDFLARE_ZONE_ID,required"`
-	EnableWebhooks      bool   `env:"ENABLE_WEBHOOKS" envDefault:"true"`
+	CloudflareAPIToken            string        `env:"CLOUDFLARE_API_TOKEN,required"`
+	CloudflareAccountID           string        `env:"CLOUDFLARE_ACCOUNT_ID,required"`
+	CloudflareAPIBaseURL          string        `env:"CLOUDFLARE_API_BASE_URL"`
+	CloudflareZoneRefreshInterval time.Duration `env:"CLOUDFLARE_ZONE_REFRESH_INTERVAL" envDefault:"10m"`
+	CloudflareAPIRateLimit        float64       `env:"CLOUDFLARE_API_RATE_LIMIT" envDefault:"4"`
+	ResyncInterval                time.Duration `env:"RESYNC_INTERVAL" envDefault:"10m"`
+	ConnectorCheckInterval        time.Duration `env:"CONNECTOR_CHECK_INTERVAL" envDefault:"1m"`
+	MaxConcurrentReconciles       int           `env:"MAX_CONCURRENT_RECONCILES" envDefault:"4"`
+	EnableWebhooks                bool          `env:"ENABLE_WEBHOOKS" envDefault:"true"`
 }
 
 func main() {
@@ -138,6 +151,12 @@ func main() {
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "c770d844.cf-tunnel-operator.walnuts.dev",
+		// 所有記録のConfigMapしか読まないので、クラスタ全体のConfigMapをキャッシュせずAPIサーバーから直接読む
+		Client: client.Options{
+			Cache: &client.CacheOptions{
+				DisableFor: []client.Object{&corev1.ConfigMap{}},
+			},
+		},
 		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
 		// when the Manager ends. This requires the binary to immediately end when the
 		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
@@ -155,16 +174,25 @@ func main() {
 		os.Exit(1)
 	}
 
-	cfManager, err := external.NewCloudflareTunnelClient(cfg.CloudflareAPIToken, cfg.CloudflareAccountID, cfg.CloudflareZoneID, random.NewSecure())
+	cfManager, err := external.NewCloudflareTunnelClient(cfg.CloudflareAPIToken, cfg.CloudflareAccountID, cfg.CloudflareAPIBaseURL, cfg.CloudflareZoneRefreshInterval, cfg.CloudflareAPIRateLimit, random.NewSecure())
 	if err != nil {
 		setupLog.Error(err, "unable to create Cloudflare Tunnel client")
 		os.Exit(1)
 	}
 
+	credentials := controller.NewCloudflareCredentialsCache(func(c domain.CloudflareCredentials) (controller.CloudflareTunnelManager, error) {
+		return external.NewCloudflareTunnelClient(c.APIToken, c.AccountID, cfg.CloudflareAPIBaseURL, cfg.CloudflareZoneRefreshInterval, cfg.CloudflareAPIRateLimit, random.NewSecure())
+	})
+
 	if err = (&controller.CloudflareTunnelReconciler{
 		Client:                  mgr.GetClient(),
 		Scheme:                  mgr.GetScheme(),
 		CloudflareTunnelManager: cfManager,
+		Credentials:             credentials,
+		Recorder:                mgr.GetEventRecorderFor("cloudflaretunnel-controller"),
+		ResyncInterval:          cfg.ResyncInterval,
+		ConnectorCheckInterval:  cfg.ConnectorCheckInterval,
+		MaxConcurrentReconciles: cfg.MaxConcurrentReconciles,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "CloudflareTunnel")
 		os.Exit(1)
@@ -173,10 +201,44 @@ func main() {
 		Client:                  mgr.GetClient(),
 		Scheme:                  mgr.GetScheme(),
 		CloudflareTunnelManager: cfManager,
+		Credentials:             credentials,
+		Recorder:                mgr.GetEventRecorderFor("ingress-controller"),
+		MaxConcurrentReconciles: cfg.MaxConcurrentReconciles,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Ingress")
 		os.Exit(1)
 	}
+	if err = (&controller.HTTPRouteReconciler{
+		Client:                  mgr.GetClient(),
+		Scheme:                  mgr.GetScheme(),
+		CloudflareTunnelManager: cfManager,
+		Credentials:             credentials,
+		Recorder:                mgr.GetEventRecorderFor("httproute-controller"),
+		MaxConcurrentReconciles: cfg.MaxConcurrentReconciles,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "HTTPRoute")
+		os.Exit(1)
+	}
+	if err = (&controller.ServiceReconciler{
+		Client:                  mgr.GetClient(),
+		Scheme:                  mgr.GetScheme(),
+		CloudflareTunnelManager: cfManager,
+		Credentials:             credentials,
+		MaxConcurrentReconciles: cfg.MaxConcurrentReconciles,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Service")
+		os.Exit(1)
+	}
+	if err = (&controller.TunnelRouteReconciler{
+		Client:                  mgr.GetClient(),
+		Scheme:                  mgr.GetScheme(),
+		CloudflareTunnelManager: cfManager,
+		Credentials:             credentials,
+		MaxConcurrentReconciles: cfg.MaxConcurrentReconciles,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "TunnelRoute")
+		os.Exit(1)
+	}
 	if cfg.EnableWebhooks {
 		if err = webhookcftunneloperatorv1beta1.SetupCloudflareTunnelWebhookWithManager(mgr); err != nil {
 			setupLog.Error(err, "unable to create webhook", "webhook", "CloudflareTunnel")
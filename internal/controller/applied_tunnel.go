@@ -0,0 +1,124 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	cftv1beta1 "github.com/walnuts1018/cloudflare-tunnel-operator/api/v1beta1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// appliedTunnelAnnotation records the CloudflareTunnel that the rules of the resource were last added to, as namespace/name.
+// The rules are removed from it when the resource is moved to another tunnel.
+const appliedTunnelAnnotation = annotationPrefix + "applied-tunnel"
+
+// releaseTunnelRules removes every rule owned by owner from the tunnel, and the DNS records of their hostnames.
+func releaseTunnelRules(
+	ctx context.Context,
+	c client.Client,
+	manager CloudflareTunnelManager,
+	cfTunnel cftv1beta1.CloudflareTunnel,
+	zones []string,
+	owner string,
+) error {
+	owned, err := tunnelConfigWriterFor(cfTunnel.Status.TunnelID).ownedRules(ctx, c, cfTunnel)
+	if err != nil {
+		return err
+	}
+
+	var rules []ingressRule
+	for _, rule := range owned {
+		if rule.Owner == owner {
+			rules = append(rules, ingressRule{Hostname: rule.Hostname, Path: rule.Path})
+		}
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	return removeTunnelRules(ctx, c, manager, cfTunnel, zones, owner, rules)
+}
+
+// releasePreviousTunnel removes the rules owned by owner from the tunnel in the applied-tunnel annotation of obj,
+// unless it is current. If current is nil, the rules are removed from the annotated tunnel in any case.
+func releasePreviousTunnel(
+	ctx context.Context,
+	c client.Client,
+	defaultManager CloudflareTunnelManager,
+	credentials *CloudflareCredentialsCache,
+	obj client.Object,
+	owner string,
+	current *cftv1beta1.CloudflareTunnel,
+) error {
+	logger := log.FromContext(ctx)
+
+	v := obj.GetAnnotations()[appliedTunnelAnnotation]
+	if v == "" {
+		return nil
+	}
+
+	namespace, name, ok := strings.Cut(v, "/")
+	if !ok {
+		logger.Info("ignore invalid applied tunnel annotation", "value", v)
+		return nil
+	}
+
+	previousName := types.NamespacedName{Namespace: namespace, Name: name}
+	if current != nil && previousName == client.ObjectKeyFromObject(current) {
+		return nil
+	}
+
+	previous, err := getCloudflareTunnel(ctx, c, previousName)
+	if err != nil {
+		if errors.Is(err, ErrCloudflareTunnelNotFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to get previous Cloudflare Tunnel: %w", err)
+	}
+
+	// CloudflareTunnelリソースの削除時にトンネルやレコードが削除されるので、何もしない
+	if !previous.DeletionTimestamp.IsZero() || previous.Status.TunnelID == "" {
+		return nil
+	}
+
+	manager, zones, err := cloudflareTunnelManagerFor(ctx, c, defaultManager, credentials, previous)
+	if err != nil {
+		return fmt.Errorf("failed to get Cloudflare Tunnel client: %w", err)
+	}
+
+	logger.Info("removing rules from the previous Cloudflare Tunnel", "tunnel", previousName)
+	return releaseTunnelRules(ctx, c, manager, previous, zones, owner)
+}
+
+// recordAppliedTunnel sets the applied-tunnel annotation of obj to cfTunnel, or removes it if cfTunnel is nil.
+func recordAppliedTunnel(ctx context.Context, c client.Client, obj client.Object, cfTunnel *cftv1beta1.CloudflareTunnel) error {
+	annotations := obj.GetAnnotations()
+
+	var value string
+	if cfTunnel != nil {
+		value = cfTunnel.Namespace + "/" + cfTunnel.Name
+	}
+	if annotations[appliedTunnelAnnotation] == value {
+		return nil
+	}
+
+	patch := client.MergeFrom(obj.DeepCopyObject().(client.Object))
+	if value == "" {
+		delete(annotations, appliedTunnelAnnotation)
+	} else {
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[appliedTunnelAnnotation] = value
+	}
+	obj.SetAnnotations(annotations)
+
+	if err := c.Patch(ctx, obj, patch); err != nil {
+		return fmt.Errorf("failed to record applied tunnel: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/cloudflare/cloudflare-go"
+	cftv1beta1 "github.com/walnuts1018/cloudflare-tunnel-operator/api/v1beta1"
+	mock_controller "github.com/walnuts1018/cloudflare-tunnel-operator/internal/controller/mock"
+	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/domain"
+	"go.uber.org/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_releasePreviousTunnel(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	if err := cftv1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := networkingv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	previous := &cftv1beta1.CloudflareTunnel{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "previous", UID: "previous-uid"},
+		Spec: cftv1beta1.CloudflareTunnelSpec{
+			Settings: cftv1beta1.CloudflareTunnelSettings{CatchAllRule: "http_status:404"},
+		},
+		Status: cftv1beta1.CloudflareTunnelStatus{TunnelID: "previous-id"},
+	}
+	current := &cftv1beta1.CloudflareTunnel{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "current", UID: "current-uid"},
+		Status:     cftv1beta1.CloudflareTunnelStatus{TunnelID: "current-id"},
+	}
+
+	rules, err := json.Marshal([]managedRule{
+		{Hostname: "web.walnuts.dev", Service: "http://web:80", Owner: "Ingress/default/web"},
+		{Hostname: "api.walnuts.dev", Service: "http://api:80", Owner: "Ingress/default/api"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "previous-managed-rules"},
+		Data:       map[string]string{managedRulesKey: string(rules)},
+	}
+
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "default",
+			Name:        "web",
+			Annotations: map[string]string{appliedTunnelAnnotation: "default/previous"},
+		},
+	}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(previous, current, record, ingress).Build()
+
+	gomockctrl := gomock.NewController(t)
+	manager := mock_controller.NewMockCloudflareTunnelManager(gomockctrl)
+	manager.EXPECT().GetTunnelConfiguration(gomock.Any(), "previous-id").Return(domain.TunnelConfiguration{
+		Ingress: []cloudflare.UnvalidatedIngressRule{
+			{Hostname: "api.walnuts.dev", Service: "http://api:80"},
+			{Hostname: "web.walnuts.dev", Service: "http://web:80"},
+			{Service: "http_status:404"},
+		},
+	}, nil)
+	manager.EXPECT().UpdateTunnelConfiguration(gomock.Any(), "previous-id", gomock.Any()).DoAndReturn(func(_ context.Context, _ string, config domain.TunnelConfiguration) (domain.TunnelConfiguration, error) {
+		if len(config.Ingress) != 2 || config.Ingress[0].Hostname != "api.walnuts.dev" {
+			t.Errorf("UpdateTunnelConfiguration() ingress = %+v, want only api.walnuts.dev and the catch-all rule", config.Ingress)
+		}
+		return config, nil
+	})
+	manager.EXPECT().ResolveZone(gomock.Any(), "web.walnuts.dev", gomock.Any()).Return(domain.Zone{ID: "zone-id"}, nil)
+	manager.EXPECT().GetDNS(gomock.Any(), "zone-id", "previous-id", "web.walnuts.dev").Return(domain.DNSRecord{
+		ID:      "record-id",
+		Type:    "CNAME",
+		Content: "previous-id.cfargotunnel.com",
+		Proxied: ptr.To(true),
+	}, nil)
+	manager.EXPECT().DeleteDNS(gomock.Any(), "zone-id", "record-id").Return(nil)
+
+	if err := releasePreviousTunnel(ctx, c, manager, nil, ingress, ruleOwner("Ingress", ingress), current); err != nil {
+		t.Fatalf("releasePreviousTunnel() error = %v", err)
+	}
+
+	if err := recordAppliedTunnel(ctx, c, ingress, current); err != nil {
+		t.Fatalf("recordAppliedTunnel() error = %v", err)
+	}
+
+	var got networkingv1.Ingress
+	if err := c.Get(ctx, client.ObjectKeyFromObject(ingress), &got); err != nil {
+		t.Fatal(err)
+	}
+	if v := got.Annotations[appliedTunnelAnnotation]; v != "default/current" {
+		t.Errorf("applied tunnel annotation = %v, want default/current", v)
+	}
+
+	// 同じトンネルなら何もしない
+	if err := releasePreviousTunnel(ctx, c, manager, nil, &got, ruleOwner("Ingress", &got), current); err != nil {
+		t.Fatalf("releasePreviousTunnel() error = %v", err)
+	}
+}
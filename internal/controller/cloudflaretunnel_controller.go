@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log/slog"
 	"strconv"
+	"time"
 
 	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
 	cftv1beta1 "github.com/walnuts1018/cloudflare-tunnel-operator/api/v1beta1"
@@ -26,11 +27,14 @@ import (
 	appsv1apply "k8s.io/client-go/applyconfigurations/apps/v1"
 	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
 	metav1apply "k8s.io/client-go/applyconfigurations/meta/v1"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/utils/ptr"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
@@ -42,6 +46,9 @@ const (
 	finalizerName  = "cf-tunnel-operator.walnuts.dev/finalizer"
 
 	cloudflaredImage = "cloudflare/cloudflared:2025.11.1"
+
+	// tunnelIDAnnotation is set on the cloudflared Pods to the ID of the tunnel that they connect to.
+	tunnelIDAnnotation = annotationPrefix + "tunnel-id"
 )
 
 // CloudflareTunnelReconciler reconciles a CloudflareTunnel object
@@ -49,6 +56,16 @@ type CloudflareTunnelReconciler struct {
 	client.Client
 	Scheme                  *runtime.Scheme
 	CloudflareTunnelManager CloudflareTunnelManager
+	Credentials             *CloudflareCredentialsCache
+	Recorder                record.EventRecorder
+	// ResyncInterval is the interval at which the tunnel ingress rules and DNS records are compared with Cloudflare and repaired.
+	// Zero disables the resync.
+	ResyncInterval time.Duration
+	// ConnectorCheckInterval is the interval at which the connectors of the tunnel are fetched from Cloudflare to update the Connected condition.
+	// Zero disables the check.
+	ConnectorCheckInterval time.Duration
+	// MaxConcurrentReconciles is the number of CloudflareTunnels reconciled in parallel. The default is 1.
+	MaxConcurrentReconciles int
 }
 
 // +kubebuilder:rbac:groups=cf-tunnel-operator.walnuts.dev,resources=cloudflaretunnels,verbs=get;list;watch;create;update;patch;delete
@@ -89,23 +106,14 @@ func (r *CloudflareTunnelReconciler) Reconcile(ctx context.Context, req ctrl.Req
 		return ctrl.Result{}, err
 	}
 
+	// 認証情報のSecretが先に消えていても削除できるよう、認証情報を解決する前に削除を処理する
 	if !cfTunnel.DeletionTimestamp.IsZero() {
-		if controllerutil.ContainsFinalizer(&cfTunnel, finalizerName) {
-			if err := r.CloudflareTunnelManager.DeleteAllDNS(ctx, cfTunnel.Status.TunnelID); err != nil {
-				return ctrl.Result{}, fmt.Errorf("failed to delete CloudflareTunnel: %w", err)
-			}
-
-			if err := r.CloudflareTunnelManager.DeleteTunnel(ctx, cfTunnel.Status.TunnelID); err != nil {
-				return ctrl.Result{}, fmt.Errorf("failed to delete Cloudflare Tunnel: %w", err)
-			}
+		return ctrl.Result{}, r.reconcileDelete(ctx, cfTunnel)
+	}
 
-			controllerutil.RemoveFinalizer(&cfTunnel, finalizerName)
-			err = r.Update(ctx, &cfTunnel)
-			if err != nil {
-				return ctrl.Result{}, err
-			}
-		}
-		return ctrl.Result{}, nil
+	manager, zones, err := cloudflareTunnelManagerFor(ctx, r.Client, r.CloudflareTunnelManager, r.Credentials, cfTunnel)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to get Cloudflare Tunnel client: %w", err)
 	}
 
 	if !controllerutil.ContainsFinalizer(&cfTunnel, finalizerName) {
@@ -116,22 +124,51 @@ func (r *CloudflareTunnelReconciler) Reconcile(ctx context.Context, req ctrl.Req
 		}
 	}
 
-	tunnel, token, err := r.reconcileTunnel(ctx, cfTunnel)
+	tunnel, token, err := r.reconcileTunnel(ctx, manager, cfTunnel)
+	if errors.Is(err, domain.ErrTunnelNotFound) && cfTunnel.Spec.TunnelID != "" {
+		// 指定されたトンネルは戻ってこないので再試行しない。spec.tunnelIDを外せば作り直される
+		log.FromContext(ctx).Error(err, "Cloudflare Tunnel specified by spec.tunnelID does not exist.", "tunnelID", cfTunnel.Spec.TunnelID)
+		r.Recorder.Eventf(&cfTunnel, corev1.EventTypeWarning, "TunnelNotFound",
+			"Cloudflare Tunnel %s specified by spec.tunnelID has been deleted. Remove spec.tunnelID to create a new tunnel.", cfTunnel.Spec.TunnelID)
+		setCondition(&cfTunnel, cftv1beta1.TypeCloudflareTunnelTunnelReady, metav1.ConditionFalse, "TunnelNotFound", err.Error())
+		return r.updateStatus(ctx, cfTunnel)
+	}
 	if err != nil {
-		result, err2 := r.updateStatus(ctx, cfTunnel)
-		if err2 != nil {
-			logger.Error(err2, "Failed to update CloudflareTunnel status.", "name", req.Name, "namespace", req.Namespace)
+		return r.failReconcile(ctx, cfTunnel, cftv1beta1.TypeCloudflareTunnelTunnelReady, "CloudflareAPIError", err)
+	}
+
+	// 新しく作成・引き継いだトンネルのトークンはローテーションしない
+	reason, rotate := tokenRotationDue(cfTunnel, time.Now())
+	if tunnel.ID != cfTunnel.Status.TunnelID {
+		rotate = false
+		cfTunnel.Status.PendingTokenRotation = nil
+	}
+	if rotate {
+		token, err = r.rotateToken(ctx, manager, &cfTunnel, reason)
+		if err != nil {
+			return r.failReconcile(ctx, cfTunnel, cftv1beta1.TypeCloudflareTunnelTunnelReady, "TokenRotationFailed", err)
 		}
-		return result, err
 	}
+	setCondition(&cfTunnel, cftv1beta1.TypeCloudflareTunnelTunnelReady, metav1.ConditionTrue, "Ready", fmt.Sprintf("Cloudflare Tunnel %s is ready", tunnel.ID))
 
 	secretName, err := r.reconcileSecret(ctx, cfTunnel, token)
 	if err != nil {
-		result, err2 := r.updateStatus(ctx, cfTunnel)
-		if err2 != nil {
-			logger.Error(err2, "Failed to update CloudflareTunnel status.", "name", req.Name, "namespace", req.Namespace)
-		}
-		return result, err
+		return r.failReconcile(ctx, cfTunnel, cftv1beta1.TypeCloudflareTunnelSecretReady, "SecretFailed", err)
+	}
+	setCondition(&cfTunnel, cftv1beta1.TypeCloudflareTunnelSecretReady, metav1.ConditionTrue, "Ready", fmt.Sprintf("Secret %s is up to date", secretName.Name))
+
+	// Secretに書き込むまではローテーションを完了しないので、失敗しても次のReconcileでやり直される
+	if rotate {
+		r.completeTokenRotation(ctx, &cfTunnel)
+	}
+
+	if previousID := cfTunnel.Status.TunnelID; previousID != "" && previousID != tunnel.ID {
+		logger.Info("Cloudflare Tunnel has been recreated.", "previousTunnelID", previousID, "tunnelID", tunnel.ID)
+		r.Recorder.Eventf(&cfTunnel, corev1.EventTypeWarning, "TunnelRecreated",
+			"Cloudflare Tunnel %s was deleted outside of the operator and has been replaced with %s", previousID, tunnel.ID)
+		setCondition(&cfTunnel, cftv1beta1.TypeCloudflareTunnelRecovered, metav1.ConditionFalse, "Republishing",
+			fmt.Sprintf("Cloudflare Tunnel %s was deleted outside of the operator and has been replaced with %s. Re-publishing the rules and DNS records.", previousID, tunnel.ID))
+		forgetTunnelConfigWriter(previousID)
 	}
 
 	cfTunnel.Status.TunnelID = tunnel.ID
@@ -140,42 +177,176 @@ func (r *CloudflareTunnelReconciler) Reconcile(ctx context.Context, req ctrl.Req
 		return ctrl.Result{}, fmt.Errorf("failed to update CloudflareTunnel status: %w", err)
 	}
 
-	if err := r.reconcileDeployment(ctx, cfTunnel, secretName); err != nil {
-		result, err2 := r.updateStatus(ctx, cfTunnel)
-		if err2 != nil {
-			logger.Error(err2, "Failed to update CloudflareTunnel status.", "name", req.Name, "namespace", req.Namespace)
+	// 再登録が終わるまではRecoveredがFalseのままなので、失敗しても次のReconcileでやり直す
+	if meta.IsStatusConditionFalse(cfTunnel.Status.Conditions, cftv1beta1.TypeCloudflareTunnelRecovered) {
+		if err := r.republishTunnel(ctx, manager, zones, &cfTunnel); err != nil {
+			return r.failReconcile(ctx, cfTunnel, cftv1beta1.TypeCloudflareTunnelDNSReady, "RepublishFailed", err)
 		}
-		return result, err
+	}
+
+	if err := r.reconcileDeployment(ctx, cfTunnel, secretName, token); err != nil {
+		return r.failReconcile(ctx, cfTunnel, cftv1beta1.TypeCloudflareTunnelDeploymentReady, "DeploymentFailed", err)
 	}
 
 	if err := r.reconcileService(ctx, cfTunnel); err != nil {
-		result, err2 := r.updateStatus(ctx, cfTunnel)
-		if err2 != nil {
-			logger.Error(err2, "Failed to update CloudflareTunnel status.", "name", req.Name, "namespace", req.Namespace)
-		}
-		return result, err
+		return r.failReconcile(ctx, cfTunnel, cftv1beta1.TypeCloudflareTunnelDeploymentReady, "ServiceFailed", err)
 	}
 
 	if err := r.reconcileServiceMonitor(ctx, cfTunnel); err != nil {
-		result, err2 := r.updateStatus(ctx, cfTunnel)
-		if err2 != nil {
-			logger.Error(err2, "Failed to update CloudflareTunnel status.", "name", req.Name, "namespace", req.Namespace)
-		}
-		return result, err
+		return r.failReconcile(ctx, cfTunnel, cftv1beta1.TypeCloudflareTunnelDeploymentReady, "ServiceMonitorFailed", err)
 	}
 
 	if err := r.reconcilePDB(ctx, cfTunnel); err != nil {
-		result, err2 := r.updateStatus(ctx, cfTunnel)
-		if err2 != nil {
-			logger.Error(err2, "Failed to update CloudflareTunnel status.", "name", req.Name, "namespace", req.Namespace)
-		}
+		return r.failReconcile(ctx, cfTunnel, cftv1beta1.TypeCloudflareTunnelDeploymentReady, "PodDisruptionBudgetFailed", err)
+	}
+
+	requeue, err := r.reconcileDeploymentStatus(ctx, &cfTunnel)
+	if err != nil {
+		return r.failReconcile(ctx, cfTunnel, cftv1beta1.TypeCloudflareTunnelDeploymentReady, "DeploymentFailed", err)
+	}
+
+	requeueAfter, err := r.reconcileDrift(ctx, manager, zones, &cfTunnel)
+	if err != nil {
+		return r.failReconcile(ctx, cfTunnel, cftv1beta1.TypeCloudflareTunnelDNSReady, "SyncFailed", err)
+	}
+
+	// PodがReadyでもエッジに接続できていないことがあるので、定期的に接続状況を確認する
+	if until := r.reconcileConnectors(ctx, manager, &cfTunnel); until > 0 && (requeueAfter == 0 || until < requeueAfter) {
+		requeueAfter = until
+	}
+
+	if until := untilTokenRotation(cfTunnel, time.Now()); until >= 0 && (requeueAfter == 0 || until < requeueAfter) {
+		requeueAfter = max(until, time.Second)
+	}
+
+	result, err := r.updateStatus(ctx, cfTunnel)
+	if err != nil {
 		return result, err
 	}
+	if requeue {
+		return ctrl.Result{Requeue: true}, nil
+	}
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// reconcileDrift repairs the tunnel ingress rules and DNS records that were changed outside of the operator once per ResyncInterval,
+// and returns the time until the next resync.
+func (r *CloudflareTunnelReconciler) reconcileDrift(ctx context.Context, manager CloudflareTunnelManager, zones []string, cfTunnel *cftv1beta1.CloudflareTunnel) (time.Duration, error) {
+	if r.ResyncInterval <= 0 {
+		return 0, nil
+	}
+
+	// Statusの更新でもReconcileが呼ばれるので、前回から間隔が空いていなければ何もしない
+	if last := cfTunnel.Status.LastSyncTime; last != nil {
+		if elapsed := time.Since(last.Time); elapsed < r.ResyncInterval {
+			return r.ResyncInterval - elapsed, nil
+		}
+	}
+
+	drift, err := syncTunnelRules(ctx, r.Client, manager, *cfTunnel, zones)
+	if err != nil {
+		return 0, fmt.Errorf("failed to resync tunnel ingress rules: %w", err)
+	}
+
+	driftedRulesGauge.WithLabelValues(cfTunnel.Namespace, cfTunnel.Name).Set(float64(drift.Rules))
+	driftedDNSRecordsGauge.WithLabelValues(cfTunnel.Namespace, cfTunnel.Name).Set(float64(drift.DNSRecords))
+	driftRepairsTotal.WithLabelValues(cfTunnel.Namespace, cfTunnel.Name, "rule").Add(float64(drift.Rules))
+	driftRepairsTotal.WithLabelValues(cfTunnel.Namespace, cfTunnel.Name, "dns").Add(float64(drift.DNSRecords))
+
+	if drift.Rules > 0 || drift.DNSRecords > 0 {
+		r.Recorder.Eventf(cfTunnel, corev1.EventTypeWarning, "DriftRepaired",
+			"Repaired %d tunnel ingress rules and %d DNS records that were changed outside of the operator", drift.Rules, drift.DNSRecords)
+	}
+
+	cfTunnel.Status.DriftedRules = int32(drift.Rules)
+	cfTunnel.Status.DriftedDNSRecords = int32(drift.DNSRecords)
+	setCondition(cfTunnel, cftv1beta1.TypeCloudflareTunnelDNSReady, metav1.ConditionTrue, "Synced",
+		fmt.Sprintf("Repaired %d tunnel ingress rules and %d DNS records at the last resync", drift.Rules, drift.DNSRecords))
+	cfTunnel.Status.LastSyncTime = ptr.To(metav1.Now())
+	return r.ResyncInterval, nil
+}
+
+// republishTunnel adds the rules of the resources bound to the tunnel and their DNS records to the recreated tunnel.
+func (r *CloudflareTunnelReconciler) republishTunnel(ctx context.Context, manager CloudflareTunnelManager, zones []string, cfTunnel *cftv1beta1.CloudflareTunnel) error {
+	drift, err := syncTunnelRules(ctx, r.Client, manager, *cfTunnel, zones)
+	if err != nil {
+		return fmt.Errorf("failed to re-publish tunnel ingress rules: %w", err)
+	}
+
+	r.Recorder.Eventf(cfTunnel, corev1.EventTypeNormal, "TunnelRecovered",
+		"Re-published %d tunnel ingress rules and %d DNS records to Cloudflare Tunnel %s", drift.Rules, drift.DNSRecords, cfTunnel.Status.TunnelID)
+	setCondition(cfTunnel, cftv1beta1.TypeCloudflareTunnelRecovered, metav1.ConditionTrue, "Republished",
+		fmt.Sprintf("The rules and DNS records have been re-published to Cloudflare Tunnel %s", cfTunnel.Status.TunnelID))
+	setCondition(cfTunnel, cftv1beta1.TypeCloudflareTunnelDNSReady, metav1.ConditionTrue, "Synced",
+		fmt.Sprintf("Re-published %d tunnel ingress rules and %d DNS records", drift.Rules, drift.DNSRecords))
+	cfTunnel.Status.LastSyncTime = ptr.To(metav1.Now())
+	return nil
+}
+
+// reconcileDelete cleans up the Cloudflare resources of the deleted CloudflareTunnel and removes the finalizer.
+// If the credentials Secret has been deleted or is invalid, e.g. in the teardown of the namespace,
+// the Cloudflare resources are left with a warning event so that the CloudflareTunnel is not stuck with the finalizer.
+func (r *CloudflareTunnelReconciler) reconcileDelete(ctx context.Context, cfTunnel cftv1beta1.CloudflareTunnel) error {
+	if !controllerutil.ContainsFinalizer(&cfTunnel, finalizerName) {
+		return nil
+	}
+
+	manager, zones, err := cloudflareTunnelManagerFor(ctx, r.Client, r.CloudflareTunnelManager, r.Credentials, cfTunnel)
+	switch {
+	case apierrors.IsNotFound(err) || errors.Is(err, ErrInvalidCredentials):
+		log.FromContext(ctx).Error(err, "Skipping the cleanup of Cloudflare Tunnel without credentials.", "tunnelID", cfTunnel.Status.TunnelID)
+		r.Recorder.Eventf(&cfTunnel, corev1.EventTypeWarning, "CleanupSkipped",
+			"Cloudflare Tunnel %s (%s) and its DNS records are left because the credentials are not available: %v",
+			cfTunnel.Status.TunnelName, cfTunnel.Status.TunnelID, err)
+	case err != nil:
+		return fmt.Errorf("failed to get Cloudflare Tunnel client: %w", err)
+	default:
+		if err := r.finalizeTunnel(ctx, manager, zones, cfTunnel); err != nil {
+			return err
+		}
+	}
+
+	controllerutil.RemoveFinalizer(&cfTunnel, finalizerName)
+	if err := r.Update(ctx, &cfTunnel); err != nil {
+		return err
+	}
+	forgetTunnelConfigWriter(cfTunnel.Status.TunnelID)
+	return nil
+}
+
+func (r *CloudflareTunnelReconciler) finalizeTunnel(ctx context.Context, manager CloudflareTunnelManager, zones []string, cfTunnel cftv1beta1.CloudflareTunnel) error {
+	logger := log.FromContext(ctx)
+
+	if cfTunnel.Status.TunnelID == "" {
+		return nil
+	}
+
+	if cfTunnel.Spec.DeletionPolicy == cftv1beta1.DeletionPolicyRetain {
+		// Kubernetes側のリソースはOwnerReferenceによって削除されるので、Cloudflare側のリソースだけを残す
+		logger.Info("Retaining Cloudflare Tunnel and DNS records.", "tunnelID", cfTunnel.Status.TunnelID)
+		r.Recorder.Eventf(&cfTunnel, corev1.EventTypeNormal, "TunnelRetained",
+			"Cloudflare Tunnel %s (%s) and its DNS records are retained. Set spec.tunnelID to %s to adopt it again.",
+			cfTunnel.Status.TunnelName, cfTunnel.Status.TunnelID, cfTunnel.Status.TunnelID,
+		)
+		return nil
+	}
+
+	if err := manager.DeleteAllDNS(ctx, cfTunnel.Status.TunnelID, zones); err != nil {
+		return fmt.Errorf("failed to delete CloudflareTunnel: %w", err)
+	}
+
+	if err := manager.DeleteTunnel(ctx, cfTunnel.Status.TunnelID); err != nil {
+		return fmt.Errorf("failed to delete Cloudflare Tunnel: %w", err)
+	}
 
-	return r.updateStatus(ctx, cfTunnel)
+	// 所有記録はトンネルを残す場合に引き継げるようOwnerReferenceを持たないので、ここで消す
+	if err := deleteManagedRules(ctx, r.Client, cfTunnel); err != nil {
+		return fmt.Errorf("failed to delete managed rules: %w", err)
+	}
+	return nil
 }
 
-func (r *CloudflareTunnelReconciler) reconcileTunnel(ctx context.Context, cfTunnel cftv1beta1.CloudflareTunnel) (domain.CloudflareTunnel, domain.CloudflareTunnelToken, error) {
+func (r *CloudflareTunnelReconciler) reconcileTunnel(ctx context.Context, manager CloudflareTunnelManager, cfTunnel cftv1beta1.CloudflareTunnel) (domain.CloudflareTunnel, domain.CloudflareTunnelToken, error) {
 	var secret corev1.Secret
 	if err := r.Get(ctx, client.ObjectKey{Namespace: cfTunnel.Namespace, Name: cfTunnel.Name}, &secret); err != nil {
 		if !apierrors.IsNotFound(err) {
@@ -196,34 +367,50 @@ func (r *CloudflareTunnelReconciler) reconcileTunnel(ctx context.Context, cfTunn
 	}
 
 	if cfTunnel.Status.TunnelID != "" {
-		// TunnelIDがStatusに存在していて、Secretも存在している場合は、再利用する
-		if token != "" {
+		tunnel, err := manager.GetTunnel(ctx, cfTunnel.Status.TunnelID)
+		if err != nil && !errors.Is(err, domain.ErrTunnelNotFound) {
+			return domain.CloudflareTunnel{}, "", fmt.Errorf("failed to get tunnel: %w", err)
+		}
+
+		if err == nil && !tunnel.Deleted {
+			// TunnelIDがStatusに存在していて、Secretも存在している場合は、再利用する
+			if token == "" {
+				token, err = manager.GetTunnelToken(ctx, cfTunnel.Status.TunnelID)
+				if err != nil {
+					return domain.CloudflareTunnel{}, "", fmt.Errorf("failed to get tunnel token: %w", err)
+				}
+			}
 			return domain.CloudflareTunnel{
 				ID:   cfTunnel.Status.TunnelID,
 				Name: tunnelName,
 			}, token, nil
-		} else {
-			token, err := r.CloudflareTunnelManager.GetTunnelToken(ctx, cfTunnel.Status.TunnelID)
-			if err == nil {
-				return domain.CloudflareTunnel{
-					ID:   cfTunnel.Status.TunnelID,
-					Name: tunnelName,
-				}, token, nil
-			} else {
-				if !errors.Is(err, ErrTunnelNotFound) {
-					return domain.CloudflareTunnel{}, "", fmt.Errorf("failed to get tunnel: %w", err)
-				}
-			}
 		}
+
+		// Cloudflare側で削除されているので、引き継ぐか作り直す
+		log.FromContext(ctx).Info("Cloudflare Tunnel has been deleted outside of the operator.", "tunnelID", cfTunnel.Status.TunnelID)
+	}
+
+	// 既存のTunnelを引き継ぐ
+	tunnel, err := findAdoptableTunnel(ctx, manager, cfTunnel, tunnelName)
+	if err != nil {
+		return domain.CloudflareTunnel{}, "", fmt.Errorf("failed to adopt tunnel: %w", err)
+	}
+	if tunnel.ID != "" {
+		token, err := manager.GetTunnelToken(ctx, tunnel.ID)
+		if err != nil {
+			return domain.CloudflareTunnel{}, "", fmt.Errorf("failed to get tunnel token: %w", err)
+		}
+		log.FromContext(ctx).Info("Adopted existing Cloudflare Tunnel.", "tunnelID", tunnel.ID, "tunnelName", tunnel.Name)
+		return tunnel, token, nil
 	}
 
 	// TunnelIDがStatusに存在していないので、Tunnelを作成する
-	tunnel, err := r.CloudflareTunnelManager.CreateTunnel(ctx, tunnelName)
+	tunnel, err = manager.CreateTunnel(ctx, tunnelName)
 	if err != nil {
 		return domain.CloudflareTunnel{}, "", fmt.Errorf("failed to create tunnel: %w", err)
 	}
 
-	token, err = r.CloudflareTunnelManager.GetTunnelToken(ctx, tunnel.ID)
+	token, err = manager.GetTunnelToken(ctx, tunnel.ID)
 	if err != nil {
 		return domain.CloudflareTunnel{}, "", fmt.Errorf("failed to get tunnel token: %w", err)
 	}
@@ -231,6 +418,43 @@ func (r *CloudflareTunnelReconciler) reconcileTunnel(ctx context.Context, cfTunn
 	return tunnel, token, nil
 }
 
+// findAdoptableTunnel returns the existing tunnel specified by spec.tunnelID or spec.adoptExisting.
+// If there is no tunnel to adopt, it returns an empty CloudflareTunnel.
+// If the tunnel specified by spec.tunnelID does not exist or has been deleted, it returns domain.ErrTunnelNotFound.
+func findAdoptableTunnel(ctx context.Context, manager CloudflareTunnelManager, cfTunnel cftv1beta1.CloudflareTunnel, tunnelName string) (domain.CloudflareTunnel, error) {
+	var tunnel domain.CloudflareTunnel
+	switch {
+	case cfTunnel.Spec.TunnelID != "":
+		t, err := manager.GetTunnel(ctx, cfTunnel.Spec.TunnelID)
+		if err != nil {
+			return domain.CloudflareTunnel{}, fmt.Errorf("failed to get tunnel %s: %w", cfTunnel.Spec.TunnelID, err)
+		}
+		if t.Deleted {
+			return domain.CloudflareTunnel{}, fmt.Errorf("%w: tunnel %s has been deleted", domain.ErrTunnelNotFound, t.ID)
+		}
+		tunnel = t
+	case cfTunnel.Spec.AdoptExisting:
+		t, err := manager.FindTunnelByName(ctx, tunnelName)
+		if err != nil {
+			return domain.CloudflareTunnel{}, fmt.Errorf("failed to find tunnel %s: %w", tunnelName, err)
+		}
+		if t.ID == "" {
+			return domain.CloudflareTunnel{}, nil
+		}
+		tunnel = t
+	default:
+		return domain.CloudflareTunnel{}, nil
+	}
+
+	if tunnel.Deleted {
+		return domain.CloudflareTunnel{}, fmt.Errorf("%w: tunnel %s has been deleted", domain.ErrTunnelNotAdoptable, tunnel.ID)
+	}
+	if !tunnel.RemoteConfig {
+		return domain.CloudflareTunnel{}, fmt.Errorf("%w: tunnel %s is not remotely managed", domain.ErrTunnelNotAdoptable, tunnel.ID)
+	}
+	return tunnel, nil
+}
+
 func (r *CloudflareTunnelReconciler) reconcileSecret(ctx context.Context, cfTunnel cftv1beta1.CloudflareTunnel, token domain.CloudflareTunnelToken) (types.NamespacedName, error) {
 	logger := log.FromContext(ctx)
 
@@ -288,7 +512,7 @@ func (r *CloudflareTunnelReconciler) reconcileSecret(ctx context.Context, cfTunn
 	return namespacedName, nil
 }
 
-func (r *CloudflareTunnelReconciler) reconcileDeployment(ctx context.Context, cfTunnel cftv1beta1.CloudflareTunnel, secretName types.NamespacedName) error {
+func (r *CloudflareTunnelReconciler) reconcileDeployment(ctx context.Context, cfTunnel cftv1beta1.CloudflareTunnel, secretName types.NamespacedName, token domain.CloudflareTunnelToken) error {
 	logger := log.FromContext(ctx)
 
 	owner, err := controllerReference(cfTunnel, r.Scheme)
@@ -403,8 +627,21 @@ func (r *CloudflareTunnelReconciler) reconcileDeployment(ctx context.Context, cf
 		WithSpec(appsv1apply.DeploymentSpec().
 			WithReplicas(cfTunnel.Spec.Replicas).
 			WithSelector(metav1apply.LabelSelector().WithMatchLabels(labels)).
+			// 新しいトークンで接続したPodがReadyになってから古いPodを止めるので、コネクタが0にならない
+			WithStrategy(appsv1apply.DeploymentStrategy().
+				WithType(appsv1.RollingUpdateDeploymentStrategyType).
+				WithRollingUpdate(appsv1apply.RollingUpdateDeployment().
+					WithMaxUnavailable(intstr.FromInt32(0)).
+					WithMaxSurge(intstr.FromInt32(1)),
+				),
+			).
 			WithTemplate(corev1apply.PodTemplateSpec().
 				WithLabels(labels).
+				// トンネルが作り直されたときやトークンがローテーションされたときにPodを再起動させる
+				WithAnnotations(map[string]string{
+					tunnelIDAnnotation:      cfTunnel.Status.TunnelID,
+					tokenChecksumAnnotation: tokenChecksum(token),
+				}).
 				WithSpec(corev1apply.PodSpec().
 					WithTopologySpreadConstraints(topologySpreadConstraints...).
 					WithSecurityContext(podSecurityContext).
@@ -422,6 +659,13 @@ func (r *CloudflareTunnelReconciler) reconcileDeployment(ctx context.Context, cf
 						).
 						WithResources(resourceRequirements).
 						WithSecurityContext(securityContext).
+						WithReadinessProbe(corev1apply.Probe().
+							WithHTTPGet(corev1apply.HTTPGetAction().
+								WithPath("/ready").
+								WithPort(intstr.FromString("metrics")),
+							).
+							WithPeriodSeconds(5),
+						).
 						WithLivenessProbe(corev1apply.Probe().
 							WithHTTPGet(corev1apply.HTTPGetAction().
 								WithPath("/ready").
@@ -643,106 +887,15 @@ func (r *CloudflareTunnelReconciler) reconcilePDB(ctx context.Context, cfTunnel
 	return nil
 }
 
+// updateStatus summarizes the conditions of the reconcile steps into Available and Degraded, and writes the status.
 func (r *CloudflareTunnelReconciler) updateStatus(ctx context.Context, cfTunnel cftv1beta1.CloudflareTunnel) (ctrl.Result, error) {
-	meta.SetStatusCondition(&cfTunnel.Status.Conditions, metav1.Condition{
-		Type:   cftv1beta1.TypeCloudflareTunnelAvailable,
-		Status: metav1.ConditionTrue,
-		Reason: "OK",
-	})
-	meta.SetStatusCondition(&cfTunnel.Status.Conditions, metav1.Condition{
-		Type:   cftv1beta1.TypeCloudflareTunnelDegraded,
-		Status: metav1.ConditionFalse,
-		Reason: "OK",
-	})
-
-	if cfTunnel.Status.TunnelID == "" {
-		meta.SetStatusCondition(&cfTunnel.Status.Conditions, metav1.Condition{
-			Type:    cftv1beta1.TypeCloudflareTunnelDegraded,
-			Status:  metav1.ConditionTrue,
-			Reason:  "Reconciling",
-			Message: "Cloudflare Tunnel is not ready yet",
-		})
-		meta.SetStatusCondition(&cfTunnel.Status.Conditions, metav1.Condition{
-			Type:   cftv1beta1.TypeCloudflareTunnelAvailable,
-			Status: metav1.ConditionFalse,
-			Reason: "Reconciling",
-		})
-		return ctrl.Result{}, nil
-	}
-
-	var secret corev1.Secret
-	if err := r.Get(ctx, client.ObjectKey{Namespace: cfTunnel.Namespace, Name: cfTunnel.Name}, &secret); err != nil {
-		if apierrors.IsNotFound(err) {
-			meta.SetStatusCondition(&cfTunnel.Status.Conditions, metav1.Condition{
-				Type:    cftv1beta1.TypeCloudflareTunnelDegraded,
-				Status:  metav1.ConditionTrue,
-				Reason:  "Reconciling",
-				Message: "Secret not found",
-			})
-			meta.SetStatusCondition(&cfTunnel.Status.Conditions, metav1.Condition{
-				Type:   cftv1beta1.TypeCloudflareTunnelAvailable,
-				Status: metav1.ConditionFalse,
-				Reason: "Reconciling",
-			})
-		} else {
-			return ctrl.Result{}, err
-		}
-	}
-
-	var svc corev1.Service
-	if err := r.Get(ctx, client.ObjectKey{Namespace: cfTunnel.Namespace, Name: cfTunnel.Name}, &svc); err != nil {
-		if apierrors.IsNotFound(err) {
-			meta.SetStatusCondition(&cfTunnel.Status.Conditions, metav1.Condition{
-				Type:    cftv1beta1.TypeCloudflareTunnelDegraded,
-				Status:  metav1.ConditionTrue,
-				Reason:  "Reconciling",
-				Message: "Service not found",
-			})
-			meta.SetStatusCondition(&cfTunnel.Status.Conditions, metav1.Condition{
-				Type:   cftv1beta1.TypeCloudflareTunnelAvailable,
-				Status: metav1.ConditionFalse,
-				Reason: "Reconciling",
-			})
-		} else {
-			return ctrl.Result{}, err
-		}
-	}
-
-	var dep appsv1.Deployment
-	if err := r.Get(ctx, client.ObjectKey{Namespace: cfTunnel.Namespace, Name: cfTunnel.Name}, &dep); err != nil {
-		if apierrors.IsNotFound(err) {
-			meta.SetStatusCondition(&cfTunnel.Status.Conditions, metav1.Condition{
-				Type:    cftv1beta1.TypeCloudflareTunnelDegraded,
-				Status:  metav1.ConditionTrue,
-				Reason:  "Reconciling",
-				Message: "Deployment not found",
-			})
-			meta.SetStatusCondition(&cfTunnel.Status.Conditions, metav1.Condition{
-				Type:   cftv1beta1.TypeCloudflareTunnelAvailable,
-				Status: metav1.ConditionFalse,
-				Reason: "Reconciling",
-			})
-		} else {
-			return ctrl.Result{}, err
-		}
-	}
-
-	result := ctrl.Result{}
-	if dep.Status.AvailableReplicas == 0 {
-		meta.SetStatusCondition(&cfTunnel.Status.Conditions, metav1.Condition{
-			Type:    cftv1beta1.TypeCloudflareTunnelAvailable,
-			Status:  metav1.ConditionFalse,
-			Reason:  "Unavailable",
-			Message: "AvailableReplicas is 0",
-		})
-		result = ctrl.Result{Requeue: true}
-	}
+	cfTunnel.Status.ObservedGeneration = cfTunnel.Generation
+	summarizeConditions(&cfTunnel)
 
 	if err := r.Status().Update(ctx, &cfTunnel); err != nil {
 		return ctrl.Result{}, err
 	}
-
-	return result, nil
+	return ctrl.Result{}, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -752,7 +905,8 @@ func (r *CloudflareTunnelReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		Owns(&corev1.Secret{}).
 		Owns(&appsv1.Deployment{}).
 		Owns(&corev1.Service{}).
-		Owns(&policyv1.PodDisruptionBudget{})
+		Owns(&policyv1.PodDisruptionBudget{}).
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.cloudflareTunnelsForSecret))
 
 	// Prometheus Operatorがインストールされていない環境でも動作するようにする
 	if err := mgr.GetAPIReader().Get(context.Background(), types.NamespacedName{Name: "servicemonitors.monitoring.coreos.com"}, &apiextensions.CustomResourceDefinition{}); err != nil {
@@ -765,8 +919,9 @@ func (r *CloudflareTunnelReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		builder = builder.Owns(&monitoringv1.ServiceMonitor{})
 	}
 
-	builder = builder.Named("cloudflaretunnel")
-	return builder.Complete(r)
+	builder = builder.Named("cloudflaretunnel").
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles})
+	return builder.Complete(requeueOnRateLimit(r))
 }
 
 func controllerReference(cfTunnel cftv1beta1.CloudflareTunnel, scheme *runtime.Scheme) (*metav1apply.OwnerReferenceApplyConfiguration, error) {
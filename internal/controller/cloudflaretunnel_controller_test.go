@@ -3,6 +3,10 @@ package controller
 import (
 	"context"
 	"embed"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -11,12 +15,17 @@ import (
 	mock_controller "github.com/walnuts1018/cloudflare-tunnel-operator/internal/controller/mock"
 	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/domain"
 	. "github.com/walnuts1018/cloudflare-tunnel-operator/pkg/gomega"
+	"go.uber.org/mock/gomock"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	policyv1 "k8s.io/api/policy/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/yaml"
 )
@@ -89,6 +98,7 @@ var _ = Describe("CloudflareTunnel Controller", func() {
 				Client:                  k8sClient,
 				Scheme:                  k8sClient.Scheme(),
 				CloudflareTunnelManager: mockCloudflareTunnelManager,
+				Recorder:                record.NewFakeRecorder(10),
 			}
 
 			mockCloudflareTunnelManager.EXPECT().CreateTunnel(ctx, cloudflareTunnel.Name).Return(domain.CloudflareTunnel{
@@ -141,3 +151,328 @@ var _ = Describe("CloudflareTunnel Controller", func() {
 		})
 	})
 })
+
+func Test_findAdoptableTunnel(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		spec    cftunneloperatorv1beta1.CloudflareTunnelSpec
+		setup   func(m *mock_controller.MockCloudflareTunnelManager)
+		want    domain.CloudflareTunnel
+		wantErr error
+	}{
+		{
+			name:  "nothing to adopt",
+			spec:  cftunneloperatorv1beta1.CloudflareTunnelSpec{},
+			setup: func(m *mock_controller.MockCloudflareTunnelManager) {},
+			want:  domain.CloudflareTunnel{},
+		},
+		{
+			name: "adopt by ID",
+			spec: cftunneloperatorv1beta1.CloudflareTunnelSpec{TunnelID: "existing-id"},
+			setup: func(m *mock_controller.MockCloudflareTunnelManager) {
+				m.EXPECT().GetTunnel(ctx, "existing-id").Return(domain.CloudflareTunnel{ID: "existing-id", Name: "existing", RemoteConfig: true}, nil)
+			},
+			want: domain.CloudflareTunnel{ID: "existing-id", Name: "existing", RemoteConfig: true},
+		},
+		{
+			name: "adopt by name",
+			spec: cftunneloperatorv1beta1.CloudflareTunnelSpec{AdoptExisting: true},
+			setup: func(m *mock_controller.MockCloudflareTunnelManager) {
+				m.EXPECT().FindTunnelByName(ctx, "tunnel").Return(domain.CloudflareTunnel{ID: "existing-id", Name: "tunnel", RemoteConfig: true}, nil)
+			},
+			want: domain.CloudflareTunnel{ID: "existing-id", Name: "tunnel", RemoteConfig: true},
+		},
+		{
+			name: "no tunnel with the same name",
+			spec: cftunneloperatorv1beta1.CloudflareTunnelSpec{AdoptExisting: true},
+			setup: func(m *mock_controller.MockCloudflareTunnelManager) {
+				m.EXPECT().FindTunnelByName(ctx, "tunnel").Return(domain.CloudflareTunnel{}, nil)
+			},
+			want: domain.CloudflareTunnel{},
+		},
+		{
+			name: "locally managed tunnel",
+			spec: cftunneloperatorv1beta1.CloudflareTunnelSpec{TunnelID: "existing-id"},
+			setup: func(m *mock_controller.MockCloudflareTunnelManager) {
+				m.EXPECT().GetTunnel(ctx, "existing-id").Return(domain.CloudflareTunnel{ID: "existing-id", Name: "existing"}, nil)
+			},
+			wantErr: domain.ErrTunnelNotAdoptable,
+		},
+		{
+			name: "deleted tunnel",
+			spec: cftunneloperatorv1beta1.CloudflareTunnelSpec{TunnelID: "existing-id"},
+			setup: func(m *mock_controller.MockCloudflareTunnelManager) {
+				m.EXPECT().GetTunnel(ctx, "existing-id").Return(domain.CloudflareTunnel{ID: "existing-id", Name: "existing", RemoteConfig: true, Deleted: true}, nil)
+			},
+			wantErr: domain.ErrTunnelNotFound,
+		},
+		{
+			name: "tunnel not found",
+			spec: cftunneloperatorv1beta1.CloudflareTunnelSpec{TunnelID: "existing-id"},
+			setup: func(m *mock_controller.MockCloudflareTunnelManager) {
+				m.EXPECT().GetTunnel(ctx, "existing-id").Return(domain.CloudflareTunnel{}, fmt.Errorf("failed to get tunnel: %w", domain.ErrTunnelNotFound))
+			},
+			wantErr: domain.ErrTunnelNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := mock_controller.NewMockCloudflareTunnelManager(gomock.NewController(t))
+			tt.setup(m)
+
+			cfTunnel := cftunneloperatorv1beta1.CloudflareTunnel{
+				ObjectMeta: metav1.ObjectMeta{Name: "tunnel", Namespace: "default"},
+				Spec:       tt.spec,
+			}
+
+			got, err := findAdoptableTunnel(ctx, m, cfTunnel, "tunnel")
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("findAdoptableTunnel() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("findAdoptableTunnel() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("findAdoptableTunnel() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCloudflareTunnelReconciler_Reconcile_specifiedTunnelDeleted(t *testing.T) {
+	ctx := context.Background()
+
+	cfTunnel := &cftunneloperatorv1beta1.CloudflareTunnel{
+		ObjectMeta: metav1.ObjectMeta{Name: "tunnel", Namespace: "default", Finalizers: []string{finalizerName}},
+		Spec:       cftunneloperatorv1beta1.CloudflareTunnelSpec{TunnelID: "adopted-id"},
+		Status:     cftunneloperatorv1beta1.CloudflareTunnelStatus{TunnelID: "adopted-id", TunnelName: "tunnel"},
+	}
+	scheme := runtime.NewScheme()
+	if err := cftunneloperatorv1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	// 作り直さずに止まるので、CreateTunnelは呼ばれない
+	m := mock_controller.NewMockCloudflareTunnelManager(gomock.NewController(t))
+	m.EXPECT().GetTunnel(ctx, "adopted-id").Return(domain.CloudflareTunnel{}, fmt.Errorf("failed to get tunnel: %w", domain.ErrTunnelNotFound)).Times(2)
+
+	recorder := record.NewFakeRecorder(10)
+	r := &CloudflareTunnelReconciler{
+		Client:                  fake.NewClientBuilder().WithScheme(scheme).WithObjects(cfTunnel).WithStatusSubresource(cfTunnel).Build(),
+		Recorder:                recorder,
+		CloudflareTunnelManager: m,
+	}
+
+	result, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "tunnel"}})
+	if err != nil {
+		t.Fatalf("Reconcile() error = %v, want no error not to retry", err)
+	}
+	if !result.IsZero() {
+		t.Errorf("Reconcile() = %v, want no requeue", result)
+	}
+
+	var got cftunneloperatorv1beta1.CloudflareTunnel
+	if err := r.Get(ctx, types.NamespacedName{Namespace: "default", Name: "tunnel"}, &got); err != nil {
+		t.Fatal(err)
+	}
+	condition := meta.FindStatusCondition(got.Status.Conditions, cftunneloperatorv1beta1.TypeCloudflareTunnelTunnelReady)
+	if condition == nil || condition.Status != metav1.ConditionFalse || condition.Reason != "TunnelNotFound" {
+		t.Errorf("TunnelReady = %v, want False with TunnelNotFound", condition)
+	}
+	if !meta.IsStatusConditionFalse(got.Status.Conditions, cftunneloperatorv1beta1.TypeCloudflareTunnelAvailable) {
+		t.Errorf("Available = %v, want False", meta.FindStatusCondition(got.Status.Conditions, cftunneloperatorv1beta1.TypeCloudflareTunnelAvailable))
+	}
+	if len(recorder.Events) != 1 {
+		t.Errorf("Reconcile() recorded %d events, want 1", len(recorder.Events))
+	}
+}
+
+func TestCloudflareTunnelReconciler_finalizeTunnel(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name       string
+		policy     cftunneloperatorv1beta1.DeletionPolicy
+		setup      func(m *mock_controller.MockCloudflareTunnelManager)
+		wantEvents int
+		// wantManagedRules is true if the ConfigMap of the ownership record is kept
+		wantManagedRules bool
+	}{
+		{
+			name:   "delete",
+			policy: cftunneloperatorv1beta1.DeletionPolicyDelete,
+			setup: func(m *mock_controller.MockCloudflareTunnelManager) {
+				m.EXPECT().DeleteAllDNS(ctx, "test-id", []string{"walnuts.dev"}).Return(nil)
+				m.EXPECT().DeleteTunnel(ctx, "test-id").Return(nil)
+			},
+			wantEvents: 0,
+		},
+		{
+			name:             "retain",
+			policy:           cftunneloperatorv1beta1.DeletionPolicyRetain,
+			setup:            func(m *mock_controller.MockCloudflareTunnelManager) {},
+			wantEvents:       1,
+			wantManagedRules: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := mock_controller.NewMockCloudflareTunnelManager(gomock.NewController(t))
+			tt.setup(m)
+
+			managedRules := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "tunnel-managed-rules", Namespace: "default"}}
+			recorder := record.NewFakeRecorder(10)
+			r := &CloudflareTunnelReconciler{
+				Client:   fake.NewClientBuilder().WithObjects(managedRules).Build(),
+				Recorder: recorder,
+			}
+
+			cfTunnel := cftunneloperatorv1beta1.CloudflareTunnel{
+				ObjectMeta: metav1.ObjectMeta{Name: "tunnel", Namespace: "default"},
+				Spec: cftunneloperatorv1beta1.CloudflareTunnelSpec{
+					DeletionPolicy: tt.policy,
+				},
+				Status: cftunneloperatorv1beta1.CloudflareTunnelStatus{
+					TunnelID:   "test-id",
+					TunnelName: "tunnel",
+				},
+			}
+
+			if err := r.finalizeTunnel(ctx, m, []string{"walnuts.dev"}, cfTunnel); err != nil {
+				t.Fatalf("finalizeTunnel() error = %v", err)
+			}
+			if len(recorder.Events) != tt.wantEvents {
+				t.Errorf("finalizeTunnel() recorded %d events, want %d", len(recorder.Events), tt.wantEvents)
+			}
+			err := r.Get(ctx, types.NamespacedName{Namespace: "default", Name: "tunnel-managed-rules"}, &corev1.ConfigMap{})
+			if tt.wantManagedRules && err != nil {
+				t.Errorf("Get() error = %v, want the managed rules to be kept", err)
+			}
+			if !tt.wantManagedRules && !apierrors.IsNotFound(err) {
+				t.Errorf("Get() error = %v, want the managed rules to be deleted", err)
+			}
+		})
+	}
+}
+
+func TestCloudflareTunnelReconciler_reconcileDelete(t *testing.T) {
+	ctx := context.Background()
+
+	cfTunnel := &cftunneloperatorv1beta1.CloudflareTunnel{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "tunnel",
+			Namespace:         "default",
+			Finalizers:        []string{finalizerName},
+			DeletionTimestamp: &metav1.Time{Time: time.Now()},
+		},
+		Spec: cftunneloperatorv1beta1.CloudflareTunnelSpec{
+			CredentialsRef: &corev1.LocalObjectReference{Name: "deleted"},
+		},
+		Status: cftunneloperatorv1beta1.CloudflareTunnelStatus{TunnelID: "test-id", TunnelName: "tunnel"},
+	}
+	scheme := runtime.NewScheme()
+	if err := cftunneloperatorv1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := record.NewFakeRecorder(10)
+	r := &CloudflareTunnelReconciler{
+		Client:   fake.NewClientBuilder().WithScheme(scheme).WithObjects(cfTunnel).Build(),
+		Recorder: recorder,
+		// 呼ばれるとテストが失敗する
+		CloudflareTunnelManager: mock_controller.NewMockCloudflareTunnelManager(gomock.NewController(t)),
+		Credentials: NewCloudflareCredentialsCache(func(domain.CloudflareCredentials) (CloudflareTunnelManager, error) {
+			return nil, errors.New("unexpected credentials")
+		}),
+	}
+
+	// 認証情報のSecretが消えていてもFinalizerを外す
+	if err := r.reconcileDelete(ctx, *cfTunnel); err != nil {
+		t.Fatalf("reconcileDelete() error = %v", err)
+	}
+	var got cftunneloperatorv1beta1.CloudflareTunnel
+	if err := r.Get(ctx, types.NamespacedName{Namespace: "default", Name: "tunnel"}, &got); !apierrors.IsNotFound(err) {
+		t.Errorf("Get() error = %v, want the CloudflareTunnel to be deleted", err)
+	}
+	if len(recorder.Events) != 1 {
+		t.Errorf("reconcileDelete() recorded %d events, want 1", len(recorder.Events))
+	}
+}
+
+func TestCloudflareTunnelReconciler_reconcileTunnel(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		setup     func(m *mock_controller.MockCloudflareTunnelManager)
+		wantID    string
+		wantToken domain.CloudflareTunnelToken
+	}{
+		{
+			name: "existing tunnel",
+			setup: func(m *mock_controller.MockCloudflareTunnelManager) {
+				m.EXPECT().GetTunnel(ctx, "test-id").Return(domain.CloudflareTunnel{ID: "test-id", Name: "tunnel", RemoteConfig: true}, nil)
+			},
+			wantID:    "test-id",
+			wantToken: "test-token",
+		},
+		{
+			name: "deleted outside of the operator",
+			setup: func(m *mock_controller.MockCloudflareTunnelManager) {
+				m.EXPECT().GetTunnel(ctx, "test-id").Return(domain.CloudflareTunnel{}, fmt.Errorf("failed to get tunnel: %w", domain.ErrTunnelNotFound))
+				m.EXPECT().CreateTunnel(ctx, "tunnel").Return(domain.CloudflareTunnel{ID: "new-id", Name: "tunnel"}, nil)
+				m.EXPECT().GetTunnelToken(ctx, "new-id").Return(domain.CloudflareTunnelToken("new-token"), nil)
+			},
+			wantID:    "new-id",
+			wantToken: "new-token",
+		},
+		{
+			name: "marked as deleted",
+			setup: func(m *mock_controller.MockCloudflareTunnelManager) {
+				m.EXPECT().GetTunnel(ctx, "test-id").Return(domain.CloudflareTunnel{ID: "test-id", Name: "tunnel", Deleted: true}, nil)
+				m.EXPECT().CreateTunnel(ctx, "tunnel").Return(domain.CloudflareTunnel{ID: "new-id", Name: "tunnel"}, nil)
+				m.EXPECT().GetTunnelToken(ctx, "new-id").Return(domain.CloudflareTunnelToken("new-token"), nil)
+			},
+			wantID:    "new-id",
+			wantToken: "new-token",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := mock_controller.NewMockCloudflareTunnelManager(gomock.NewController(t))
+			tt.setup(m)
+
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "tunnel", Namespace: "default"},
+				Data:       map[string][]byte{tunnelTokenKey: []byte("test-token")},
+			}
+			r := &CloudflareTunnelReconciler{
+				Client: fake.NewClientBuilder().WithObjects(secret).Build(),
+			}
+
+			cfTunnel := cftunneloperatorv1beta1.CloudflareTunnel{
+				ObjectMeta: metav1.ObjectMeta{Name: "tunnel", Namespace: "default"},
+				Status:     cftunneloperatorv1beta1.CloudflareTunnelStatus{TunnelID: "test-id", TunnelName: "tunnel"},
+			}
+
+			tunnel, token, err := r.reconcileTunnel(ctx, m, cfTunnel)
+			if err != nil {
+				t.Fatalf("reconcileTunnel() error = %v", err)
+			}
+			if tunnel.ID != tt.wantID || token != tt.wantToken {
+				t.Errorf("reconcileTunnel() = %v, %v, want %v, %v", tunnel.ID, token, tt.wantID, tt.wantToken)
+			}
+		})
+	}
+}
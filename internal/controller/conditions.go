@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	cftv1beta1 "github.com/walnuts1018/cloudflare-tunnel-operator/api/v1beta1"
+	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/domain"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// reasonPartiallyAvailable is the reason of DeploymentReady when some, but not all, replicas are available.
+// The tunnel is still Available in that case.
+const reasonPartiallyAvailable = "PartiallyAvailable"
+
+// stepConditions are the conditions of the reconcile steps, in the order that they are reconciled.
+var stepConditions = []string{
+	cftv1beta1.TypeCloudflareTunnelTunnelReady,
+	cftv1beta1.TypeCloudflareTunnelSecretReady,
+	cftv1beta1.TypeCloudflareTunnelDeploymentReady,
+	cftv1beta1.TypeCloudflareTunnelDNSReady,
+}
+
+// availabilityConditions are the conditions of the steps that are needed to serve requests through the tunnel.
+var availabilityConditions = []string{
+	cftv1beta1.TypeCloudflareTunnelTunnelReady,
+	cftv1beta1.TypeCloudflareTunnelSecretReady,
+	cftv1beta1.TypeCloudflareTunnelDeploymentReady,
+}
+
+func setCondition(cfTunnel *cftv1beta1.CloudflareTunnel, conditionType string, status metav1.ConditionStatus, reason string, message string) {
+	meta.SetStatusCondition(&cfTunnel.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: cfTunnel.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// failureReason returns the reason of a condition for an error of the Cloudflare API, or fallback for the other errors.
+func failureReason(err error, fallback string) string {
+	switch {
+	case errors.Is(err, domain.ErrRateLimited):
+		return "RateLimited"
+	case errors.Is(err, domain.ErrCloudflareUnavailable):
+		return "CloudflareUnavailable"
+	case errors.Is(err, domain.ErrTunnelNotFound):
+		return "TunnelNotFound"
+	case errors.Is(err, domain.ErrTunnelNotAdoptable):
+		return "TunnelNotAdoptable"
+	case errors.Is(err, domain.ErrConfigurationConflict):
+		return "ConfigurationConflict"
+	case errors.Is(err, domain.ErrZoneNotFound):
+		return "ZoneNotFound"
+	default:
+		return fallback
+	}
+}
+
+// failReconcile records err in the condition of the step that failed, updates the status and returns err.
+func (r *CloudflareTunnelReconciler) failReconcile(ctx context.Context, cfTunnel cftv1beta1.CloudflareTunnel, conditionType string, reason string, err error) (ctrl.Result, error) {
+	setCondition(&cfTunnel, conditionType, metav1.ConditionFalse, failureReason(err, reason), err.Error())
+
+	result, err2 := r.updateStatus(ctx, cfTunnel)
+	if err2 != nil {
+		log.FromContext(ctx).Error(err2, "Failed to update CloudflareTunnel status.", "name", cfTunnel.Name, "namespace", cfTunnel.Namespace)
+	}
+	return result, err
+}
+
+// reconcileDeploymentStatus sets DeploymentReady from the status of the cloudflared Deployment,
+// and reports whether the Deployment should be checked again because no replica is available.
+func (r *CloudflareTunnelReconciler) reconcileDeploymentStatus(ctx context.Context, cfTunnel *cftv1beta1.CloudflareTunnel) (bool, error) {
+	var dep appsv1.Deployment
+	if err := r.Get(ctx, client.ObjectKey{Namespace: cfTunnel.Namespace, Name: cfTunnel.Name}, &dep); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return false, fmt.Errorf("failed to get Deployment: %w", err)
+		}
+		// 作成直後はキャッシュに反映されていないことがある
+		setCondition(cfTunnel, cftv1beta1.TypeCloudflareTunnelDeploymentReady, metav1.ConditionFalse, "DeploymentNotFound", "Deployment not found")
+		return true, nil
+	}
+	cfTunnel.Status.Replicas = dep.Status.Replicas
+
+	desired := cfTunnel.Spec.Replicas
+	available := dep.Status.AvailableReplicas
+	message := fmt.Sprintf("%d of %d replicas are available", available, desired)
+	switch {
+	case available >= desired:
+		setCondition(cfTunnel, cftv1beta1.TypeCloudflareTunnelDeploymentReady, metav1.ConditionTrue, "Available", message)
+	case available > 0:
+		setCondition(cfTunnel, cftv1beta1.TypeCloudflareTunnelDeploymentReady, metav1.ConditionFalse, reasonPartiallyAvailable, message)
+	default:
+		setCondition(cfTunnel, cftv1beta1.TypeCloudflareTunnelDeploymentReady, metav1.ConditionFalse, "Unavailable", message)
+		return true, nil
+	}
+	return false, nil
+}
+
+// summarizeConditions sets Available and Degraded from the conditions of the reconcile steps.
+func summarizeConditions(cfTunnel *cftv1beta1.CloudflareTunnel) {
+	degraded := false
+	for _, conditionType := range stepConditions {
+		condition := meta.FindStatusCondition(cfTunnel.Status.Conditions, conditionType)
+		if condition == nil || condition.Status != metav1.ConditionFalse {
+			continue
+		}
+		// 最初に失敗したステップを原因として報告する
+		setCondition(cfTunnel, cftv1beta1.TypeCloudflareTunnelDegraded, metav1.ConditionTrue, condition.Reason, fmt.Sprintf("%s: %s", condition.Type, condition.Message))
+		degraded = true
+		break
+	}
+	if !degraded {
+		setCondition(cfTunnel, cftv1beta1.TypeCloudflareTunnelDegraded, metav1.ConditionFalse, "OK", "")
+	}
+
+	for _, conditionType := range availabilityConditions {
+		condition := meta.FindStatusCondition(cfTunnel.Status.Conditions, conditionType)
+		switch {
+		case condition == nil:
+			setCondition(cfTunnel, cftv1beta1.TypeCloudflareTunnelAvailable, metav1.ConditionFalse, "Reconciling", conditionType+" is not reconciled yet")
+			return
+		case condition.Status == metav1.ConditionTrue:
+		case condition.Type == cftv1beta1.TypeCloudflareTunnelDeploymentReady && condition.Reason == reasonPartiallyAvailable:
+		default:
+			setCondition(cfTunnel, cftv1beta1.TypeCloudflareTunnelAvailable, metav1.ConditionFalse, condition.Reason, fmt.Sprintf("%s: %s", condition.Type, condition.Message))
+			return
+		}
+	}
+	setCondition(cfTunnel, cftv1beta1.TypeCloudflareTunnelAvailable, metav1.ConditionTrue, "OK", "")
+}
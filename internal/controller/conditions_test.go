@@ -0,0 +1,198 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	cftv1beta1 "github.com/walnuts1018/cloudflare-tunnel-operator/api/v1beta1"
+	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/domain"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_summarizeConditions(t *testing.T) {
+	condition := func(conditionType string, status metav1.ConditionStatus, reason string) metav1.Condition {
+		return metav1.Condition{Type: conditionType, Status: status, Reason: reason, Message: "message"}
+	}
+	ready := []metav1.Condition{
+		condition(cftv1beta1.TypeCloudflareTunnelTunnelReady, metav1.ConditionTrue, "Ready"),
+		condition(cftv1beta1.TypeCloudflareTunnelSecretReady, metav1.ConditionTrue, "Ready"),
+		condition(cftv1beta1.TypeCloudflareTunnelDeploymentReady, metav1.ConditionTrue, "Available"),
+	}
+
+	tests := []struct {
+		name            string
+		conditions      []metav1.Condition
+		wantAvailable   metav1.ConditionStatus
+		wantAvailReason string
+		wantDegraded    metav1.ConditionStatus
+		wantDegReason   string
+	}{
+		{
+			name:            "not reconciled yet",
+			wantAvailable:   metav1.ConditionFalse,
+			wantAvailReason: "Reconciling",
+			wantDegraded:    metav1.ConditionFalse,
+			wantDegReason:   "OK",
+		},
+		{
+			name:            "every step is ready",
+			conditions:      ready,
+			wantAvailable:   metav1.ConditionTrue,
+			wantAvailReason: "OK",
+			wantDegraded:    metav1.ConditionFalse,
+			wantDegReason:   "OK",
+		},
+		{
+			name: "the tunnel failed",
+			conditions: append([]metav1.Condition{
+				condition(cftv1beta1.TypeCloudflareTunnelTunnelReady, metav1.ConditionFalse, "RateLimited"),
+			}, ready[1:]...),
+			wantAvailable:   metav1.ConditionFalse,
+			wantAvailReason: "RateLimited",
+			wantDegraded:    metav1.ConditionTrue,
+			wantDegReason:   "RateLimited",
+		},
+		{
+			name: "some replicas are available",
+			conditions: append(ready[:2:2],
+				condition(cftv1beta1.TypeCloudflareTunnelDeploymentReady, metav1.ConditionFalse, reasonPartiallyAvailable),
+			),
+			wantAvailable:   metav1.ConditionTrue,
+			wantAvailReason: "OK",
+			wantDegraded:    metav1.ConditionTrue,
+			wantDegReason:   reasonPartiallyAvailable,
+		},
+		{
+			name: "the DNS records failed",
+			conditions: append(ready[:3:3],
+				condition(cftv1beta1.TypeCloudflareTunnelDNSReady, metav1.ConditionFalse, "SyncFailed"),
+			),
+			wantAvailable:   metav1.ConditionTrue,
+			wantAvailReason: "OK",
+			wantDegraded:    metav1.ConditionTrue,
+			wantDegReason:   "SyncFailed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfTunnel := cftv1beta1.CloudflareTunnel{Status: cftv1beta1.CloudflareTunnelStatus{Conditions: tt.conditions}}
+			summarizeConditions(&cfTunnel)
+
+			available := meta.FindStatusCondition(cfTunnel.Status.Conditions, cftv1beta1.TypeCloudflareTunnelAvailable)
+			if available.Status != tt.wantAvailable || available.Reason != tt.wantAvailReason {
+				t.Errorf("Available = %v (%v), want %v (%v)", available.Status, available.Reason, tt.wantAvailable, tt.wantAvailReason)
+			}
+			degraded := meta.FindStatusCondition(cfTunnel.Status.Conditions, cftv1beta1.TypeCloudflareTunnelDegraded)
+			if degraded.Status != tt.wantDegraded || degraded.Reason != tt.wantDegReason {
+				t.Errorf("Degraded = %v (%v), want %v (%v)", degraded.Status, degraded.Reason, tt.wantDegraded, tt.wantDegReason)
+			}
+		})
+	}
+}
+
+func TestCloudflareTunnelReconciler_failReconcile(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	if err := cftv1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	cfTunnel := &cftv1beta1.CloudflareTunnel{
+		ObjectMeta: metav1.ObjectMeta{Name: "tunnel", Namespace: "default", Generation: 3},
+	}
+	r := &CloudflareTunnelReconciler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(cfTunnel).WithStatusSubresource(cfTunnel).Build(),
+	}
+
+	wantErr := fmt.Errorf("failed to get tunnel: %w", &domain.RateLimitError{})
+	if _, err := r.failReconcile(ctx, *cfTunnel, cftv1beta1.TypeCloudflareTunnelTunnelReady, "CloudflareAPIError", wantErr); !errors.Is(err, wantErr) {
+		t.Fatalf("failReconcile() error = %v, want %v", err, wantErr)
+	}
+
+	var got cftv1beta1.CloudflareTunnel
+	if err := r.Get(ctx, client.ObjectKeyFromObject(cfTunnel), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status.ObservedGeneration != 3 {
+		t.Errorf("ObservedGeneration = %v, want 3", got.Status.ObservedGeneration)
+	}
+	tunnelReady := meta.FindStatusCondition(got.Status.Conditions, cftv1beta1.TypeCloudflareTunnelTunnelReady)
+	if tunnelReady == nil || tunnelReady.Reason != "RateLimited" || tunnelReady.Message != wantErr.Error() || tunnelReady.ObservedGeneration != 3 {
+		t.Errorf("TunnelReady = %+v, want RateLimited with the error message", tunnelReady)
+	}
+	if !meta.IsStatusConditionTrue(got.Status.Conditions, cftv1beta1.TypeCloudflareTunnelDegraded) {
+		t.Errorf("conditions = %+v, want Degraded", got.Status.Conditions)
+	}
+}
+
+func TestCloudflareTunnelReconciler_reconcileDeploymentStatus(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name        string
+		deployment  *appsv1.Deployment
+		wantStatus  metav1.ConditionStatus
+		wantReason  string
+		wantRequeue bool
+	}{
+		{
+			name:        "not found",
+			wantStatus:  metav1.ConditionFalse,
+			wantReason:  "DeploymentNotFound",
+			wantRequeue: true,
+		},
+		{
+			name:        "unavailable",
+			deployment:  &appsv1.Deployment{Status: appsv1.DeploymentStatus{Replicas: 2}},
+			wantStatus:  metav1.ConditionFalse,
+			wantReason:  "Unavailable",
+			wantRequeue: true,
+		},
+		{
+			name:       "partially available",
+			deployment: &appsv1.Deployment{Status: appsv1.DeploymentStatus{Replicas: 2, AvailableReplicas: 1}},
+			wantStatus: metav1.ConditionFalse,
+			wantReason: reasonPartiallyAvailable,
+		},
+		{
+			name:       "available",
+			deployment: &appsv1.Deployment{Status: appsv1.DeploymentStatus{Replicas: 3, AvailableReplicas: 2}},
+			wantStatus: metav1.ConditionTrue,
+			wantReason: "Available",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfTunnel := &cftv1beta1.CloudflareTunnel{
+				ObjectMeta: metav1.ObjectMeta{Name: "tunnel", Namespace: "default"},
+				Spec:       cftv1beta1.CloudflareTunnelSpec{Replicas: 2},
+			}
+			builder := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme)
+			if tt.deployment != nil {
+				tt.deployment.ObjectMeta = metav1.ObjectMeta{Name: "tunnel", Namespace: "default"}
+				builder = builder.WithObjects(tt.deployment)
+			}
+			r := &CloudflareTunnelReconciler{Client: builder.Build()}
+
+			requeue, err := r.reconcileDeploymentStatus(ctx, cfTunnel)
+			if err != nil {
+				t.Fatalf("reconcileDeploymentStatus() error = %v", err)
+			}
+			if requeue != tt.wantRequeue {
+				t.Errorf("reconcileDeploymentStatus() = %v, want %v", requeue, tt.wantRequeue)
+			}
+			condition := meta.FindStatusCondition(cfTunnel.Status.Conditions, cftv1beta1.TypeCloudflareTunnelDeploymentReady)
+			if condition == nil || condition.Status != tt.wantStatus || condition.Reason != tt.wantReason {
+				t.Errorf("DeploymentReady = %+v, want %v (%v)", condition, tt.wantStatus, tt.wantReason)
+			}
+		})
+	}
+}
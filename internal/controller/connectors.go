@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	cftv1beta1 "github.com/walnuts1018/cloudflare-tunnel-operator/api/v1beta1"
+	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/domain"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// reconcileConnectors records the connectors of the tunnel reported by Cloudflare in the status and sets the Connected condition.
+// A failure to list the connectors is reported in the condition instead of failing the reconcile, because the status of the connectors
+// does not affect the other resources.
+// The connectors are fetched once per ConnectorCheckInterval, and the time until the next check is returned.
+func (r *CloudflareTunnelReconciler) reconcileConnectors(ctx context.Context, manager CloudflareTunnelManager, cfTunnel *cftv1beta1.CloudflareTunnel) time.Duration {
+	if r.ConnectorCheckInterval <= 0 {
+		return 0
+	}
+
+	// Statusの更新でもReconcileが呼ばれるので、前回から間隔が空いていなければ何もしない
+	if last := cfTunnel.Status.LastConnectorCheckTime; last != nil {
+		if elapsed := time.Since(last.Time); elapsed < r.ConnectorCheckInterval {
+			return r.ConnectorCheckInterval - elapsed
+		}
+	}
+	cfTunnel.Status.LastConnectorCheckTime = ptr.To(metav1.Now())
+
+	connectors, err := manager.ListTunnelConnectors(ctx, cfTunnel.Status.TunnelID)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list tunnel connectors.", "tunnelID", cfTunnel.Status.TunnelID)
+		setCondition(cfTunnel, cftv1beta1.TypeCloudflareTunnelConnected, metav1.ConditionUnknown, failureReason(err, "CheckFailed"), err.Error())
+		return r.ConnectorCheckInterval
+	}
+
+	setConnectorStatus(cfTunnel, connectors)
+	connectedReplicasGauge.WithLabelValues(cfTunnel.Namespace, cfTunnel.Name).Set(float64(cfTunnel.Status.ConnectedReplicas))
+	tunnelConnectionsGauge.WithLabelValues(cfTunnel.Namespace, cfTunnel.Name).Set(float64(cfTunnel.Status.Connections))
+	return r.ConnectorCheckInterval
+}
+
+// setConnectorStatus sets the connectors, their counts and the Connected condition of the status.
+func setConnectorStatus(cfTunnel *cftv1beta1.CloudflareTunnel, connectors []domain.TunnelConnector) {
+	var connected, connections int32
+	statuses := make([]cftv1beta1.TunnelConnectorStatus, 0, len(connectors))
+	for _, connector := range connectors {
+		// 切断済みのコネクタも少しの間は一覧に残るので、有効な接続のあるものだけを数える
+		if !connector.Active() {
+			continue
+		}
+
+		status := cftv1beta1.TunnelConnectorStatus{
+			ID:          connector.ID,
+			Version:     connector.Version,
+			Connections: int32(connector.ActiveConnections()),
+		}
+		for _, conn := range connector.Connections {
+			if conn.PendingReconnect {
+				continue
+			}
+			if conn.Colo != "" && !slices.Contains(status.Colos, conn.Colo) {
+				status.Colos = append(status.Colos, conn.Colo)
+			}
+			if conn.OriginIP != "" && !slices.Contains(status.OriginIPs, conn.OriginIP) {
+				status.OriginIPs = append(status.OriginIPs, conn.OriginIP)
+			}
+		}
+		slices.Sort(status.Colos)
+		slices.Sort(status.OriginIPs)
+
+		statuses = append(statuses, status)
+		connected++
+		connections += status.Connections
+	}
+	slices.SortFunc(statuses, func(a, b cftv1beta1.TunnelConnectorStatus) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+
+	cfTunnel.Status.Connectors = statuses
+	cfTunnel.Status.ConnectedReplicas = connected
+	cfTunnel.Status.Connections = connections
+
+	switch {
+	case cfTunnel.Spec.Replicas == 0:
+		setCondition(cfTunnel, cftv1beta1.TypeCloudflareTunnelConnected, metav1.ConditionFalse, "NoReplicas", "cloudflared is scaled to zero")
+	case connected == 0:
+		setCondition(cfTunnel, cftv1beta1.TypeCloudflareTunnelConnected, metav1.ConditionFalse, "NotConnected",
+			"No replica is connected to the Cloudflare edge. Check the logs of cloudflared, e.g. for a revoked token.")
+	default:
+		message := fmt.Sprintf("%d of %d replicas are connected to the Cloudflare edge with %d connections", connected, cfTunnel.Spec.Replicas, connections)
+		if connected < cfTunnel.Spec.Replicas {
+			setCondition(cfTunnel, cftv1beta1.TypeCloudflareTunnelConnected, metav1.ConditionFalse, "PartiallyConnected", message)
+		} else {
+			setCondition(cfTunnel, cftv1beta1.TypeCloudflareTunnelConnected, metav1.ConditionTrue, "Connected", message)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package controller
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	cftv1beta1 "github.com/walnuts1018/cloudflare-tunnel-operator/api/v1beta1"
+	mock_controller "github.com/walnuts1018/cloudflare-tunnel-operator/internal/controller/mock"
+	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/domain"
+	"go.uber.org/mock/gomock"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_setConnectorStatus(t *testing.T) {
+	connector := func(id string, conns ...domain.TunnelConnection) domain.TunnelConnector {
+		return domain.TunnelConnector{ID: id, Version: "2025.11.1", Connections: conns}
+	}
+	conn := func(colo, originIP string) domain.TunnelConnection {
+		return domain.TunnelConnection{Colo: colo, OriginIP: originIP}
+	}
+	pending := domain.TunnelConnection{Colo: "kix01", OriginIP: "192.0.2.1", PendingReconnect: true}
+
+	tests := []struct {
+		name            string
+		replicas        int32
+		connectors      []domain.TunnelConnector
+		wantConnected   int32
+		wantConnections int32
+		wantStatus      metav1.ConditionStatus
+		wantReason      string
+	}{
+		{
+			name:       "no connectors",
+			replicas:   2,
+			wantStatus: metav1.ConditionFalse,
+			wantReason: "NotConnected",
+		},
+		{
+			name:     "every replica is connected",
+			replicas: 2,
+			connectors: []domain.TunnelConnector{
+				connector("b", conn("nrt01", "192.0.2.2"), conn("kix01", "192.0.2.2")),
+				connector("a", conn("nrt01", "192.0.2.1"), conn("nrt01", "192.0.2.1")),
+			},
+			wantConnected:   2,
+			wantConnections: 4,
+			wantStatus:      metav1.ConditionTrue,
+			wantReason:      "Connected",
+		},
+		{
+			name:     "a replica has only pending connections",
+			replicas: 2,
+			connectors: []domain.TunnelConnector{
+				connector("a", conn("nrt01", "192.0.2.1")),
+				connector("b", pending),
+			},
+			wantConnected:   1,
+			wantConnections: 1,
+			wantStatus:      metav1.ConditionFalse,
+			wantReason:      "PartiallyConnected",
+		},
+		{
+			name:       "scaled to zero",
+			replicas:   0,
+			wantStatus: metav1.ConditionFalse,
+			wantReason: "NoReplicas",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfTunnel := cftv1beta1.CloudflareTunnel{Spec: cftv1beta1.CloudflareTunnelSpec{Replicas: tt.replicas}}
+			setConnectorStatus(&cfTunnel, tt.connectors)
+
+			if cfTunnel.Status.ConnectedReplicas != tt.wantConnected {
+				t.Errorf("ConnectedReplicas = %v, want %v", cfTunnel.Status.ConnectedReplicas, tt.wantConnected)
+			}
+			if cfTunnel.Status.Connections != tt.wantConnections {
+				t.Errorf("Connections = %v, want %v", cfTunnel.Status.Connections, tt.wantConnections)
+			}
+			if len(cfTunnel.Status.Connectors) != int(tt.wantConnected) {
+				t.Errorf("Connectors = %v, want %d connectors", cfTunnel.Status.Connectors, tt.wantConnected)
+			}
+			condition := meta.FindStatusCondition(cfTunnel.Status.Conditions, cftv1beta1.TypeCloudflareTunnelConnected)
+			if condition == nil {
+				t.Fatal("Connected condition is not set")
+			}
+			if condition.Status != tt.wantStatus || condition.Reason != tt.wantReason {
+				t.Errorf("Connected = %v (%v), want %v (%v)", condition.Status, condition.Reason, tt.wantStatus, tt.wantReason)
+			}
+		})
+	}
+
+	t.Run("summarizes the connections of each connector", func(t *testing.T) {
+		cfTunnel := cftv1beta1.CloudflareTunnel{Spec: cftv1beta1.CloudflareTunnelSpec{Replicas: 2}}
+		setConnectorStatus(&cfTunnel, []domain.TunnelConnector{
+			connector("b", conn("nrt01", "192.0.2.2"), conn("kix01", "192.0.2.2"), pending),
+			connector("a", conn("nrt01", "192.0.2.1")),
+		})
+
+		got := cfTunnel.Status.Connectors
+		if len(got) != 2 || got[0].ID != "a" || got[1].ID != "b" {
+			t.Fatalf("Connectors = %v, want a and b", got)
+		}
+		if !slices.Equal(got[1].Colos, []string{"kix01", "nrt01"}) || !slices.Equal(got[1].OriginIPs, []string{"192.0.2.2"}) {
+			t.Errorf("Connectors[1] = %+v, want colos kix01, nrt01 and origin IP 192.0.2.2", got[1])
+		}
+		if got[1].Connections != 2 || got[1].Version != "2025.11.1" {
+			t.Errorf("Connectors[1] = %+v, want 2 connections of version 2025.11.1", got[1])
+		}
+	})
+}
+
+func TestCloudflareTunnelReconciler_reconcileConnectors(t *testing.T) {
+	ctx := context.Background()
+	r := &CloudflareTunnelReconciler{ConnectorCheckInterval: time.Minute}
+
+	t.Run("skips the check within the interval", func(t *testing.T) {
+		m := mock_controller.NewMockCloudflareTunnelManager(gomock.NewController(t))
+		cfTunnel := cftv1beta1.CloudflareTunnel{Status: cftv1beta1.CloudflareTunnelStatus{
+			TunnelID:               "test-id",
+			LastConnectorCheckTime: &metav1.Time{Time: time.Now().Add(-20 * time.Second)},
+		}}
+
+		until := r.reconcileConnectors(ctx, m, &cfTunnel)
+		if until <= 0 || until > 40*time.Second {
+			t.Errorf("reconcileConnectors() = %v, want the time left until the next check", until)
+		}
+	})
+
+	t.Run("checks the connectors after the interval", func(t *testing.T) {
+		m := mock_controller.NewMockCloudflareTunnelManager(gomock.NewController(t))
+		m.EXPECT().ListTunnelConnectors(ctx, "test-id").Return(nil, nil)
+		last := time.Now().Add(-2 * time.Minute)
+		cfTunnel := cftv1beta1.CloudflareTunnel{Status: cftv1beta1.CloudflareTunnelStatus{
+			TunnelID:               "test-id",
+			LastConnectorCheckTime: &metav1.Time{Time: last},
+		}}
+
+		if until := r.reconcileConnectors(ctx, m, &cfTunnel); until != time.Minute {
+			t.Errorf("reconcileConnectors() = %v, want %v", until, time.Minute)
+		}
+		if !cfTunnel.Status.LastConnectorCheckTime.After(last) {
+			t.Errorf("LastConnectorCheckTime = %v, want it to be updated", cfTunnel.Status.LastConnectorCheckTime)
+		}
+	})
+}
@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	cftv1beta1 "github.com/walnuts1018/cloudflare-tunnel-operator/api/v1beta1"
+	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/domain"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	credentialsAPITokenKey  = "cloudflareAPIToken"
+	credentialsAccountIDKey = "cloudflareAccountID"
+	credentialsZonesKey     = "cloudflareZones"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid cloudflare credentials")
+)
+
+type NewCloudflareTunnelManagerFunc func(credentials domain.CloudflareCredentials) (CloudflareTunnelManager, error)
+
+// CloudflareCredentialsCache builds CloudflareTunnelManagers from the Secrets referenced by spec.credentialsRef
+// and caches them per Secret. The entry of a Secret is replaced when its credentials change,
+// and Secrets with the same credentials share the same client, so that the rate limit applies per account.
+type CloudflareCredentialsCache struct {
+	newManager NewCloudflareTunnelManagerFunc
+
+	mu      sync.Mutex
+	entries map[types.NamespacedName]credentialsEntry
+}
+
+type credentialsEntry struct {
+	credentials domain.CloudflareCredentials
+	manager     CloudflareTunnelManager
+}
+
+func NewCloudflareCredentialsCache(newManager NewCloudflareTunnelManagerFunc) *CloudflareCredentialsCache {
+	return &CloudflareCredentialsCache{
+		newManager: newManager,
+		entries:    make(map[types.NamespacedName]credentialsEntry),
+	}
+}
+
+func (c *CloudflareCredentialsCache) get(secret types.NamespacedName, credentials domain.CloudflareCredentials) (CloudflareTunnelManager, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[secret]; ok && entry.credentials == credentials {
+		return entry.manager, nil
+	}
+
+	// トークンが更新された場合は古いクライアントを捨てる。同じ認証情報の別のSecretがあれば、そのクライアントを使う
+	for _, entry := range c.entries {
+		if entry.credentials == credentials {
+			c.entries[secret] = entry
+			return entry.manager, nil
+		}
+	}
+
+	manager, err := c.newManager(credentials)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Cloudflare Tunnel client: %w", err)
+	}
+	c.entries[secret] = credentialsEntry{credentials: credentials, manager: manager}
+	return manager, nil
+}
+
+// cloudflareTunnelManagerFor returns the CloudflareTunnelManager and the allowed DNS zones for cfTunnel.
+// If cfTunnel has no credentialsRef, defaultManager is returned.
+func cloudflareTunnelManagerFor(
+	ctx context.Context,
+	c client.Reader,
+	defaultManager CloudflareTunnelManager,
+	cache *CloudflareCredentialsCache,
+	cfTunnel cftv1beta1.CloudflareTunnel,
+) (CloudflareTunnelManager, []string, error) {
+	if cfTunnel.Spec.CredentialsRef == nil || cache == nil {
+		return defaultManager, cfTunnel.Spec.Zones, nil
+	}
+
+	var secret corev1.Secret
+	if err := c.Get(ctx, client.ObjectKey{Namespace: cfTunnel.Namespace, Name: cfTunnel.Spec.CredentialsRef.Name}, &secret); err != nil {
+		return nil, nil, fmt.Errorf("failed to get credentials secret: %w", err)
+	}
+
+	credentials := domain.CloudflareCredentials{
+		APIToken:  strings.TrimSpace(string(secret.Data[credentialsAPITokenKey])),
+		AccountID: strings.TrimSpace(string(secret.Data[credentialsAccountIDKey])),
+	}
+	if credentials.APIToken == "" || credentials.AccountID == "" {
+		return nil, nil, fmt.Errorf("%w: secret %s/%s must contain %s and %s", ErrInvalidCredentials, secret.Namespace, secret.Name, credentialsAPITokenKey, credentialsAccountIDKey)
+	}
+
+	manager, err := cache.get(client.ObjectKeyFromObject(&secret), credentials)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	zones := cfTunnel.Spec.Zones
+	if len(zones) == 0 {
+		zones = splitZones(string(secret.Data[credentialsZonesKey]))
+	}
+
+	return manager, zones, nil
+}
+
+func splitZones(v string) []string {
+	var zones []string
+	for zone := range strings.SplitSeq(v, ",") {
+		if zone = strings.TrimSpace(zone); zone != "" {
+			zones = append(zones, zone)
+		}
+	}
+	return zones
+}
+
+// cloudflareTunnelsForSecret returns the CloudflareTunnels that reference the Secret with spec.credentialsRef,
+// so that a rotated token or a change of the zones is applied without waiting for another event.
+func (r *CloudflareTunnelReconciler) cloudflareTunnelsForSecret(ctx context.Context, obj client.Object) []reconcile.Request {
+	var cfTunnels cftv1beta1.CloudflareTunnelList
+	if err := r.List(ctx, &cfTunnels, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "failed to list CloudflareTunnels")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, cfTunnel := range cfTunnels.Items {
+		if cfTunnel.Spec.CredentialsRef != nil && cfTunnel.Spec.CredentialsRef.Name == obj.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&cfTunnel)})
+		}
+	}
+	return requests
+}
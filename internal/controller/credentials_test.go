@@ -0,0 +1,190 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	cftv1beta1 "github.com/walnuts1018/cloudflare-tunnel-operator/api/v1beta1"
+	mock_controller "github.com/walnuts1018/cloudflare-tunnel-operator/internal/controller/mock"
+	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/domain"
+	"go.uber.org/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func Test_cloudflareTunnelManagerFor(t *testing.T) {
+	ctx := context.Background()
+	gomockctrl := gomock.NewController(t)
+
+	defaultManager := mock_controller.NewMockCloudflareTunnelManager(gomockctrl)
+	accountManager := mock_controller.NewMockCloudflareTunnelManager(gomockctrl)
+
+	var created []domain.CloudflareCredentials
+	cache := NewCloudflareCredentialsCache(func(credentials domain.CloudflareCredentials) (CloudflareTunnelManager, error) {
+		created = append(created, credentials)
+		return accountManager, nil
+	})
+
+	c := fake.NewClientBuilder().WithObjects(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "account"},
+			Data: map[string][]byte{
+				credentialsAPITokenKey:  []byte("token"),
+				credentialsAccountIDKey: []byte("account-id"),
+				credentialsZonesKey:     []byte("example.com, example.net"),
+			},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "invalid"},
+			Data: map[string][]byte{
+				credentialsAPITokenKey: []byte("token"),
+			},
+		},
+	).Build()
+
+	tests := []struct {
+		name        string
+		cfTunnel    cftv1beta1.CloudflareTunnel
+		wantManager CloudflareTunnelManager
+		wantZones   []string
+		wantErr     error
+	}{
+		{
+			name: "default credentials",
+			cfTunnel: cftv1beta1.CloudflareTunnel{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "tunnel"},
+				Spec: cftv1beta1.CloudflareTunnelSpec{
+					Zones: []string{"walnuts.dev"},
+				},
+			},
+			wantManager: defaultManager,
+			wantZones:   []string{"walnuts.dev"},
+		},
+		{
+			name: "credentialsRef",
+			cfTunnel: cftv1beta1.CloudflareTunnel{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "tunnel"},
+				Spec: cftv1beta1.CloudflareTunnelSpec{
+					CredentialsRef: &corev1.LocalObjectReference{Name: "account"},
+				},
+			},
+			wantManager: accountManager,
+			wantZones:   []string{"example.com", "example.net"},
+		},
+		{
+			name: "spec.zones takes precedence",
+			cfTunnel: cftv1beta1.CloudflareTunnel{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "tunnel"},
+				Spec: cftv1beta1.CloudflareTunnelSpec{
+					CredentialsRef: &corev1.LocalObjectReference{Name: "account"},
+					Zones:          []string{"example.com"},
+				},
+			},
+			wantManager: accountManager,
+			wantZones:   []string{"example.com"},
+		},
+		{
+			name: "invalid secret",
+			cfTunnel: cftv1beta1.CloudflareTunnel{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "tunnel"},
+				Spec: cftv1beta1.CloudflareTunnelSpec{
+					CredentialsRef: &corev1.LocalObjectReference{Name: "invalid"},
+				},
+			},
+			wantErr: ErrInvalidCredentials,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager, zones, err := cloudflareTunnelManagerFor(ctx, c, defaultManager, cache, tt.cfTunnel)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("cloudflareTunnelManagerFor() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("cloudflareTunnelManagerFor() error = %v", err)
+			}
+			if manager != tt.wantManager {
+				t.Errorf("cloudflareTunnelManagerFor() manager = %v, want %v", manager, tt.wantManager)
+			}
+			if !reflect.DeepEqual(zones, tt.wantZones) {
+				t.Errorf("cloudflareTunnelManagerFor() zones = %v, want %v", zones, tt.wantZones)
+			}
+		})
+	}
+
+	if want := []domain.CloudflareCredentials{{APIToken: "token", AccountID: "account-id"}}; !reflect.DeepEqual(created, want) {
+		t.Errorf("managers created = %v, want %v", created, want)
+	}
+}
+
+func TestCloudflareCredentialsCache_get(t *testing.T) {
+	gomockctrl := gomock.NewController(t)
+
+	var created []domain.CloudflareCredentials
+	cache := NewCloudflareCredentialsCache(func(credentials domain.CloudflareCredentials) (CloudflareTunnelManager, error) {
+		created = append(created, credentials)
+		return mock_controller.NewMockCloudflareTunnelManager(gomockctrl), nil
+	})
+
+	secretA := types.NamespacedName{Namespace: "default", Name: "a"}
+	secretB := types.NamespacedName{Namespace: "default", Name: "b"}
+	oldCredentials := domain.CloudflareCredentials{APIToken: "old", AccountID: "account-id"}
+	newCredentials := domain.CloudflareCredentials{APIToken: "new", AccountID: "account-id"}
+
+	first, _ := cache.get(secretA, oldCredentials)
+	if again, _ := cache.get(secretA, oldCredentials); again != first {
+		t.Error("get() created another manager for the same credentials")
+	}
+
+	rotated, _ := cache.get(secretA, newCredentials)
+	if rotated == first {
+		t.Error("get() returned the manager of the old token")
+	}
+	if shared, _ := cache.get(secretB, newCredentials); shared != rotated {
+		t.Error("get() did not share the manager of the same credentials")
+	}
+
+	if len(cache.entries) != 2 {
+		t.Errorf("entries = %v, want one per Secret", cache.entries)
+	}
+	if want := []domain.CloudflareCredentials{oldCredentials, newCredentials}; !reflect.DeepEqual(created, want) {
+		t.Errorf("managers created = %v, want %v", created, want)
+	}
+}
+
+func TestCloudflareTunnelReconciler_cloudflareTunnelsForSecret(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := cftv1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	cfTunnel := func(namespace, name, secret string) *cftv1beta1.CloudflareTunnel {
+		t := &cftv1beta1.CloudflareTunnel{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+		if secret != "" {
+			t.Spec.CredentialsRef = &corev1.LocalObjectReference{Name: secret}
+		}
+		return t
+	}
+	r := &CloudflareTunnelReconciler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			cfTunnel("default", "a", "account"),
+			cfTunnel("default", "b", "other"),
+			cfTunnel("default", "c", ""),
+			cfTunnel("other", "d", "account"),
+		).Build(),
+	}
+
+	got := r.cloudflareTunnelsForSecret(context.Background(), &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "account"}})
+	want := []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: "default", Name: "a"}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("cloudflareTunnelsForSecret() = %v, want %v", got, want)
+	}
+}
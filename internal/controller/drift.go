@@ -0,0 +1,250 @@
+package controller
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/cloudflare/cloudflare-go"
+	cftv1beta1 "github.com/walnuts1018/cloudflare-tunnel-operator/api/v1beta1"
+	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/domain"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+)
+
+// tunnelDrift is the number of items that differed from the desired state and were repaired.
+type tunnelDrift struct {
+	Rules      int
+	DNSRecords int
+}
+
+// syncTunnelRules rebuilds the rules of the resources bound to the tunnel, compares them and the DNS records of their hostnames
+// with the ones in Cloudflare, and repairs them if they were changed or deleted outside of the operator, e.g. on the dashboard.
+// The ownership record is only used to decide which rules may be pruned.
+func syncTunnelRules(
+	ctx context.Context,
+	c client.Client,
+	manager CloudflareTunnelManager,
+	cfTunnel cftv1beta1.CloudflareTunnel,
+	zones []string,
+) (tunnelDrift, error) {
+	logger := log.FromContext(ctx)
+
+	owned, pruned, driftedRules, err := tunnelConfigWriterFor(cfTunnel.Status.TunnelID).sync(ctx, c, manager, cfTunnel, func(recorded managedRules) (managedRules, error) {
+		return desiredTunnelRules(ctx, c, cfTunnel, recorded)
+	})
+	if err != nil {
+		return tunnelDrift{}, err
+	}
+	drift := tunnelDrift{Rules: driftedRules}
+
+	for _, hostname := range owned.hostnames() {
+		zone, err := manager.ResolveZone(ctx, hostname, zones)
+		if err != nil {
+			if errors.Is(err, domain.ErrZoneNotFound) {
+				logger.Info("zone not found, skip checking DNS record", "hostname", hostname)
+				continue
+			}
+			return drift, fmt.Errorf("failed to resolve zone: %w", err)
+		}
+
+		record, err := manager.GetDNS(ctx, zone.ID, cfTunnel.Status.TunnelID, hostname)
+		if err != nil {
+			return drift, fmt.Errorf("failed to get DNS record: %w", err)
+		}
+		if record.Healthy(cfTunnel.Status.TunnelID) {
+			continue
+		}
+
+		logger.Info("repairing drifted DNS record", "hostname", hostname)
+		drift.DNSRecords++
+		if err := appendDNSRecord(ctx, manager, cfTunnel.Status.TunnelID, hostname, zones); err != nil {
+			return drift, fmt.Errorf("failed to update Cloudflare DNS Record: %w", err)
+		}
+	}
+
+	// 使われなくなったホスト名のDNSレコードを消す
+	var prunedRules []ingressRule
+	for _, key := range pruned {
+		prunedRules = append(prunedRules, ingressRule{Hostname: key.Hostname, Path: key.Path})
+	}
+	for _, hostname := range hostnames(prunedRules) {
+		if slices.Contains(owned.hostnames(), hostname) {
+			continue
+		}
+		logger.Info("removing DNS record of pruned rules", "hostname", hostname)
+		if err := removeDNSRecord(ctx, manager, cfTunnel.Status.TunnelID, hostname, zones); err != nil {
+			return drift, fmt.Errorf("failed to delete Cloudflare DNS Record: %w", err)
+		}
+	}
+
+	return drift, nil
+}
+
+// ownerRules are the rules built from a resource bound to a tunnel, or the error that prevented building them.
+type ownerRules struct {
+	owner string
+	rules []ingressRule
+	err   error
+}
+
+// desiredTunnelRules builds the rules of the Ingresses, HTTPRoutes, Services and TunnelRoutes bound to the tunnel.
+// A resource is bound if its applied-tunnel annotation points to the tunnel, or if it has no annotation yet but owns rules in recorded.
+// The recorded rules of a resource whose rules cannot be built are kept, so that they are not pruned by a transient error,
+// and a rule claimed by more than one resource is kept by the recorded owner.
+func desiredTunnelRules(ctx context.Context, c client.Client, cfTunnel cftv1beta1.CloudflareTunnel, recorded managedRules) (managedRules, error) {
+	logger := log.FromContext(ctx)
+
+	recordedOwners := map[string]bool{}
+	for _, rule := range recorded {
+		recordedOwners[rule.Owner] = true
+	}
+	bound := func(obj client.Object, owner string) bool {
+		if !obj.GetDeletionTimestamp().IsZero() || checkToBeIgnored(obj.GetAnnotations()) {
+			return false
+		}
+		if v := obj.GetAnnotations()[appliedTunnelAnnotation]; v != "" {
+			return v == cfTunnel.Namespace+"/"+cfTunnel.Name
+		}
+		return recordedOwners[owner]
+	}
+
+	var owners []ownerRules
+
+	var ingresses networkingv1.IngressList
+	if err := c.List(ctx, &ingresses); err != nil {
+		return nil, fmt.Errorf("failed to list Ingresses: %w", err)
+	}
+	ingressReconciler := &IngressReconciler{Client: c}
+	for _, ingress := range ingresses.Items {
+		owner := ruleOwner("Ingress", &ingress)
+		if !bound(&ingress, owner) {
+			continue
+		}
+		mode, _, err := ingressRoutingMode(ctx, c, ingress, cfTunnel.Spec.Settings)
+		if err != nil {
+			owners = append(owners, ownerRules{owner: owner, err: err})
+			continue
+		}
+		rules, err := ingressReconciler.desiredRules(ctx, ingress, mode)
+		// 不正なAnnotationはReconcilerと同じく無視する
+		overrides, _ := parseOriginRequestAnnotations(ingress.Annotations)
+		for i := range rules {
+			rules[i].OriginRequest = overrides
+		}
+		owners = append(owners, ownerRules{owner: owner, rules: rules, err: err})
+	}
+
+	// Gateway APIがインストールされていない環境では、HTTPRouteはない
+	var routes gatewayv1.HTTPRouteList
+	if err := c.List(ctx, &routes); err != nil && !meta.IsNoMatchError(err) && !runtime.IsNotRegisteredError(err) {
+		return nil, fmt.Errorf("failed to list HTTPRoutes: %w", err)
+	}
+	httpRouteReconciler := &HTTPRouteReconciler{Client: c}
+	for _, route := range routes.Items {
+		owner := ruleOwner("HTTPRoute", &route)
+		if !bound(&route, owner) {
+			continue
+		}
+		mode, err := detectRoutingMode(route.Annotations, cfTunnel.Spec.Settings)
+		if err != nil {
+			owners = append(owners, ownerRules{owner: owner, err: err})
+			continue
+		}
+		rules, err := httpRouteReconciler.desiredRules(ctx, route, mode)
+		owners = append(owners, ownerRules{owner: owner, rules: rules, err: err})
+	}
+
+	var services corev1.ServiceList
+	if err := c.List(ctx, &services); err != nil {
+		return nil, fmt.Errorf("failed to list Services: %w", err)
+	}
+	for _, svc := range services.Items {
+		owner := ruleOwner("Service", &svc)
+		if svc.Annotations[hostnameAnnotation] == "" || !bound(&svc, owner) {
+			continue
+		}
+		rule, err := serviceRule(svc)
+		owners = append(owners, ownerRules{owner: owner, rules: []ingressRule{rule}, err: err})
+	}
+
+	var tunnelRoutes cftv1beta1.TunnelRouteList
+	if err := c.List(ctx, &tunnelRoutes); err != nil {
+		return nil, fmt.Errorf("failed to list TunnelRoutes: %w", err)
+	}
+	for _, route := range tunnelRoutes.Items {
+		owner := ruleOwner("TunnelRoute", &route)
+		if !bound(&route, owner) {
+			continue
+		}
+		rule := tunnelRouteRule(route)
+		owners = append(owners, ownerRules{owner: owner, rules: []ingressRule{rule}, err: domain.ValidateOriginService(rule.Service)})
+	}
+
+	desired := managedRules{}
+	for _, o := range owners {
+		if o.err != nil {
+			logger.Info("failed to build rules, keeping the recorded ones", "owner", o.owner, "error", o.err.Error())
+			for key, rule := range recorded {
+				if _, ok := desired[key]; !ok && rule.Owner == o.owner {
+					desired[key] = rule
+				}
+			}
+			continue
+		}
+
+		for _, rule := range o.rules {
+			key := rule.key()
+			if current, ok := desired[key]; ok && current.Owner != o.owner && recorded[key].Owner != o.owner {
+				continue
+			}
+			desired[key] = managedRule{
+				Hostname:      rule.Hostname,
+				Path:          rule.Path,
+				Service:       rule.Service,
+				OriginRequest: rule.OriginRequest,
+				Owner:         o.owner,
+			}
+		}
+	}
+	return desired, nil
+}
+
+// countDriftedRules returns the number of desired rules that are missing or different in the live rules,
+// plus the number of live rules that are not desired, which are pruned in the exclusive mode.
+func countDriftedRules(live, desired []cloudflare.UnvalidatedIngressRule) int {
+	liveRules := make(map[ruleKey]cloudflare.UnvalidatedIngressRule, len(live))
+	for _, rule := range live {
+		liveRules[ruleKey{Hostname: rule.Hostname, Path: rule.Path}] = rule
+	}
+
+	drifted := 0
+	for _, rule := range desired {
+		key := ruleKey{Hostname: rule.Hostname, Path: rule.Path}
+		if l, ok := liveRules[key]; !ok || !sameRule(l, rule) {
+			drifted++
+		}
+		delete(liveRules, key)
+	}
+	return drifted + len(liveRules)
+}
+
+// sameRule compares the rules by their JSON representation, which is what cloudflared receives.
+func sameRule(a, b cloudflare.UnvalidatedIngressRule) bool {
+	if a.Service != b.Service {
+		return false
+	}
+
+	// APIは空のoriginRequestを{}で返すので、nilと同じものとして扱う
+	ja, errA := json.Marshal(cmp.Or(a.OriginRequest, &cloudflare.OriginRequestConfig{}))
+	jb, errB := json.Marshal(cmp.Or(b.OriginRequest, &cloudflare.OriginRequestConfig{}))
+	return errA == nil && errB == nil && string(ja) == string(jb)
+}
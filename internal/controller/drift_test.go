@@ -0,0 +1,236 @@
+package controller
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/cloudflare/cloudflare-go"
+	cftv1beta1 "github.com/walnuts1018/cloudflare-tunnel-operator/api/v1beta1"
+	mock_controller "github.com/walnuts1018/cloudflare-tunnel-operator/internal/controller/mock"
+	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/domain"
+	"go.uber.org/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_countDriftedRules(t *testing.T) {
+	desired := []cloudflare.UnvalidatedIngressRule{
+		{Hostname: "web.walnuts.dev", Service: "http://web:80", OriginRequest: &cloudflare.OriginRequestConfig{NoTLSVerify: ptr.To(false)}},
+		{Service: "http_status:404"},
+	}
+
+	tests := []struct {
+		name string
+		live []cloudflare.UnvalidatedIngressRule
+		want int
+	}{
+		{
+			name: "no drift",
+			live: []cloudflare.UnvalidatedIngressRule{
+				{Hostname: "web.walnuts.dev", Service: "http://web:80", OriginRequest: &cloudflare.OriginRequestConfig{NoTLSVerify: ptr.To(false)}},
+				{Service: "http_status:404", OriginRequest: &cloudflare.OriginRequestConfig{}},
+			},
+			want: 0,
+		},
+		{
+			name: "deleted rule",
+			live: []cloudflare.UnvalidatedIngressRule{
+				{Service: "http_status:404"},
+			},
+			want: 1,
+		},
+		{
+			name: "changed service and origin settings",
+			live: []cloudflare.UnvalidatedIngressRule{
+				{Hostname: "web.walnuts.dev", Service: "http://web:80", OriginRequest: &cloudflare.OriginRequestConfig{NoTLSVerify: ptr.To(true)}},
+				{Service: "http_status:503"},
+			},
+			want: 2,
+		},
+		{
+			name: "unknown rule",
+			live: []cloudflare.UnvalidatedIngressRule{
+				{Hostname: "dashboard.walnuts.dev", Service: "http://dashboard:80"},
+				{Hostname: "web.walnuts.dev", Service: "http://web:80", OriginRequest: &cloudflare.OriginRequestConfig{NoTLSVerify: ptr.To(false)}},
+				{Service: "http_status:404"},
+			},
+			want: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := countDriftedRules(tt.live, desired); got != tt.want {
+				t.Errorf("countDriftedRules() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_syncTunnelRules(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	if err := cftv1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := networkingv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	cfTunnel := cftv1beta1.CloudflareTunnel{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "drift", UID: "drift-uid"},
+		Spec: cftv1beta1.CloudflareTunnelSpec{
+			Settings: cftv1beta1.CloudflareTunnelSettings{CatchAllRule: "http_status:404"},
+		},
+		Status: cftv1beta1.CloudflareTunnelStatus{TunnelID: "tunnel-id"},
+	}
+	// 削除済みのIngressのルールが記録に残っている
+	tunnelConfigWriterFor(cfTunnel.Status.TunnelID).owned = managedRules{
+		{Hostname: "old.walnuts.dev"}: {Hostname: "old.walnuts.dev", Service: "http://old:80", Owner: "Ingress/default/old"},
+	}
+	t.Cleanup(func() {
+		forgetTunnelConfigWriter(cfTunnel.Status.TunnelID)
+	})
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "web",
+			Annotations: map[string]string{
+				hostnameAnnotation:      "web.walnuts.dev",
+				appliedTunnelAnnotation: "default/drift",
+			},
+		},
+		Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{{Port: 80}}},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&cfTunnel, svc).Build()
+
+	gomockctrl := gomock.NewController(t)
+	manager := mock_controller.NewMockCloudflareTunnelManager(gomockctrl)
+	manager.EXPECT().GetTunnelConfiguration(gomock.Any(), "tunnel-id").Return(domain.TunnelConfiguration{
+		Ingress: []cloudflare.UnvalidatedIngressRule{
+			{Hostname: "dashboard.walnuts.dev", Service: "http://dashboard:80"},
+			{Hostname: "old.walnuts.dev", Service: "http://old:80"},
+			{Service: "http_status:404"},
+		},
+	}, nil)
+	manager.EXPECT().UpdateTunnelConfiguration(gomock.Any(), "tunnel-id", gomock.Any()).DoAndReturn(func(_ context.Context, _ string, config domain.TunnelConfiguration) (domain.TunnelConfiguration, error) {
+		want := []string{"dashboard.walnuts.dev", "web.walnuts.dev", ""}
+		var got []string
+		for _, rule := range config.Ingress {
+			got = append(got, rule.Hostname)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("UpdateTunnelConfiguration() hostnames = %v, want %v", got, want)
+		}
+		return config, nil
+	})
+
+	// 記録にない管理外のルールは残し、削除済みのIngressのルールとDNSレコードは消す
+	manager.EXPECT().ResolveZone(gomock.Any(), "web.walnuts.dev", gomock.Any()).Return(domain.Zone{ID: "zone-id"}, nil).Times(2)
+	manager.EXPECT().GetDNS(gomock.Any(), "zone-id", "tunnel-id", "web.walnuts.dev").Return(domain.DNSRecord{}, nil).Times(2)
+	manager.EXPECT().AddDNS(gomock.Any(), "zone-id", "tunnel-id", "web.walnuts.dev").Return(nil)
+	manager.EXPECT().ResolveZone(gomock.Any(), "old.walnuts.dev", gomock.Any()).Return(domain.Zone{ID: "zone-id"}, nil)
+	manager.EXPECT().GetDNS(gomock.Any(), "zone-id", "tunnel-id", "old.walnuts.dev").Return(domain.DNSRecord{
+		ID:      "old-record-id",
+		Type:    "CNAME",
+		Content: "tunnel-id.cfargotunnel.com",
+		Proxied: ptr.To(true),
+	}, nil)
+	manager.EXPECT().DeleteDNS(gomock.Any(), "zone-id", "old-record-id").Return(nil)
+
+	got, err := syncTunnelRules(ctx, c, manager, cfTunnel, nil)
+	if err != nil {
+		t.Fatalf("syncTunnelRules() error = %v", err)
+	}
+	if want := (tunnelDrift{Rules: 2, DNSRecords: 1}); got != want {
+		t.Errorf("syncTunnelRules() = %+v, want %+v", got, want)
+	}
+
+	owned, err := loadManagedRules(ctx, c, cfTunnel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := managedRules{
+		{Hostname: "web.walnuts.dev"}: {Hostname: "web.walnuts.dev", Service: "http://web.default.svc:80", Owner: "Service/default/web"},
+	}
+	if !reflect.DeepEqual(owned, want) {
+		t.Errorf("managed rules = %+v, want %+v", owned, want)
+	}
+}
+
+func Test_desiredTunnelRules(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	if err := cftv1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := networkingv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	cfTunnel := cftv1beta1.CloudflareTunnel{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "tunnel"},
+	}
+	service := func(name, hostname, appliedTunnel string) *corev1.Service {
+		annotations := map[string]string{hostnameAnnotation: hostname}
+		if appliedTunnel != "" {
+			annotations[appliedTunnelAnnotation] = appliedTunnel
+		}
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Annotations: annotations},
+			Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Port: 80}}},
+		}
+	}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		service("web", "web.walnuts.dev", "default/tunnel"),
+		// 他のトンネルのもの
+		service("other", "other.walnuts.dev", "default/other"),
+		// 記録にあるが、まだAnnotationがついていないもの
+		service("legacy", "legacy.walnuts.dev", ""),
+		// 先に追加された方がルールを持つ
+		service("web-copy", "web.walnuts.dev", "default/tunnel"),
+		// ルールを作れないものは記録を残す
+		&cftv1beta1.TunnelRoute{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "default",
+				Name:        "ssh",
+				Annotations: map[string]string{appliedTunnelAnnotation: "default/tunnel"},
+			},
+			Spec: cftv1beta1.TunnelRouteSpec{Hostname: "ssh.walnuts.dev", Service: "udp://ssh:22"},
+		},
+	).Build()
+
+	recorded := managedRules{
+		{Hostname: "web.walnuts.dev"}:    {Hostname: "web.walnuts.dev", Service: "http://web.default.svc:80", Owner: "Service/default/web"},
+		{Hostname: "legacy.walnuts.dev"}: {Hostname: "legacy.walnuts.dev", Service: "http://legacy.default.svc:80", Owner: "Service/default/legacy"},
+		{Hostname: "ssh.walnuts.dev"}:    {Hostname: "ssh.walnuts.dev", Service: "ssh://ssh:22", Owner: "TunnelRoute/default/ssh"},
+		{Hostname: "gone.walnuts.dev"}:   {Hostname: "gone.walnuts.dev", Service: "http://gone:80", Owner: "Ingress/default/gone"},
+	}
+
+	got, err := desiredTunnelRules(ctx, c, cfTunnel, recorded)
+	if err != nil {
+		t.Fatalf("desiredTunnelRules() error = %v", err)
+	}
+	want := managedRules{
+		{Hostname: "web.walnuts.dev"}:    recorded[ruleKey{Hostname: "web.walnuts.dev"}],
+		{Hostname: "legacy.walnuts.dev"}: recorded[ruleKey{Hostname: "legacy.walnuts.dev"}],
+		{Hostname: "ssh.walnuts.dev"}:    recorded[ruleKey{Hostname: "ssh.walnuts.dev"}],
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("desiredTunnelRules() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,536 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"slices"
+
+	cftv1beta1 "github.com/walnuts1018/cloudflare-tunnel-operator/api/v1beta1"
+	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/domain"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apiextensions "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+	gatewayv1beta1 "sigs.k8s.io/gateway-api/apis/v1beta1"
+)
+
+// HTTPRouteReconciler reconciles a HTTPRoute object
+type HTTPRouteReconciler struct {
+	client.Client
+	Scheme                  *runtime.Scheme
+	CloudflareTunnelManager CloudflareTunnelManager
+	Credentials             *CloudflareCredentialsCache
+	Recorder                record.EventRecorder
+	// MaxConcurrentReconciles is the number of resources reconciled in parallel. The default is 1.
+	MaxConcurrentReconciles int
+}
+
+// +kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=httproutes,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=gateways,verbs=get;list;watch
+// +kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=referencegrants,verbs=get;list;watch
+
+func (r *HTTPRouteReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	route := &gatewayv1.HTTPRoute{}
+	if err := r.Get(ctx, req.NamespacedName, route); err != nil {
+		if apierrors.IsNotFound(err) {
+			logger.Info("httproute resource not found")
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, fmt.Errorf("failed to get HTTPRoute: %w", err)
+	}
+
+	if !route.DeletionTimestamp.IsZero() {
+		if controllerutil.ContainsFinalizer(route, finalizerName) {
+			if err := r.finalizeHTTPRoute(ctx, route); err != nil {
+				return ctrl.Result{}, fmt.Errorf("failed to finalize HTTPRoute: %w", err)
+			}
+
+			controllerutil.RemoveFinalizer(route, finalizerName)
+			if err := r.Update(ctx, route); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if !controllerutil.ContainsFinalizer(route, finalizerName) {
+		controllerutil.AddFinalizer(route, finalizerName)
+		if err := r.Update(ctx, route); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to add finalizer to HTTPRoute: %w", err)
+		}
+	}
+
+	cfTunnelName, err := detectCloudflareTunnelName(route.Annotations)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to detect Cloudflare Tunnel name: %w", err)
+	}
+
+	cfTunnel, err := getCloudflareTunnel(ctx, r.Client, cfTunnelName)
+	if err != nil {
+		if errors.Is(err, ErrDefaultCloudflareTunnelNotExists) {
+			logger.Info("default Cloudflare Tunnel not exists")
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, fmt.Errorf("failed to get Cloudflare Tunnel: %w", err)
+	}
+
+	if !cfTunnel.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, fmt.Errorf("cloudflare tunnel is being deleted: %w", ErrCloudflareTunnelNotFound)
+	}
+
+	tunnelID := cfTunnel.Status.TunnelID
+	if tunnelID == "" {
+		return ctrl.Result{}, fmt.Errorf("tunnel ID is empty")
+	}
+
+	manager, zones, err := cloudflareTunnelManagerFor(ctx, r.Client, r.CloudflareTunnelManager, r.Credentials, cfTunnel)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to get Cloudflare Tunnel client: %w", err)
+	}
+
+	mode, err := detectRoutingMode(route.Annotations, cfTunnel.Spec.Settings)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to detect routing mode: %w", err)
+	}
+
+	// 別のトンネルに移された場合は、前のトンネルからルールを消す
+	owner := ruleOwner("HTTPRoute", route)
+	if err := releasePreviousTunnel(ctx, r.Client, r.CloudflareTunnelManager, r.Credentials, route, owner, &cfTunnel); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to remove rules from the previous Cloudflare Tunnel: %w", err)
+	}
+
+	// Ignore Annotationがついていたらエントリを追加しない
+	// 既に追加されていたら削除する
+	if checkToBeIgnored(route.Annotations) {
+		if err := releaseTunnelRules(ctx, r.Client, manager, cfTunnel, zones, owner); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, recordAppliedTunnel(ctx, r.Client, route, nil)
+	}
+
+	rules, err := r.desiredRules(ctx, *route, mode)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to build tunnel ingress rules: %w", err)
+	}
+
+	// cloudflaredは重み付きの振り分けができないので、最初のbackendRefだけを使う
+	if mode == cftv1beta1.RoutingModeService && hasMultipleBackendRefs(*route) {
+		r.Recorder.Event(route, corev1.EventTypeWarning, "MultipleBackendRefs",
+			"Only the first backendRef of each rule is used, because Cloudflare Tunnel cannot split traffic between backends")
+	}
+
+	if err := applyTunnelRules(ctx, r.Client, manager, cfTunnel, zones, owner, rules); err != nil {
+		if errors.Is(err, ErrRuleConflict) {
+			r.Recorder.Event(route, corev1.EventTypeWarning, "RuleConflict", err.Error())
+		}
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, recordAppliedTunnel(ctx, r.Client, route, &cfTunnel)
+}
+
+func (r *HTTPRouteReconciler) finalizeHTTPRoute(ctx context.Context, route *gatewayv1.HTTPRoute) error {
+	logger := log.FromContext(ctx)
+	cfTunnelName, err := detectCloudflareTunnelName(route.Annotations)
+	if err != nil {
+		return fmt.Errorf("failed to detect Cloudflare Tunnel name: %w", err)
+	}
+
+	cfTunnel, err := getCloudflareTunnel(ctx, r.Client, cfTunnelName)
+	if err != nil {
+		if errors.Is(err, ErrDefaultCloudflareTunnelNotExists) {
+			logger.Info("default Cloudflare Tunnel not exists")
+			return nil
+		}
+		return fmt.Errorf("failed to get Cloudflare Tunnel: %w", err)
+	}
+
+	// CloudflareTunnelリソースの削除時にトンネルやレコードが削除されるので、何もせずに抜ける
+	if !cfTunnel.DeletionTimestamp.IsZero() {
+		logger.Info("cloudflare tunnel is being deleted, skip removing httproute from the tunnel")
+		return nil
+	}
+
+	tunnelID := cfTunnel.Status.TunnelID
+	if tunnelID == "" {
+		return fmt.Errorf("tunnel ID is empty")
+	}
+
+	manager, zones, err := cloudflareTunnelManagerFor(ctx, r.Client, r.CloudflareTunnelManager, r.Credentials, cfTunnel)
+	if err != nil {
+		return fmt.Errorf("failed to get Cloudflare Tunnel client: %w", err)
+	}
+
+	owner := ruleOwner("HTTPRoute", route)
+	if err := releasePreviousTunnel(ctx, r.Client, r.CloudflareTunnelManager, r.Credentials, route, owner, &cfTunnel); err != nil {
+		return fmt.Errorf("failed to remove rules from the previous Cloudflare Tunnel: %w", err)
+	}
+
+	// 今のspecから作り直すと、削除前に消したホストやルーティングモードの変更前のルールが残るので、記録されたルールをすべて外す
+	return releaseTunnelRules(ctx, r.Client, manager, cfTunnel, zones, owner)
+}
+
+// desiredRules returns the tunnel ingress rules for the HTTPRoute.
+func (r *HTTPRouteReconciler) desiredRules(ctx context.Context, route gatewayv1.HTTPRoute, mode cftv1beta1.RoutingMode) ([]ingressRule, error) {
+	paths := httpRoutePaths(route)
+
+	if mode == cftv1beta1.RoutingModeService {
+		rules := make([]ingressRule, 0, len(paths))
+		for _, p := range paths {
+			if p.Backend == nil {
+				continue
+			}
+			permitted, err := r.backendPermitted(ctx, route, *p.Backend)
+			if err != nil {
+				return nil, err
+			}
+			if !permitted {
+				r.Recorder.Eventf(&route, corev1.EventTypeWarning, "RefNotPermitted",
+					"Backend %s/%s is not published because no ReferenceGrant allows the reference", *p.Backend.Namespace, p.Backend.Name)
+				continue
+			}
+			servicePort, err := r.backendServicePort(ctx, route.Namespace, *p.Backend)
+			if err != nil {
+				return nil, err
+			}
+			service, err := backendEndpoint(route.Namespace, *p.Backend, servicePort)
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, ingressRule{
+				Hostname: p.Host,
+				Path:     p.Path,
+				Service:  service,
+			})
+		}
+		return rules, nil
+	}
+
+	service, err := r.gatewayEndpoint(ctx, route)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]ingressRule, 0, len(paths))
+	for _, p := range paths {
+		rules = append(rules, ingressRule{
+			Hostname: p.Host,
+			Path:     p.Path,
+			Service:  service,
+		})
+	}
+	return rules, nil
+}
+
+type httpRoutePath struct {
+	Host    string
+	Path    string
+	Backend *gatewayv1.BackendObjectReference
+}
+
+// httpRoutePaths returns a path regex for every hostname and match of the HTTPRoute.
+// A rule without matches matches every path, and only the first backendRef of a rule is used.
+func httpRoutePaths(route gatewayv1.HTTPRoute) []httpRoutePath {
+	var paths []httpRoutePath
+	for _, hostname := range route.Spec.Hostnames {
+		for _, rule := range route.Spec.Rules {
+			var backend *gatewayv1.BackendObjectReference
+			if len(rule.BackendRefs) > 0 {
+				backend = &rule.BackendRefs[0].BackendObjectReference
+			}
+
+			matches := rule.Matches
+			if len(matches) == 0 {
+				matches = []gatewayv1.HTTPRouteMatch{{}}
+			}
+			for _, match := range matches {
+				path := httpPathRegex(match.Path)
+				if slices.ContainsFunc(paths, func(p httpRoutePath) bool {
+					return p.Host == string(hostname) && p.Path == path
+				}) {
+					continue
+				}
+				paths = append(paths, httpRoutePath{
+					Host:    string(hostname),
+					Path:    path,
+					Backend: backend,
+				})
+			}
+		}
+	}
+	return paths
+}
+
+// hasMultipleBackendRefs reports whether a rule of the HTTPRoute has more than one backendRef.
+func hasMultipleBackendRefs(route gatewayv1.HTTPRoute) bool {
+	return slices.ContainsFunc(route.Spec.Rules, func(rule gatewayv1.HTTPRouteRule) bool {
+		return len(rule.BackendRefs) > 1
+	})
+}
+
+// httpPathRegex translates an HTTPRoute path match into a cloudflared path regex.
+func httpPathRegex(match *gatewayv1.HTTPPathMatch) string {
+	if match == nil || match.Value == nil {
+		return ""
+	}
+
+	pathType := gatewayv1.PathMatchPathPrefix
+	if match.Type != nil {
+		pathType = *match.Type
+	}
+
+	switch pathType {
+	case gatewayv1.PathMatchExact:
+		return pathRegex(*match.Value, ptr.To(networkingv1.PathTypeExact))
+	case gatewayv1.PathMatchRegularExpression:
+		return *match.Value
+	default:
+		return pathRegex(*match.Value, nil)
+	}
+}
+
+// backendEndpoint returns the in-cluster URL of the backend Service.
+// servicePort is the Service port that the backend refers to, which is used to detect the protocol, or the zero value if it is not known.
+func backendEndpoint(namespace string, backend gatewayv1.BackendObjectReference, servicePort corev1.ServicePort) (string, error) {
+	if !isServiceBackend(backend) {
+		return "", fmt.Errorf("unsupported backend %s: only Services are supported", backend.Name)
+	}
+	if backend.Port == nil {
+		return "", fmt.Errorf("port of backend %s is not specified", backend.Name)
+	}
+	if backend.Namespace != nil {
+		namespace = string(*backend.Namespace)
+	}
+
+	servicePort.Port = int32(*backend.Port)
+	return domain.OriginURL(defaultOriginProtocol(servicePort), fmt.Sprintf("%s.%s.svc", backend.Name, namespace), servicePort.Port), nil
+}
+
+// backendServicePort returns the Service port that the backend refers to, or the zero value if it is not known yet.
+func (r *HTTPRouteReconciler) backendServicePort(ctx context.Context, namespace string, backend gatewayv1.BackendObjectReference) (corev1.ServicePort, error) {
+	if !isServiceBackend(backend) || backend.Port == nil {
+		return corev1.ServicePort{}, nil
+	}
+	if backend.Namespace != nil {
+		namespace = string(*backend.Namespace)
+	}
+
+	var svc corev1.Service
+	if err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: string(backend.Name)}, &svc); err != nil {
+		// Serviceが後から作られることもあるので、ポート番号だけで判断する
+		if apierrors.IsNotFound(err) {
+			return corev1.ServicePort{}, nil
+		}
+		return corev1.ServicePort{}, fmt.Errorf("failed to get Service %s/%s: %w", namespace, backend.Name, err)
+	}
+
+	i := slices.IndexFunc(svc.Spec.Ports, func(p corev1.ServicePort) bool {
+		return p.Port == int32(*backend.Port)
+	})
+	if i < 0 {
+		return corev1.ServicePort{}, nil
+	}
+	return svc.Spec.Ports[i], nil
+}
+
+// backendPermitted reports whether the HTTPRoute may refer to the backend.
+// A backend in another namespace must be allowed by a ReferenceGrant in its namespace, as required by Gateway API.
+func (r *HTTPRouteReconciler) backendPermitted(ctx context.Context, route gatewayv1.HTTPRoute, backend gatewayv1.BackendObjectReference) (bool, error) {
+	if backend.Namespace == nil || string(*backend.Namespace) == route.Namespace {
+		return true, nil
+	}
+
+	var grants gatewayv1beta1.ReferenceGrantList
+	if err := r.List(ctx, &grants, client.InNamespace(string(*backend.Namespace))); err != nil {
+		// ReferenceGrantのCRDがなければ、別のNamespaceは参照できない
+		if meta.IsNoMatchError(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to list ReferenceGrants: %w", err)
+	}
+
+	return slices.ContainsFunc(grants.Items, func(grant gatewayv1beta1.ReferenceGrant) bool {
+		return slices.ContainsFunc(grant.Spec.From, func(from gatewayv1beta1.ReferenceGrantFrom) bool {
+			return from.Group == gatewayv1.GroupName && from.Kind == "HTTPRoute" && string(from.Namespace) == route.Namespace
+		}) && slices.ContainsFunc(grant.Spec.To, func(to gatewayv1beta1.ReferenceGrantTo) bool {
+			return to.Group == "" && to.Kind == "Service" && (to.Name == nil || *to.Name == backend.Name)
+		})
+	}), nil
+}
+
+func isServiceBackend(backend gatewayv1.BackendObjectReference) bool {
+	return (backend.Group == nil || *backend.Group == "") && (backend.Kind == nil || *backend.Kind == "Service")
+}
+
+// gatewayEndpoint returns the URL of the first parent Gateway listener that the HTTPRoute is attached to.
+func (r *HTTPRouteReconciler) gatewayEndpoint(ctx context.Context, route gatewayv1.HTTPRoute) (string, error) {
+	for _, parentRef := range route.Spec.ParentRefs {
+		if !isGatewayRef(parentRef) {
+			continue
+		}
+
+		namespace := route.Namespace
+		if parentRef.Namespace != nil {
+			namespace = string(*parentRef.Namespace)
+		}
+
+		var gateway gatewayv1.Gateway
+		if err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: string(parentRef.Name)}, &gateway); err != nil {
+			return "", fmt.Errorf("failed to get Gateway %s/%s: %w", namespace, parentRef.Name, err)
+		}
+
+		return gatewayListenerEndpoint(gateway, parentRef)
+	}
+	return "", fmt.Errorf("no parent Gateway found")
+}
+
+func gatewayListenerEndpoint(gateway gatewayv1.Gateway, parentRef gatewayv1.ParentReference) (string, error) {
+	i := slices.IndexFunc(gateway.Status.Addresses, func(a gatewayv1.GatewayStatusAddress) bool {
+		return a.Type == nil || *a.Type == gatewayv1.IPAddressType
+	})
+	if i < 0 {
+		return "", fmt.Errorf("gateway %s/%s has no IP address", gateway.Namespace, gateway.Name)
+	}
+	ip, err := netip.ParseAddr(gateway.Status.Addresses[i].Value)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse IP: %w", err)
+	}
+
+	j := slices.IndexFunc(gateway.Spec.Listeners, func(l gatewayv1.Listener) bool {
+		if l.Protocol != gatewayv1.HTTPProtocolType && l.Protocol != gatewayv1.HTTPSProtocolType {
+			return false
+		}
+		if parentRef.SectionName != nil && *parentRef.SectionName != l.Name {
+			return false
+		}
+		if parentRef.Port != nil && *parentRef.Port != l.Port {
+			return false
+		}
+		return true
+	})
+	if j < 0 {
+		return "", fmt.Errorf("gateway %s/%s has no HTTP listener for the HTTPRoute", gateway.Namespace, gateway.Name)
+	}
+	listener := gateway.Spec.Listeners[j]
+
+	scheme := "http"
+	if listener.Protocol == gatewayv1.HTTPSProtocolType {
+		scheme = "https"
+	}
+	return scheme + "://" + netip.AddrPortFrom(ip, uint16(listener.Port)).String(), nil
+}
+
+func isGatewayRef(parentRef gatewayv1.ParentReference) bool {
+	if parentRef.Group != nil && *parentRef.Group != gatewayv1.GroupName {
+		return false
+	}
+	if parentRef.Kind != nil && *parentRef.Kind != "Gateway" {
+		return false
+	}
+	return true
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *HTTPRouteReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// Gateway APIがインストールされていない環境でも動作するようにする
+	if err := mgr.GetAPIReader().Get(context.Background(), types.NamespacedName{Name: "httproutes.gateway.networking.k8s.io"}, &apiextensions.CustomResourceDefinition{}); err != nil {
+		if apierrors.IsNotFound(err) {
+			slog.Info("HTTPRoute CRD not found. Skipping watching HTTPRoute.")
+			return nil
+		}
+		return fmt.Errorf("failed to check HTTPRoute CRD: %w", err)
+	}
+
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&gatewayv1.HTTPRoute{})
+
+	// HTTPRouteだけがインストールされている環境もあるので、GatewayのCRDも確認する
+	if err := mgr.GetAPIReader().Get(context.Background(), types.NamespacedName{Name: "gateways.gateway.networking.k8s.io"}, &apiextensions.CustomResourceDefinition{}); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to check Gateway CRD: %w", err)
+		}
+		slog.Info("Gateway CRD not found. Skipping watching Gateway.")
+	} else {
+		b = b.Watches(&gatewayv1.Gateway{}, handler.EnqueueRequestsFromMapFunc(r.httpRoutesForGateway))
+	}
+
+	if err := mgr.GetAPIReader().Get(context.Background(), types.NamespacedName{Name: "referencegrants.gateway.networking.k8s.io"}, &apiextensions.CustomResourceDefinition{}); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to check ReferenceGrant CRD: %w", err)
+		}
+		slog.Info("ReferenceGrant CRD not found. Skipping watching ReferenceGrant.")
+	} else {
+		b = b.Watches(&gatewayv1beta1.ReferenceGrant{}, handler.EnqueueRequestsFromMapFunc(r.httpRoutesForReferenceGrant))
+	}
+
+	return b.Named("httproute").
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
+		Complete(requeueOnRateLimit(r))
+}
+
+// httpRoutesForReferenceGrant enqueues the HTTPRoutes in the namespaces that the ReferenceGrant allows references from,
+// so that the backends are published or removed when the grant changes.
+func (r *HTTPRouteReconciler) httpRoutesForReferenceGrant(ctx context.Context, obj client.Object) []reconcile.Request {
+	grant, ok := obj.(*gatewayv1beta1.ReferenceGrant)
+	if !ok {
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, from := range grant.Spec.From {
+		if from.Group != gatewayv1.GroupName || from.Kind != "HTTPRoute" {
+			continue
+		}
+
+		var routes gatewayv1.HTTPRouteList
+		if err := r.List(ctx, &routes, client.InNamespace(string(from.Namespace))); err != nil {
+			log.FromContext(ctx).Error(err, "failed to list HTTPRoutes")
+			return nil
+		}
+		for _, route := range routes.Items {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&route)})
+		}
+	}
+	return requests
+}
+
+// httpRoutesForGateway enqueues the HTTPRoutes attached to the Gateway, so that the address changes are followed.
+func (r *HTTPRouteReconciler) httpRoutesForGateway(ctx context.Context, obj client.Object) []reconcile.Request {
+	var routes gatewayv1.HTTPRouteList
+	if err := r.List(ctx, &routes); err != nil {
+		log.FromContext(ctx).Error(err, "failed to list HTTPRoutes")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, route := range routes.Items {
+		if slices.ContainsFunc(route.Spec.ParentRefs, func(parentRef gatewayv1.ParentReference) bool {
+			namespace := route.Namespace
+			if parentRef.Namespace != nil {
+				namespace = string(*parentRef.Namespace)
+			}
+			return isGatewayRef(parentRef) && namespace == obj.GetNamespace() && string(parentRef.Name) == obj.GetName()
+		}) {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&route)})
+		}
+	}
+	return requests
+}
@@ -0,0 +1,199 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+	gatewayv1beta1 "sigs.k8s.io/gateway-api/apis/v1beta1"
+)
+
+func Test_backendEndpoint(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend gatewayv1.BackendObjectReference
+		port    corev1.ServicePort
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "service",
+			backend: gatewayv1.BackendObjectReference{
+				Name: "web",
+				Port: ptr.To(gatewayv1.PortNumber(8080)),
+			},
+			want: "http://web.default.svc:8080",
+		},
+		{
+			name: "https in another namespace",
+			backend: gatewayv1.BackendObjectReference{
+				Name:      "web",
+				Namespace: ptr.To(gatewayv1.Namespace("web")),
+				Port:      ptr.To(gatewayv1.PortNumber(443)),
+			},
+			want: "https://web.web.svc:443",
+		},
+		{
+			name: "port named https",
+			backend: gatewayv1.BackendObjectReference{
+				Name: "web",
+				Port: ptr.To(gatewayv1.PortNumber(8443)),
+			},
+			port: corev1.ServicePort{Name: "https"},
+			want: "https://web.default.svc:8443",
+		},
+		{
+			name: "port with the https appProtocol",
+			backend: gatewayv1.BackendObjectReference{
+				Name: "web",
+				Port: ptr.To(gatewayv1.PortNumber(8443)),
+			},
+			port: corev1.ServicePort{Name: "web", AppProtocol: ptr.To("https")},
+			want: "https://web.default.svc:8443",
+		},
+		{
+			name: "unsupported kind",
+			backend: gatewayv1.BackendObjectReference{
+				Kind: ptr.To(gatewayv1.Kind("ServiceImport")),
+				Name: "web",
+				Port: ptr.To(gatewayv1.PortNumber(8080)),
+			},
+			wantErr: true,
+		},
+		{
+			name: "no port",
+			backend: gatewayv1.BackendObjectReference{
+				Name: "web",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := backendEndpoint("default", tt.backend, tt.port)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("backendEndpoint() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("backendEndpoint() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPRouteReconciler_backendPermitted(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	if err := gatewayv1beta1.Install(scheme); err != nil {
+		t.Fatal(err)
+	}
+	r := &HTTPRouteReconciler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			&gatewayv1beta1.ReferenceGrant{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "backend", Name: "web"},
+				Spec: gatewayv1beta1.ReferenceGrantSpec{
+					From: []gatewayv1beta1.ReferenceGrantFrom{{Group: gatewayv1.GroupName, Kind: "HTTPRoute", Namespace: "default"}},
+					To:   []gatewayv1beta1.ReferenceGrantTo{{Group: "", Kind: "Service", Name: ptr.To(gatewayv1.ObjectName("web"))}},
+				},
+			},
+		).Build(),
+	}
+	route := gatewayv1.HTTPRoute{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"}}
+
+	tests := []struct {
+		name    string
+		backend gatewayv1.BackendObjectReference
+		want    bool
+	}{
+		{
+			name:    "same namespace",
+			backend: gatewayv1.BackendObjectReference{Name: "web"},
+			want:    true,
+		},
+		{
+			name:    "granted",
+			backend: gatewayv1.BackendObjectReference{Name: "web", Namespace: ptr.To(gatewayv1.Namespace("backend"))},
+			want:    true,
+		},
+		{
+			name:    "another Service",
+			backend: gatewayv1.BackendObjectReference{Name: "admin", Namespace: ptr.To(gatewayv1.Namespace("backend"))},
+			want:    false,
+		},
+		{
+			name:    "namespace without ReferenceGrant",
+			backend: gatewayv1.BackendObjectReference{Name: "web", Namespace: ptr.To(gatewayv1.Namespace("other"))},
+			want:    false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.backendPermitted(ctx, route, tt.backend)
+			if err != nil {
+				t.Fatalf("backendPermitted() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("backendPermitted() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_gatewayListenerEndpoint(t *testing.T) {
+	gateway := gatewayv1.Gateway{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "gateway", Name: "gateway"},
+		Spec: gatewayv1.GatewaySpec{
+			Listeners: []gatewayv1.Listener{
+				{Name: "tcp", Port: 5432, Protocol: gatewayv1.TCPProtocolType},
+				{Name: "http", Port: 80, Protocol: gatewayv1.HTTPProtocolType},
+				{Name: "https", Port: 443, Protocol: gatewayv1.HTTPSProtocolType},
+			},
+		},
+		Status: gatewayv1.GatewayStatus{
+			Addresses: []gatewayv1.GatewayStatusAddress{
+				{Type: ptr.To(gatewayv1.HostnameAddressType), Value: "gateway.example.com"},
+				{Type: ptr.To(gatewayv1.IPAddressType), Value: "192.168.0.1"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		parentRef gatewayv1.ParentReference
+		want      string
+		wantErr   bool
+	}{
+		{
+			name:      "first HTTP listener",
+			parentRef: gatewayv1.ParentReference{Name: "gateway"},
+			want:      "http://192.168.0.1:80",
+		},
+		{
+			name:      "section name",
+			parentRef: gatewayv1.ParentReference{Name: "gateway", SectionName: ptr.To(gatewayv1.SectionName("https"))},
+			want:      "https://192.168.0.1:443",
+		},
+		{
+			name:      "no HTTP listener",
+			parentRef: gatewayv1.ParentReference{Name: "gateway", SectionName: ptr.To(gatewayv1.SectionName("tcp"))},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := gatewayListenerEndpoint(gateway, tt.parentRef)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("gatewayListenerEndpoint() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("gatewayListenerEndpoint() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
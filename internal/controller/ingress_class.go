@@ -0,0 +1,131 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	cftv1beta1 "github.com/walnuts1018/cloudflare-tunnel-operator/api/v1beta1"
+	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/domain"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// IngressControllerName is spec.controller of the IngressClasses that the operator handles as an ingress controller.
+	// Ingresses of such a class are routed to their backend Services, and their status.loadBalancer is set to the tunnel.
+	IngressControllerName = "cf-tunnel-operator.walnuts.dev/ingress-controller"
+
+	// legacyIngressClassAnnotation is the deprecated way to select the IngressClass, which is still used by some charts.
+	legacyIngressClassAnnotation = "kubernetes.io/ingress.class"
+)
+
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=ingressclasses,verbs=get;list;watch
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses/status,verbs=get;update;patch
+
+// isDedicatedIngress reports whether the Ingress belongs to an IngressClass handled by the operator.
+func isDedicatedIngress(ctx context.Context, c client.Reader, ingress networkingv1.Ingress) (bool, error) {
+	className := ingress.Annotations[legacyIngressClassAnnotation]
+	if ingress.Spec.IngressClassName != nil {
+		className = *ingress.Spec.IngressClassName
+	}
+
+	if className != "" {
+		var class networkingv1.IngressClass
+		if err := c.Get(ctx, client.ObjectKey{Name: className}, &class); err != nil {
+			if apierrors.IsNotFound(err) {
+				return false, nil
+			}
+			return false, fmt.Errorf("failed to get IngressClass: %w", err)
+		}
+		return class.Spec.Controller == IngressControllerName, nil
+	}
+
+	// IngressClassが指定されていない場合は、デフォルトのIngressClassが使われる
+	var classes networkingv1.IngressClassList
+	if err := c.List(ctx, &classes); err != nil {
+		return false, fmt.Errorf("failed to list IngressClasses: %w", err)
+	}
+	return slices.ContainsFunc(classes.Items, func(class networkingv1.IngressClass) bool {
+		return class.Annotations[networkingv1.AnnotationIsDefaultIngressClass] == "true" && class.Spec.Controller == IngressControllerName
+	}), nil
+}
+
+// ingressRoutingMode returns the routing mode of the Ingress, and whether it belongs to an IngressClass handled by the operator.
+func ingressRoutingMode(ctx context.Context, c client.Reader, ingress networkingv1.Ingress, settings cftv1beta1.CloudflareTunnelSettings) (cftv1beta1.RoutingMode, bool, error) {
+	dedicated, err := isDedicatedIngress(ctx, c, ingress)
+	if err != nil {
+		return "", false, err
+	}
+	// 他のIngress Controllerがいないので、Serviceに直接送る
+	if dedicated {
+		return cftv1beta1.RoutingModeService, true, nil
+	}
+
+	mode, err := detectRoutingMode(ingress.Annotations, settings)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to detect routing mode: %w", err)
+	}
+	return mode, false, nil
+}
+
+// updateLoadBalancerStatus sets status.loadBalancer of the Ingress to the hostname of the tunnel, or clears it if tunnelID is empty.
+func (r *IngressReconciler) updateLoadBalancerStatus(ctx context.Context, ingress *networkingv1.Ingress, tunnelID string) error {
+	var want []networkingv1.IngressLoadBalancerIngress
+	if tunnelID != "" {
+		want = []networkingv1.IngressLoadBalancerIngress{{Hostname: domain.TunnelCNAME(tunnelID)}}
+	}
+	if slices.EqualFunc(ingress.Status.LoadBalancer.Ingress, want, func(a, b networkingv1.IngressLoadBalancerIngress) bool {
+		return a.IP == b.IP && a.Hostname == b.Hostname && len(a.Ports) == 0 && len(b.Ports) == 0
+	}) {
+		return nil
+	}
+
+	patch := client.MergeFrom(ingress.DeepCopy())
+	ingress.Status.LoadBalancer.Ingress = want
+	if err := r.Status().Patch(ctx, ingress, patch); err != nil {
+		return fmt.Errorf("failed to update Ingress status: %w", err)
+	}
+	return nil
+}
+
+// ingressesForTunnel returns the Ingresses whose rules were added to the CloudflareTunnel,
+// so that their publication state and load balancer status follow a recreated tunnel.
+func (r *IngressReconciler) ingressesForTunnel(ctx context.Context, obj client.Object) []reconcile.Request {
+	var ingresses networkingv1.IngressList
+	if err := r.List(ctx, &ingresses); err != nil {
+		log.FromContext(ctx).Error(err, "failed to list Ingresses")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, ingress := range ingresses.Items {
+		if ingress.Annotations[appliedTunnelAnnotation] == obj.GetNamespace()+"/"+obj.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&ingress)})
+		}
+	}
+	return requests
+}
+
+// tunnelIDChanged passes the updates of CloudflareTunnels whose tunnel was created or replaced.
+var tunnelIDChanged = predicate.Funcs{
+	CreateFunc:  func(event.CreateEvent) bool { return false },
+	DeleteFunc:  func(event.DeleteEvent) bool { return false },
+	GenericFunc: func(event.GenericEvent) bool { return false },
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldTunnel, ok := e.ObjectOld.(*cftv1beta1.CloudflareTunnel)
+		if !ok {
+			return false
+		}
+		newTunnel, ok := e.ObjectNew.(*cftv1beta1.CloudflareTunnel)
+		if !ok {
+			return false
+		}
+		return oldTunnel.Status.TunnelID != newTunnel.Status.TunnelID
+	},
+}
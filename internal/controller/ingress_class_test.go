@@ -0,0 +1,113 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_isDedicatedIngress(t *testing.T) {
+	ctx := context.Background()
+
+	ingressClass := func(name string, controller string, isDefault bool) *networkingv1.IngressClass {
+		class := &networkingv1.IngressClass{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       networkingv1.IngressClassSpec{Controller: controller},
+		}
+		if isDefault {
+			class.Annotations = map[string]string{networkingv1.AnnotationIsDefaultIngressClass: "true"}
+		}
+		return class
+	}
+
+	tests := []struct {
+		name    string
+		classes []client.Object
+		ingress networkingv1.Ingress
+		want    bool
+	}{
+		{
+			name:    "class handled by the operator",
+			classes: []client.Object{ingressClass("cloudflare-tunnel", IngressControllerName, false)},
+			ingress: networkingv1.Ingress{Spec: networkingv1.IngressSpec{IngressClassName: ptr.To("cloudflare-tunnel")}},
+			want:    true,
+		},
+		{
+			name:    "class of another controller",
+			classes: []client.Object{ingressClass("nginx", "k8s.io/ingress-nginx", true)},
+			ingress: networkingv1.Ingress{Spec: networkingv1.IngressSpec{IngressClassName: ptr.To("nginx")}},
+			want:    false,
+		},
+		{
+			name:    "class not found",
+			ingress: networkingv1.Ingress{Spec: networkingv1.IngressSpec{IngressClassName: ptr.To("cloudflare-tunnel")}},
+			want:    false,
+		},
+		{
+			name:    "legacy annotation",
+			classes: []client.Object{ingressClass("cloudflare-tunnel", IngressControllerName, false)},
+			ingress: networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{legacyIngressClassAnnotation: "cloudflare-tunnel"}}},
+			want:    true,
+		},
+		{
+			name: "default class handled by the operator",
+			classes: []client.Object{
+				ingressClass("nginx", "k8s.io/ingress-nginx", false),
+				ingressClass("cloudflare-tunnel", IngressControllerName, true),
+			},
+			want: true,
+		},
+		{
+			name:    "no default class",
+			classes: []client.Object{ingressClass("cloudflare-tunnel", IngressControllerName, false)},
+			want:    false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(tt.classes...).Build()
+			got, err := isDedicatedIngress(ctx, c, tt.ingress)
+			if err != nil {
+				t.Fatalf("isDedicatedIngress() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("isDedicatedIngress() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIngressReconciler_updateLoadBalancerStatus(t *testing.T) {
+	ctx := context.Background()
+
+	ingress := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "ingress", Namespace: "default"}}
+	c := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(ingress).WithStatusSubresource(ingress).Build()
+	r := &IngressReconciler{Client: c}
+
+	if err := r.updateLoadBalancerStatus(ctx, ingress, "test-id"); err != nil {
+		t.Fatalf("updateLoadBalancerStatus() error = %v", err)
+	}
+	var got networkingv1.Ingress
+	if err := c.Get(ctx, client.ObjectKeyFromObject(ingress), &got); err != nil {
+		t.Fatal(err)
+	}
+	if lb := got.Status.LoadBalancer.Ingress; len(lb) != 1 || lb[0].Hostname != "test-id.cfargotunnel.com" {
+		t.Errorf("status.loadBalancer.ingress = %v, want test-id.cfargotunnel.com", lb)
+	}
+
+	if err := r.updateLoadBalancerStatus(ctx, ingress, ""); err != nil {
+		t.Fatalf("updateLoadBalancerStatus() error = %v", err)
+	}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(ingress), &got); err != nil {
+		t.Fatal(err)
+	}
+	if lb := got.Status.LoadBalancer.Ingress; len(lb) != 0 {
+		t.Errorf("status.loadBalancer.ingress = %v, want empty", lb)
+	}
+}
@@ -0,0 +1,267 @@
+package controller
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go"
+	cftv1beta1 "github.com/walnuts1018/cloudflare-tunnel-operator/api/v1beta1"
+	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/domain"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ingressRule is a tunnel ingress rule generated from an Ingress.
+type ingressRule struct {
+	Hostname string
+	Path     string
+	Service  string
+	// OriginRequest overrides the origin settings of the tunnel for the rule.
+	OriginRequest *cftv1beta1.OriginRequest
+}
+
+type ruleKey struct {
+	Hostname string
+	Path     string
+}
+
+func (r ingressRule) key() ruleKey {
+	return ruleKey{Hostname: r.Hostname, Path: r.Path}
+}
+
+func detectRoutingMode(annotations map[string]string, tunnelSettings cftv1beta1.CloudflareTunnelSettings) (cftv1beta1.RoutingMode, error) {
+	v, ok := annotations[routingModeAnnotation]
+	if !ok {
+		if tunnelSettings.RoutingMode == "" {
+			return cftv1beta1.RoutingModeLoadBalancer, nil
+		}
+		return tunnelSettings.RoutingMode, nil
+	}
+
+	switch {
+	case strings.EqualFold(v, string(cftv1beta1.RoutingModeLoadBalancer)):
+		return cftv1beta1.RoutingModeLoadBalancer, nil
+	case strings.EqualFold(v, string(cftv1beta1.RoutingModeService)):
+		return cftv1beta1.RoutingModeService, nil
+	default:
+		return "", fmt.Errorf("invalid annotation value: %s, expected one of %s, %s", v, cftv1beta1.RoutingModeLoadBalancer, cftv1beta1.RoutingModeService)
+	}
+}
+
+// desiredRules returns the tunnel ingress rules for the Ingress.
+func (r *IngressReconciler) desiredRules(ctx context.Context, ingress networkingv1.Ingress, mode cftv1beta1.RoutingMode) ([]ingressRule, error) {
+	if mode == cftv1beta1.RoutingModeService {
+		return r.serviceRules(ctx, ingress)
+	}
+
+	// Ingress Controllerに送る場合はHTTP以外のプロトコルは使えない
+	protocol, err := detectOriginProtocol(ingress.Annotations)
+	if err != nil {
+		return nil, err
+	}
+	if protocol != "" && protocol != domain.OriginProtocolHTTP && protocol != domain.OriginProtocolHTTPS {
+		return nil, fmt.Errorf("%w: %s requires the %s routing mode", domain.ErrUnsupportedOriginProtocol, protocol, cftv1beta1.RoutingModeService)
+	}
+
+	if len(ingress.Status.LoadBalancer.Ingress) == 0 {
+		return nil, fmt.Errorf("ingress status load balancer ingress is empty")
+	}
+
+	ip, err := netip.ParseAddr(ingress.Status.LoadBalancer.Ingress[0].IP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse IP: %w", err)
+	}
+
+	return loadBalancerRules(ingress, ip), nil
+}
+
+// loadBalancerRules returns a rule per Ingress path, which sends the traffic to the ingress controller.
+func loadBalancerRules(ingress networkingv1.Ingress, ip netip.Addr) []ingressRule {
+	var TLSHosts []string
+	for _, tls := range ingress.Spec.TLS {
+		TLSHosts = append(TLSHosts, tls.Hosts...)
+	}
+
+	paths := ingressPaths(ingress)
+	rules := make([]ingressRule, 0, len(paths))
+	for _, p := range paths {
+		rules = append(rules, ingressRule{
+			Hostname: p.Host,
+			Path:     pathRegex(p.Path.Path, p.Path.PathType),
+			Service:  createEndpoint(ip, checkTLS(p.Host, TLSHosts)),
+		})
+	}
+	return rules
+}
+
+func (r *IngressReconciler) serviceRules(ctx context.Context, ingress networkingv1.Ingress) ([]ingressRule, error) {
+	protocol, err := detectOriginProtocol(ingress.Annotations)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []ingressRule
+	for _, p := range ingressPaths(ingress) {
+		if p.Path.Backend.Service == nil {
+			continue
+		}
+
+		service, err := r.serviceEndpoint(ctx, ingress.Namespace, *p.Path.Backend.Service, protocol)
+		if err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, ingressRule{
+			Hostname: p.Host,
+			Path:     pathRegex(p.Path.Path, p.Path.PathType),
+			Service:  service,
+		})
+	}
+	return rules, nil
+}
+
+// serviceEndpoint returns the in-cluster URL of the backend Service.
+// If protocol is empty, it is detected from the Service port that the backend refers to by name or number.
+func (r *IngressReconciler) serviceEndpoint(ctx context.Context, namespace string, backend networkingv1.IngressServiceBackend, protocol domain.OriginProtocol) (string, error) {
+	port := corev1.ServicePort{Name: backend.Port.Name, Port: backend.Port.Number}
+	if port.Port == 0 || protocol == "" {
+		var svc corev1.Service
+		if err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: backend.Name}, &svc); err != nil {
+			// ポート番号が指定されていれば、Serviceが後から作られても番号だけで判断できる
+			if port.Port == 0 || !apierrors.IsNotFound(err) {
+				return "", fmt.Errorf("failed to get Service %s/%s: %w", namespace, backend.Name, err)
+			}
+		}
+
+		i := slices.IndexFunc(svc.Spec.Ports, func(p corev1.ServicePort) bool {
+			if port.Port == 0 {
+				return p.Name == backend.Port.Name
+			}
+			return p.Port == port.Port
+		})
+		switch {
+		case i >= 0:
+			port = svc.Spec.Ports[i]
+		case port.Port == 0:
+			return "", fmt.Errorf("port %s not found in Service %s/%s", backend.Port.Name, namespace, backend.Name)
+		}
+	}
+
+	if protocol == "" {
+		protocol = defaultOriginProtocol(port)
+	}
+
+	return domain.OriginURL(protocol, fmt.Sprintf("%s.%s.svc", backend.Name, namespace), port.Port), nil
+}
+
+type ingressPath struct {
+	Host string
+	Path networkingv1.HTTPIngressPath
+}
+
+// ingressPaths returns the paths of the Ingress rules that have a host.
+// A rule without paths is treated as a single path that matches everything and is served by the default backend.
+func ingressPaths(ingress networkingv1.Ingress) []ingressPath {
+	var paths []ingressPath
+	for _, rule := range ingress.Spec.Rules {
+		if rule.Host == "" {
+			continue
+		}
+		if rule.HTTP == nil || len(rule.HTTP.Paths) == 0 {
+			path := networkingv1.HTTPIngressPath{}
+			if ingress.Spec.DefaultBackend != nil {
+				path.Backend = *ingress.Spec.DefaultBackend
+			}
+			paths = append(paths, ingressPath{
+				Host: rule.Host,
+				Path: path,
+			})
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			paths = append(paths, ingressPath{
+				Host: rule.Host,
+				Path: path,
+			})
+		}
+	}
+	return paths
+}
+
+const prefixPathSuffix = "(/.*)?$"
+
+// pathRegex translates an Ingress path into a cloudflared path regex.
+// ImplementationSpecific is treated as Prefix.
+func pathRegex(path string, pathType *networkingv1.PathType) string {
+	if pathType != nil && *pathType == networkingv1.PathTypeExact {
+		return "^" + regexp.QuoteMeta(path) + "$"
+	}
+
+	path = strings.TrimSuffix(path, "/")
+	if path == "" {
+		return ""
+	}
+	return "^" + regexp.QuoteMeta(path) + prefixPathSuffix
+}
+
+// compareRules orders the tunnel ingress rules so that cloudflared, which uses the first matching rule,
+// matches the most specific one: exact hostnames before wildcards, longer paths before shorter ones,
+// and rules without a path after every path of the same hostname. The catch-all rule is always last.
+func compareRules(a, b cloudflare.UnvalidatedIngressRule) int {
+	if c := cmp.Compare(catchAllOrder(a), catchAllOrder(b)); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(wildcardOrder(a.Hostname), wildcardOrder(b.Hostname)); c != 0 {
+		return c
+	}
+	if c := strings.Compare(a.Hostname, b.Hostname); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(pathSpecificity(b.Path), pathSpecificity(a.Path)); c != 0 {
+		return c
+	}
+	return strings.Compare(a.Path, b.Path)
+}
+
+func catchAllOrder(rule cloudflare.UnvalidatedIngressRule) int {
+	if rule.Hostname == "" && rule.Path == "" {
+		return 1
+	}
+	return 0
+}
+
+func wildcardOrder(hostname string) int {
+	if strings.HasPrefix(hostname, "*") {
+		return 1
+	}
+	return 0
+}
+
+// pathSpecificity returns a larger value for a more specific path regex.
+// An exact path is more specific than a prefix of the same path.
+func pathSpecificity(path string) int {
+	if path == "" {
+		return 0
+	}
+	if prefix, ok := strings.CutSuffix(path, prefixPathSuffix); ok {
+		return 2 * len(prefix)
+	}
+	return 2*len(strings.TrimSuffix(path, "$")) + 1
+}
+
+func hostnames(rules []ingressRule) []string {
+	hostnames := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		if !slices.Contains(hostnames, rule.Hostname) {
+			hostnames = append(hostnames, rule.Hostname)
+		}
+	}
+	return hostnames
+}
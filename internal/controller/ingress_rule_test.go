@@ -0,0 +1,191 @@
+package controller
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	cftv1beta1 "github.com/walnuts1018/cloudflare-tunnel-operator/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_detectRoutingMode(t *testing.T) {
+	tests := []struct {
+		name           string
+		annotations    map[string]string
+		tunnelSettings cftv1beta1.CloudflareTunnelSettings
+		want           cftv1beta1.RoutingMode
+		wantErr        bool
+	}{
+		{
+			name: "default",
+			want: cftv1beta1.RoutingModeLoadBalancer,
+		},
+		{
+			name:           "tunnel settings",
+			tunnelSettings: cftv1beta1.CloudflareTunnelSettings{RoutingMode: cftv1beta1.RoutingModeService},
+			want:           cftv1beta1.RoutingModeService,
+		},
+		{
+			name:           "annotation overrides tunnel settings",
+			annotations:    map[string]string{routingModeAnnotation: "loadbalancer"},
+			tunnelSettings: cftv1beta1.CloudflareTunnelSettings{RoutingMode: cftv1beta1.RoutingModeService},
+			want:           cftv1beta1.RoutingModeLoadBalancer,
+		},
+		{
+			name:        "invalid annotation",
+			annotations: map[string]string{routingModeAnnotation: "direct"},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := detectRoutingMode(tt.annotations, tt.tunnelSettings)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("detectRoutingMode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("detectRoutingMode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_pathRegex(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		pathType *networkingv1.PathType
+		want     string
+	}{
+		{
+			name:     "root prefix",
+			path:     "/",
+			pathType: ptr.To(networkingv1.PathTypePrefix),
+			want:     "",
+		},
+		{
+			name:     "prefix",
+			path:     "/api/",
+			pathType: ptr.To(networkingv1.PathTypePrefix),
+			want:     `^/api(/.*)?$`,
+		},
+		{
+			name:     "implementation specific",
+			path:     "/api",
+			pathType: ptr.To(networkingv1.PathTypeImplementationSpecific),
+			want:     `^/api(/.*)?$`,
+		},
+		{
+			name:     "exact",
+			path:     "/v1.0/healthz",
+			pathType: ptr.To(networkingv1.PathTypeExact),
+			want:     `^/v1\.0/healthz$`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pathRegex(tt.path, tt.pathType); got != tt.want {
+				t.Errorf("pathRegex() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIngressReconciler_serviceRules(t *testing.T) {
+	ctx := context.Background()
+
+	r := &IngressReconciler{
+		Client: fake.NewClientBuilder().WithObjects(
+			&corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+				Spec: corev1.ServiceSpec{
+					Ports: []corev1.ServicePort{
+						{Name: "http", Port: 8080},
+						{Name: "https", Port: 8443},
+						{Name: "admin", Port: 9443, AppProtocol: ptr.To("https")},
+					},
+				},
+			},
+		).Build(),
+	}
+
+	ingress := networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{
+				{
+					Host: "web.walnuts.dev",
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{
+								{
+									Path:     "/api",
+									PathType: ptr.To(networkingv1.PathTypePrefix),
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{
+											Name: "web",
+											Port: networkingv1.ServiceBackendPort{Name: "https"},
+										},
+									},
+								},
+								{
+									Path:     "/admin",
+									PathType: ptr.To(networkingv1.PathTypePrefix),
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{
+											Name: "web",
+											Port: networkingv1.ServiceBackendPort{Number: 9443},
+										},
+									},
+								},
+								{
+									Path:     "/",
+									PathType: ptr.To(networkingv1.PathTypePrefix),
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{
+											Name: "web",
+											Port: networkingv1.ServiceBackendPort{Number: 8080},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	want := []ingressRule{
+		{Hostname: "web.walnuts.dev", Path: `^/api(/.*)?$`, Service: "https://web.default.svc:8443"},
+		{Hostname: "web.walnuts.dev", Path: `^/admin(/.*)?$`, Service: "https://web.default.svc:9443"},
+		{Hostname: "web.walnuts.dev", Path: "", Service: "http://web.default.svc:8080"},
+	}
+
+	got, err := r.serviceRules(ctx, ingress)
+	if err != nil {
+		t.Fatalf("serviceRules() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("serviceRules() = %v, want %v", got, want)
+	}
+
+	ingress.Annotations = map[string]string{protocolAnnotation: "tcp"}
+	got, err = r.serviceRules(ctx, ingress)
+	if err != nil {
+		t.Fatalf("serviceRules() error = %v", err)
+	}
+	if want := "tcp://web.default.svc:8443"; got[0].Service != want {
+		t.Errorf("serviceRules() service = %v, want %v", got[0].Service, want)
+	}
+
+	ingress.Spec.Rules[0].HTTP.Paths[0].Backend.Service.Port.Name = "grpc"
+	if _, err := r.serviceRules(ctx, ingress); err == nil {
+		t.Errorf("serviceRules() error = nil, want error for unknown port name")
+	}
+}
@@ -4,24 +4,24 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"maps"
 	"net/netip"
 	"slices"
 	"strings"
-	"sync"
+	"time"
 
-	"github.com/cloudflare/cloudflare-go"
 	cftv1beta1 "github.com/walnuts1018/cloudflare-tunnel-operator/api/v1beta1"
-	"github.com/walnuts1018/cloudflare-tunnel-operator/internal/consts"
-	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/domain"
+	corev1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
@@ -29,6 +29,13 @@ const (
 	annotationPrefix   = "cf-tunnel-operator.walnuts.dev/"
 	cfTunnelAnnotation = annotationPrefix + "cloudflare-tunnel"
 	ignoreAnnotation   = annotationPrefix + "ignore"
+	// routingModeAnnotation overrides CloudflareTunnelSettings.RoutingMode for the Ingress.
+	routingModeAnnotation = annotationPrefix + "routing-mode"
+
+	// publicationRecheckInterval is the interval at which the hosts of an Ingress that are not published yet are checked again.
+	publicationRecheckInterval = time.Minute
+	// publishedRecheckInterval is the interval at which the DNS records of the published hosts of an Ingress are checked again.
+	publishedRecheckInterval = 10 * time.Minute
 )
 
 var (
@@ -41,10 +48,13 @@ type IngressReconciler struct {
 	client.Client
 	Scheme                  *runtime.Scheme
 	CloudflareTunnelManager CloudflareTunnelManager
-	mu                      sync.Mutex
+	Credentials             *CloudflareCredentialsCache
+	Recorder                record.EventRecorder
+	// MaxConcurrentReconciles is the number of resources reconciled in parallel. The default is 1.
+	MaxConcurrentReconciles int
 }
 
-// +kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;update
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;update;patch
 
 func (r *IngressReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	logger := log.FromContext(ctx)
@@ -84,7 +94,7 @@ func (r *IngressReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		return ctrl.Result{}, fmt.Errorf("failed to detect Cloudflare Tunnel name: %w", err)
 	}
 
-	cfTunnel, err := r.getCloudflareTunnel(ctx, cfTunnelName)
+	cfTunnel, err := getCloudflareTunnel(ctx, r.Client, cfTunnelName)
 	if err != nil {
 		if errors.Is(err, ErrDefaultCloudflareTunnelNotExists) {
 			logger.Info("default Cloudflare Tunnel not exists")
@@ -102,226 +112,88 @@ func (r *IngressReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		return ctrl.Result{}, fmt.Errorf("tunnel ID is empty")
 	}
 
-	if len(ingress.Status.LoadBalancer.Ingress) == 0 {
-		return ctrl.Result{}, fmt.Errorf("ingress status load balancer ingress is empty")
+	manager, zones, err := cloudflareTunnelManagerFor(ctx, r.Client, r.CloudflareTunnelManager, r.Credentials, cfTunnel)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to get Cloudflare Tunnel client: %w", err)
 	}
 
-	ip, err := netip.ParseAddr(ingress.Status.LoadBalancer.Ingress[0].IP)
+	mode, dedicated, err := ingressRoutingMode(ctx, r.Client, *ingress, cfTunnel.Spec.Settings)
 	if err != nil {
-		return ctrl.Result{}, fmt.Errorf("failed to parse IP: %w", err)
+		return ctrl.Result{}, err
 	}
 
-	{
-		hosts := getHosts(*ingress)
-
-		// Ignore Annotationがついていたらエントリを追加しない
-		// 既に追加されていたら削除する
-		if checkToBeIgnored(ingress.Annotations) {
-			if err := r.removeCloudflareTunnelConfig(ctx, tunnelID, hosts, cfTunnel.Spec.Settings); err != nil {
-				return ctrl.Result{}, fmt.Errorf("failed to update Cloudflare Tunnel config: %w", err)
-			}
-
-			for _, host := range hosts {
-				if err := r.removeDNSRecord(ctx, tunnelID, host); err != nil {
-					return ctrl.Result{}, fmt.Errorf("failed to update Cloudflare DNS Record: %w", err)
-				}
-			}
-		} else {
-			if err := r.appendCloudflareTunnelConfig(ctx, tunnelID, hosts, ip, cfTunnel.Spec.Settings); err != nil {
-				return ctrl.Result{}, fmt.Errorf("failed to update Cloudflare Tunnel config: %w", err)
-			}
-
-			for _, host := range hosts {
-				if err := r.appendDNSRecord(ctx, tunnelID, host); err != nil {
-					return ctrl.Result{}, fmt.Errorf("failed to update Cloudflare DNS Record: %w", err)
-				}
-			}
-		}
+	// 別のトンネルに移された場合は、前のトンネルからルールを消す
+	owner := ruleOwner("Ingress", ingress)
+	if err := releasePreviousTunnel(ctx, r.Client, r.CloudflareTunnelManager, r.Credentials, ingress, owner, &cfTunnel); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to remove rules from the previous Cloudflare Tunnel: %w", err)
 	}
 
-	return ctrl.Result{}, nil
-}
-
-func (r *IngressReconciler) getCloudflareTunnel(ctx context.Context, cfTunnelName types.NamespacedName) (cftv1beta1.CloudflareTunnel, error) {
-	var cfTunnel cftv1beta1.CloudflareTunnel
-
-	// use default Cloudflare Tunnel
-	if cfTunnelName.Name == "" || cfTunnelName.Namespace == "" {
-		var defaultCFTunnels cftv1beta1.CloudflareTunnelList
-		if err := r.List(ctx, &defaultCFTunnels, &client.ListOptions{
-			Limit:         1,
-			LabelSelector: labels.SelectorFromSet(map[string]string{consts.DefaultLabelKey: "true"}),
-		}); err != nil {
-			return cftv1beta1.CloudflareTunnel{}, fmt.Errorf("failed to list default Cloudflare Tunnel: %w", err)
+	// Ignore Annotationがついていたらエントリを追加しない
+	// 既に追加されていたら削除する
+	if checkToBeIgnored(ingress.Annotations) {
+		if err := releaseTunnelRules(ctx, r.Client, manager, cfTunnel, zones, owner); err != nil {
+			return ctrl.Result{}, err
 		}
-
-		if len(defaultCFTunnels.Items) == 0 {
-			return cftv1beta1.CloudflareTunnel{}, ErrDefaultCloudflareTunnelNotExists
-		}
-
-		cfTunnel = defaultCFTunnels.Items[0]
-	} else {
-		if err := r.Get(ctx, cfTunnelName, &cfTunnel); err != nil {
-			if apierrors.IsNotFound(err) {
-				return cftv1beta1.CloudflareTunnel{}, ErrCloudflareTunnelNotFound
-			}
-
-			return cftv1beta1.CloudflareTunnel{}, fmt.Errorf("failed to get Cloudflare Tunnel: %w", err)
+		if err := recordAppliedTunnel(ctx, r.Client, ingress, nil); err != nil {
+			return ctrl.Result{}, err
 		}
-	}
-
-	return cfTunnel, nil
-}
-
-func (r *IngressReconciler) appendCloudflareTunnelConfig(
-	ctx context.Context,
-	tunnelID string,
-	hosts []host,
-	ip netip.Addr,
-	tunnelSettings cftv1beta1.CloudflareTunnelSettings,
-) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	config, err := r.CloudflareTunnelManager.GetTunnelConfiguration(ctx, tunnelID)
-	if err != nil {
-		return fmt.Errorf("failed to get tunnel configs: %v", err)
-	}
-	rules := make(map[string]cloudflare.UnvalidatedIngressRule, len(config.Ingress))
-	for _, rule := range config.Ingress {
-		rules[rule.Hostname] = cloudflare.UnvalidatedIngressRule{
-			Hostname:      rule.Hostname,
-			Path:          rule.Path,
-			Service:       rule.Service,
-			OriginRequest: domain.ToOriginRequestConfig(tunnelSettings, rule.Hostname),
+		if err := recordPublication(ctx, r.Client, r.Recorder, ingress, nil); err != nil {
+			return ctrl.Result{}, err
 		}
-	}
-
-	for _, host := range hosts {
-		rules[host.Host] = cloudflare.UnvalidatedIngressRule{
-			Hostname:      host.Host,
-			Path:          "",
-			Service:       createEndpoint(ip, host.TLS),
-			OriginRequest: domain.ToOriginRequestConfig(tunnelSettings, host.Host),
+		if dedicated {
+			return ctrl.Result{}, r.updateLoadBalancerStatus(ctx, ingress, "")
 		}
+		return ctrl.Result{}, nil
 	}
 
-	rules[""] = cloudflare.UnvalidatedIngressRule{
-		Hostname:      "",
-		Path:          "",
-		Service:       tunnelSettings.CatchAllRule,
-		OriginRequest: nil,
-	}
-
-	config.Ingress = slices.SortedFunc(maps.Values(rules), func(a, b cloudflare.UnvalidatedIngressRule) int {
-		switch {
-		case a.Hostname == "":
-			return 1
-		case b.Hostname == "":
-			return -1
-		default:
-			return strings.Compare(a.Hostname, b.Hostname)
-		}
-	})
-
-	if err := r.CloudflareTunnelManager.UpdateTunnelConfiguration(ctx, tunnelID, config); err != nil {
-		return fmt.Errorf("failed to update tunnel configs: %v", err)
+	rules, err := r.desiredRules(ctx, *ingress, mode)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to build tunnel ingress rules: %w", err)
 	}
 
-	return nil
-}
-
-func (r *IngressReconciler) removeCloudflareTunnelConfig(
-	ctx context.Context,
-	tunnelID string,
-	hosts []host,
-	tunnelSettings cftv1beta1.CloudflareTunnelSettings,
-) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	config, err := r.CloudflareTunnelManager.GetTunnelConfiguration(ctx, tunnelID)
+	// 不正なAnnotationは無視してトンネルの設定を使い、Eventで知らせる
+	overrides, err := parseOriginRequestAnnotations(ingress.Annotations)
 	if err != nil {
-		return fmt.Errorf("failed to get tunnel configs: %v", err)
+		r.Recorder.Event(ingress, corev1.EventTypeWarning, "InvalidAnnotation", err.Error())
 	}
-	rules := make(map[string]cloudflare.UnvalidatedIngressRule, len(config.Ingress))
-	for _, rule := range config.Ingress {
-		rules[rule.Hostname] = cloudflare.UnvalidatedIngressRule{
-			Hostname:      rule.Hostname,
-			Path:          rule.Path,
-			Service:       rule.Service,
-			OriginRequest: domain.ToOriginRequestConfig(tunnelSettings, rule.Hostname),
-		}
+	for i := range rules {
+		rules[i].OriginRequest = overrides
 	}
 
-	for _, host := range hosts {
-		delete(rules, host.Host)
+	if err := applyTunnelRules(ctx, r.Client, manager, cfTunnel, zones, owner, rules); err != nil {
+		r.Recorder.Eventf(ingress, corev1.EventTypeWarning, "PublishFailed", "Failed to publish %s to Cloudflare Tunnel %s/%s: %v",
+			strings.Join(hostnames(rules), ", "), cfTunnel.Namespace, cfTunnel.Name, err)
+		return ctrl.Result{}, err
 	}
 
-	rules[""] = cloudflare.UnvalidatedIngressRule{
-		Hostname:      "",
-		Path:          "",
-		Service:       tunnelSettings.CatchAllRule,
-		OriginRequest: nil,
+	if err := recordAppliedTunnel(ctx, r.Client, ingress, &cfTunnel); err != nil {
+		return ctrl.Result{}, err
 	}
 
-	config.Ingress = slices.SortedFunc(maps.Values(rules), func(a, b cloudflare.UnvalidatedIngressRule) int {
-		switch {
-		case a.Hostname == "":
-			return 1
-		case b.Hostname == "":
-			return -1
-		default:
-			return strings.Compare(a.Hostname, b.Hostname)
-		}
-	})
-
-	if err := r.CloudflareTunnelManager.UpdateTunnelConfiguration(ctx, tunnelID, config); err != nil {
-		return fmt.Errorf("failed to update tunnel configs: %v", err)
-	}
-
-	return nil
-}
-
-func (r *IngressReconciler) appendDNSRecord(ctx context.Context, tunnelID string, host host) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	record, err := r.CloudflareTunnelManager.GetDNS(ctx, tunnelID, host.Host)
+	publications, err := observePublication(ctx, manager, cfTunnel, zones, rules, recordedPublication(ingress), time.Now())
 	if err != nil {
-		return fmt.Errorf("failed to get DNS record: %v", err)
+		return ctrl.Result{}, fmt.Errorf("failed to observe publication state: %w", err)
+	}
+	if err := recordPublication(ctx, r.Client, r.Recorder, ingress, publications); err != nil {
+		return ctrl.Result{}, err
 	}
 
-	if record.ID == "" {
-		if err := r.CloudflareTunnelManager.AddDNS(ctx, tunnelID, host.Host); err != nil {
-			return fmt.Errorf("failed to add DNS record: %v", err)
-		}
-		return nil
-	} else if !record.Healthy(tunnelID) {
-		if err := r.CloudflareTunnelManager.UpdateDNS(ctx, tunnelID, host.Host, record); err != nil {
-			return fmt.Errorf("failed to update DNS record: %v", err)
+	// 専用のIngressClassでは、このOperatorがIngress Controllerとしてstatusを書く
+	if dedicated {
+		if err := r.updateLoadBalancerStatus(ctx, ingress, tunnelID); err != nil {
+			return ctrl.Result{}, err
 		}
 	}
-	return nil
-}
-
-func (r *IngressReconciler) removeDNSRecord(ctx context.Context, tunnelID string, host host) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
 
-	record, err := r.CloudflareTunnelManager.GetDNS(ctx, tunnelID, host.Host)
-	if err != nil {
-		return fmt.Errorf("failed to get DNS record: %v", err)
+	// DNSレコードの反映などは後から直ることがあるので、公開できていないホストがあれば確認し直す
+	if slices.ContainsFunc(publications, func(p hostPublication) bool { return !p.published() }) {
+		return ctrl.Result{RequeueAfter: publicationRecheckInterval}, nil
 	}
-
-	if record.ID == "" {
-		return nil
-	} else {
-		if err := r.CloudflareTunnelManager.DeleteDNS(ctx, tunnelID, record.ID); err != nil {
-			return fmt.Errorf("failed to delete DNS record: %v", err)
-		}
+	// 公開済みのDNSレコードも外から変更されることがあるので、間隔を空けて確認し直す
+	if len(publications) > 0 {
+		return ctrl.Result{RequeueAfter: publishedRecheckInterval}, nil
 	}
-	return nil
+	return ctrl.Result{}, nil
 }
 
 func createEndpoint(IP netip.Addr, TLS bool) string {
@@ -332,28 +204,6 @@ func createEndpoint(IP netip.Addr, TLS bool) string {
 	}
 }
 
-type host struct {
-	Host string
-	TLS  bool
-}
-
-func getHosts(ingress networkingv1.Ingress) []host {
-	var TLSHosts []string
-	for _, tls := range ingress.Spec.TLS {
-		TLSHosts = append(TLSHosts, tls.Hosts...)
-	}
-
-	hosts := make([]host, 0, len(ingress.Spec.Rules))
-	for _, rule := range ingress.Spec.Rules {
-		hosts = append(hosts, host{
-			Host: rule.Host,
-			TLS:  checkTLS(rule.Host, TLSHosts),
-		})
-	}
-
-	return hosts
-}
-
 func checkTLS(host string, TLSHosts []string) bool {
 	return slices.Contains(TLSHosts, host)
 }
@@ -388,7 +238,7 @@ func (r *IngressReconciler) finalizeIngress(ctx context.Context, ingress *networ
 		return fmt.Errorf("failed to detect Cloudflare Tunnel name: %w", err)
 	}
 
-	cfTunnel, err := r.getCloudflareTunnel(ctx, cfTunnelName)
+	cfTunnel, err := getCloudflareTunnel(ctx, r.Client, cfTunnelName)
 	if err != nil {
 		if errors.Is(err, ErrDefaultCloudflareTunnelNotExists) {
 			logger.Info("default Cloudflare Tunnel not exists")
@@ -408,21 +258,25 @@ func (r *IngressReconciler) finalizeIngress(ctx context.Context, ingress *networ
 		return fmt.Errorf("tunnel ID is empty")
 	}
 
-	hosts := getHosts(*ingress)
-	if err := r.removeCloudflareTunnelConfig(ctx, tunnelID, hosts, cfTunnel.Spec.Settings); err != nil {
-		return fmt.Errorf("failed to remove Cloudflare Tunnel config: %w", err)
+	manager, zones, err := cloudflareTunnelManagerFor(ctx, r.Client, r.CloudflareTunnelManager, r.Credentials, cfTunnel)
+	if err != nil {
+		return fmt.Errorf("failed to get Cloudflare Tunnel client: %w", err)
 	}
-	for _, host := range hosts {
-		if err := r.removeDNSRecord(ctx, tunnelID, host); err != nil {
-			return fmt.Errorf("failed to delete Cloudflare Tunnel: %w", err)
-		}
+
+	owner := ruleOwner("Ingress", ingress)
+	if err := releasePreviousTunnel(ctx, r.Client, r.CloudflareTunnelManager, r.Credentials, ingress, owner, &cfTunnel); err != nil {
+		return fmt.Errorf("failed to remove rules from the previous Cloudflare Tunnel: %w", err)
 	}
-	return nil
+
+	// 今のspecから作り直すと、削除前に消したホストやルーティングモードの変更前のルールが残るので、記録されたルールをすべて外す
+	return releaseTunnelRules(ctx, r.Client, manager, cfTunnel, zones, owner)
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *IngressReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&networkingv1.Ingress{}).
-		Complete(r)
+		Watches(&cftv1beta1.CloudflareTunnel{}, handler.EnqueueRequestsFromMapFunc(r.ingressesForTunnel), builder.WithPredicates(tunnelIDChanged)).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
+		Complete(requeueOnRateLimit(r))
 }
@@ -1,20 +1,13 @@
 package controller
 
 import (
-	"context"
 	"net/netip"
 	"reflect"
-	"sync"
 	"testing"
 
-	"github.com/cloudflare/cloudflare-go"
 	. "github.com/onsi/ginkgo/v2"
-	"github.com/stretchr/testify/assert"
-	cftv1beta1 "github.com/walnuts1018/cloudflare-tunnel-operator/api/v1beta1"
-	mock_controller "github.com/walnuts1018/cloudflare-tunnel-operator/internal/controller/mock"
-	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/domain"
-	"go.uber.org/mock/gomock"
 	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/utils/ptr"
 )
 
 var _ = Describe("Ingress Controller", func() {
@@ -28,14 +21,15 @@ var _ = Describe("Ingress Controller", func() {
 	})
 })
 
-func Test_getHosts(t *testing.T) {
+func Test_loadBalancerRules(t *testing.T) {
 	type args struct {
 		ingress networkingv1.Ingress
+		ip      netip.Addr
 	}
 	tests := []struct {
 		name string
 		args args
-		want []host
+		want []ingressRule
 	}{
 		{
 			name: "normal",
@@ -48,6 +42,23 @@ func Test_getHosts(t *testing.T) {
 							},
 							{
 								Host: "example2.walnuts.dev",
+								IngressRuleValue: networkingv1.IngressRuleValue{
+									HTTP: &networkingv1.HTTPIngressRuleValue{
+										Paths: []networkingv1.HTTPIngressPath{
+											{
+												Path:     "/api",
+												PathType: ptr.To(networkingv1.PathTypePrefix),
+											},
+											{
+												Path:     "/healthz",
+												PathType: ptr.To(networkingv1.PathTypeExact),
+											},
+										},
+									},
+								},
+							},
+							{
+								Host: "",
 							},
 						},
 						TLS: []networkingv1.IngressTLS{
@@ -63,122 +74,32 @@ func Test_getHosts(t *testing.T) {
 						},
 					},
 				},
+				ip: netip.MustParseAddr("192.168.0.1"),
 			},
-			want: []host{
+			want: []ingressRule{
 				{
-					Host: "example1.walnuts.dev",
-					TLS:  true,
+					Hostname: "example1.walnuts.dev",
+					Path:     "",
+					Service:  "https://192.168.0.1:443",
 				},
 				{
-					Host: "example2.walnuts.dev",
-					TLS:  false,
-				},
-			},
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			got := getHosts(tt.args.ingress)
-			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("getHosts() = %v, want %v", got, tt.want)
-			}
-		})
-	}
-}
-
-func TestIngressReconciler_updateCloudflareTunnelConfig(t *testing.T) {
-	ctx := context.Background()
-	type state struct {
-		Ingress []cloudflare.UnvalidatedIngressRule
-	}
-
-	type args struct {
-		tunnelID string
-		hosts    []host
-		IP       netip.Addr
-	}
-	tests := []struct {
-		name      string
-		state     state
-		args      args
-		wantState state
-	}{
-		{
-			name: "normal",
-			state: state{
-				Ingress: []cloudflare.UnvalidatedIngressRule{
-					{
-						Hostname: "example1.walnuts.dev",
-						Service:  "https://example1:80",
-					},
-					{
-						Hostname: "example2.walnuts.dev",
-						Service:  "https://example2:80",
-					},
-					{
-						Hostname: "",
-						Service:  "CatchAll",
-					},
+					Hostname: "example2.walnuts.dev",
+					Path:     `^/api(/.*)?$`,
+					Service:  "http://192.168.0.1:80",
 				},
-			},
-			args: args{
-				tunnelID: "test",
-				hosts: []host{
-					{
-						Host: "example3.walnuts.dev",
-						TLS:  true,
-					},
-				},
-				IP: netip.MustParseAddr("192.168.0.1"),
-			},
-			wantState: state{
-				Ingress: []cloudflare.UnvalidatedIngressRule{
-					{
-						Hostname: "example1.walnuts.dev",
-						Service:  "https://example1:80",
-					},
-					{
-						Hostname: "example2.walnuts.dev",
-						Service:  "https://example2:80",
-					},
-					{
-						Hostname: "example3.walnuts.dev",
-						Service:  "https://192.168.0.1:443",
-					},
-					{
-						Hostname: "",
-						Service:  "CatchAll",
-					},
+				{
+					Hostname: "example2.walnuts.dev",
+					Path:     `^/healthz$`,
+					Service:  "http://192.168.0.1:80",
 				},
 			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gomockctrl := gomock.NewController(t)
-			defer gomockctrl.Finish()
-
-			mockCloudflareTunnelManager := mock_controller.NewMockCloudflareTunnelManager(gomockctrl)
-			r := &IngressReconciler{
-				CloudflareTunnelManager: mockCloudflareTunnelManager,
-				mu:                      sync.Mutex{},
-			}
-			mockCloudflareTunnelManager.EXPECT().GetTunnelConfiguration(ctx, tt.args.tunnelID).Return(domain.TunnelConfiguration{
-				Ingress: tt.state.Ingress,
-			}, nil)
-			mockCloudflareTunnelManager.EXPECT().UpdateTunnelConfiguration(ctx, tt.args.tunnelID, gomock.Any()).Return(nil).Do(
-				func(ctx context.Context, tunnelID string, config domain.TunnelConfiguration) {
-					for i, rule := range config.Ingress {
-						assert.Equal(t, tt.wantState.Ingress[i].Hostname, rule.Hostname)
-						assert.Equal(t, tt.wantState.Ingress[i].Service, rule.Service)
-					}
-				},
-			)
-
-			if err := r.appendCloudflareTunnelConfig(ctx, tt.args.tunnelID, tt.args.hosts, tt.args.IP, cftv1beta1.CloudflareTunnelSettings{
-				CatchAllRule: "CatchAll",
-			}); err != nil {
-				t.Errorf("IngressReconciler.updateCloudflareTunnelConfig() error = %v", err)
+			got := loadBalancerRules(tt.args.ingress, tt.args.ip)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("loadBalancerRules() = %v, want %v", got, tt.want)
 			}
 		})
 	}
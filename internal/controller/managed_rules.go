@@ -0,0 +1,152 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/cloudflare/cloudflare-go"
+	cftv1beta1 "github.com/walnuts1018/cloudflare-tunnel-operator/api/v1beta1"
+	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/domain"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const managedRulesKey = "rules.json"
+
+// +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;create;update;patch;delete
+
+// managedRule is a tunnel ingress rule that the operator added to a tunnel.
+type managedRule struct {
+	Hostname      string                    `json:"hostname"`
+	Path          string                    `json:"path,omitempty"`
+	Service       string                    `json:"service"`
+	OriginRequest *cftv1beta1.OriginRequest `json:"originRequest,omitempty"`
+	// Owner is the resource that the rule was generated from, e.g. "Ingress/default/web".
+	Owner string `json:"owner"`
+}
+
+func (r managedRule) key() ruleKey {
+	return ruleKey{Hostname: r.Hostname, Path: r.Path}
+}
+
+func (r managedRule) ingressRule() cloudflare.UnvalidatedIngressRule {
+	return cloudflare.UnvalidatedIngressRule{
+		Hostname: r.Hostname,
+		Path:     r.Path,
+		Service:  r.Service,
+	}
+}
+
+// managedRules is the ownership record of a tunnel.
+// Only the rules in it are rewritten by the operator, and the other rules are left as they are.
+type managedRules map[ruleKey]managedRule
+
+// toIngressRule builds the rule with the current tunnel settings and the overrides of the rule.
+func (m managedRules) toIngressRule(key ruleKey, tunnelSettings cftv1beta1.CloudflareTunnelSettings) cloudflare.UnvalidatedIngressRule {
+	rule := m[key]
+	live := rule.ingressRule()
+	live.OriginRequest = domain.ToOriginRequestConfig(tunnelSettings, rule.Hostname, rule.Service, rule.OriginRequest)
+	return live
+}
+
+// hostnames returns the hostnames that are still used by the rules.
+func (m managedRules) hostnames() []string {
+	var hostnames []string
+	for key := range m {
+		if !slices.Contains(hostnames, key.Hostname) {
+			hostnames = append(hostnames, key.Hostname)
+		}
+	}
+	return hostnames
+}
+
+func managedRulesName(cfTunnel cftv1beta1.CloudflareTunnel) types.NamespacedName {
+	return types.NamespacedName{Namespace: cfTunnel.Namespace, Name: cfTunnel.Name + "-managed-rules"}
+}
+
+// loadManagedRules reads the ownership record of the tunnel from the ConfigMap.
+// ConfigMaps are not cached by the manager, so the record is read from the API server.
+func loadManagedRules(ctx context.Context, c client.Client, cfTunnel cftv1beta1.CloudflareTunnel) (managedRules, error) {
+	var cm corev1.ConfigMap
+	if err := c.Get(ctx, managedRulesName(cfTunnel), &cm); err != nil {
+		if apierrors.IsNotFound(err) {
+			return managedRules{}, nil
+		}
+		return nil, fmt.Errorf("failed to get ConfigMap: %w", err)
+	}
+
+	var list []managedRule
+	if data := cm.Data[managedRulesKey]; data != "" {
+		if err := json.Unmarshal([]byte(data), &list); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal managed rules: %w", err)
+		}
+	}
+
+	rules := make(managedRules, len(list))
+	for _, rule := range list {
+		rules[rule.key()] = rule
+	}
+	return rules, nil
+}
+
+// saveManagedRules stores the ownership record in a ConfigMap.
+// The ConfigMap has no owner reference, so that it is kept with a retained tunnel and used again when the tunnel is adopted;
+// it is deleted by deleteManagedRules when the tunnel is deleted.
+func saveManagedRules(ctx context.Context, c client.Client, cfTunnel cftv1beta1.CloudflareTunnel, rules managedRules) error {
+	list := slices.SortedFunc(maps.Values(rules), func(a, b managedRule) int {
+		return compareRules(a.ingressRule(), b.ingressRule())
+	})
+	data, err := json.Marshal(list)
+	if err != nil {
+		return fmt.Errorf("failed to marshal managed rules: %w", err)
+	}
+
+	name := managedRulesName(cfTunnel)
+	cm := &corev1.ConfigMap{}
+	if err := c.Get(ctx, name, cm); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to get ConfigMap: %w", err)
+		}
+
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: name.Namespace,
+				Name:      name.Name,
+				Labels:    appLabels(cfTunnel),
+			},
+			Data: map[string]string{managedRulesKey: string(data)},
+		}
+		if err := c.Create(ctx, cm); err != nil {
+			return fmt.Errorf("failed to create ConfigMap: %w", err)
+		}
+	} else {
+		patch := client.MergeFrom(cm.DeepCopy())
+		cm.Data = map[string]string{managedRulesKey: string(data)}
+		if err := c.Patch(ctx, cm, patch); err != nil {
+			return fmt.Errorf("failed to patch ConfigMap: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// deleteManagedRules deletes the ConfigMap of the ownership record of the tunnel.
+func deleteManagedRules(ctx context.Context, c client.Client, cfTunnel cftv1beta1.CloudflareTunnel) error {
+	name := managedRulesName(cfTunnel)
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: name.Namespace, Name: name.Name}}
+	if err := c.Delete(ctx, cm); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete ConfigMap: %w", err)
+	}
+	return nil
+}
+
+// ruleOwner returns the owner of the rules generated from the object, e.g. "Ingress/default/web".
+func ruleOwner(kind string, obj client.Object) string {
+	return kind + "/" + obj.GetNamespace() + "/" + obj.GetName()
+}
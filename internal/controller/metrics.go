@@ -0,0 +1,37 @@
+package controller
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	driftedRulesGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudflare_tunnel_operator_drifted_rules",
+		Help: "Number of tunnel ingress rules that had drifted from the desired state at the last resync.",
+	}, []string{"namespace", "name"})
+
+	driftedDNSRecordsGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudflare_tunnel_operator_drifted_dns_records",
+		Help: "Number of DNS records that did not point to the tunnel at the last resync.",
+	}, []string{"namespace", "name"})
+
+	connectedReplicasGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudflare_tunnel_operator_connected_replicas",
+		Help: "Number of cloudflared connectors that have active connections to the Cloudflare edge.",
+	}, []string{"namespace", "name"})
+
+	tunnelConnectionsGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudflare_tunnel_operator_tunnel_connections",
+		Help: "Number of active connections of the cloudflared connectors to the Cloudflare edge.",
+	}, []string{"namespace", "name"})
+
+	driftRepairsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cloudflare_tunnel_operator_drift_repairs_total",
+		Help: "Total number of drifted tunnel ingress rules and DNS records that were repaired.",
+	}, []string{"namespace", "name", "kind"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(driftedRulesGauge, driftedDNSRecordsGauge, driftRepairsTotal, connectedReplicasGauge, tunnelConnectionsGauge)
+}
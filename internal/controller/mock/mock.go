@@ -42,17 +42,17 @@ func (m *MockCloudflareTunnelManager) EXPECT() *MockCloudflareTunnelManagerMockR
 }
 
 // AddDNS mocks base method.
-func (m *MockCloudflareTunnelManager) AddDNS(ctx context.Context, tunnelID, hostname string) error {
+func (m *MockCloudflareTunnelManager) AddDNS(ctx context.Context, zoneID, tunnelID, hostname string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "AddDNS", ctx, tunnelID, hostname)
+	ret := m.ctrl.Call(m, "AddDNS", ctx, zoneID, tunnelID, hostname)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // AddDNS indicates an expected call of AddDNS.
-func (mr *MockCloudflareTunnelManagerMockRecorder) AddDNS(ctx, tunnelID, hostname any) *gomock.Call {
+func (mr *MockCloudflareTunnelManagerMockRecorder) AddDNS(ctx, zoneID, tunnelID, hostname any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddDNS", reflect.TypeOf((*MockCloudflareTunnelManager)(nil).AddDNS), ctx, tunnelID, hostname)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddDNS", reflect.TypeOf((*MockCloudflareTunnelManager)(nil).AddDNS), ctx, zoneID, tunnelID, hostname)
 }
 
 // CreateTunnel mocks base method.
@@ -71,31 +71,31 @@ func (mr *MockCloudflareTunnelManagerMockRecorder) CreateTunnel(ctx, Name any) *
 }
 
 // DeleteAllDNS mocks base method.
-func (m *MockCloudflareTunnelManager) DeleteAllDNS(ctx context.Context, tunnelID string) error {
+func (m *MockCloudflareTunnelManager) DeleteAllDNS(ctx context.Context, tunnelID string, allowedZones []string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DeleteAllDNS", ctx, tunnelID)
+	ret := m.ctrl.Call(m, "DeleteAllDNS", ctx, tunnelID, allowedZones)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // DeleteAllDNS indicates an expected call of DeleteAllDNS.
-func (mr *MockCloudflareTunnelManagerMockRecorder) DeleteAllDNS(ctx, tunnelID any) *gomock.Call {
+func (mr *MockCloudflareTunnelManagerMockRecorder) DeleteAllDNS(ctx, tunnelID, allowedZones any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteAllDNS", reflect.TypeOf((*MockCloudflareTunnelManager)(nil).DeleteAllDNS), ctx, tunnelID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteAllDNS", reflect.TypeOf((*MockCloudflareTunnelManager)(nil).DeleteAllDNS), ctx, tunnelID, allowedZones)
 }
 
 // DeleteDNS mocks base method.
-func (m *MockCloudflareTunnelManager) DeleteDNS(ctx context.Context, tunnelID, recordID string) error {
+func (m *MockCloudflareTunnelManager) DeleteDNS(ctx context.Context, zoneID, recordID string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DeleteDNS", ctx, tunnelID, recordID)
+	ret := m.ctrl.Call(m, "DeleteDNS", ctx, zoneID, recordID)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // DeleteDNS indicates an expected call of DeleteDNS.
-func (mr *MockCloudflareTunnelManagerMockRecorder) DeleteDNS(ctx, tunnelID, recordID any) *gomock.Call {
+func (mr *MockCloudflareTunnelManagerMockRecorder) DeleteDNS(ctx, zoneID, recordID any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteDNS", reflect.TypeOf((*MockCloudflareTunnelManager)(nil).DeleteDNS), ctx, tunnelID, recordID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteDNS", reflect.TypeOf((*MockCloudflareTunnelManager)(nil).DeleteDNS), ctx, zoneID, recordID)
 }
 
 // DeleteTunnel mocks base method.
@@ -112,19 +112,34 @@ func (mr *MockCloudflareTunnelManagerMockRecorder) DeleteTunnel(ctx, id any) *go
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteTunnel", reflect.TypeOf((*MockCloudflareTunnelManager)(nil).DeleteTunnel), ctx, id)
 }
 
+// FindTunnelByName mocks base method.
+func (m *MockCloudflareTunnelManager) FindTunnelByName(ctx context.Context, name string) (domain.CloudflareTunnel, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindTunnelByName", ctx, name)
+	ret0, _ := ret[0].(domain.CloudflareTunnel)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindTunnelByName indicates an expected call of FindTunnelByName.
+func (mr *MockCloudflareTunnelManagerMockRecorder) FindTunnelByName(ctx, name any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindTunnelByName", reflect.TypeOf((*MockCloudflareTunnelManager)(nil).FindTunnelByName), ctx, name)
+}
+
 // GetDNS mocks base method.
-func (m *MockCloudflareTunnelManager) GetDNS(ctx context.Context, tunnelID, hostname string) (domain.DNSRecord, error) {
+func (m *MockCloudflareTunnelManager) GetDNS(ctx context.Context, zoneID, tunnelID, hostname string) (domain.DNSRecord, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetDNS", ctx, tunnelID, hostname)
+	ret := m.ctrl.Call(m, "GetDNS", ctx, zoneID, tunnelID, hostname)
 	ret0, _ := ret[0].(domain.DNSRecord)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetDNS indicates an expected call of GetDNS.
-func (mr *MockCloudflareTunnelManagerMockRecorder) GetDNS(ctx, tunnelID, hostname any) *gomock.Call {
+func (mr *MockCloudflareTunnelManagerMockRecorder) GetDNS(ctx, zoneID, tunnelID, hostname any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDNS", reflect.TypeOf((*MockCloudflareTunnelManager)(nil).GetDNS), ctx, tunnelID, hostname)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDNS", reflect.TypeOf((*MockCloudflareTunnelManager)(nil).GetDNS), ctx, zoneID, tunnelID, hostname)
 }
 
 // GetTunnel mocks base method.
@@ -172,26 +187,86 @@ func (mr *MockCloudflareTunnelManagerMockRecorder) GetTunnelToken(ctx, tunnelID
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTunnelToken", reflect.TypeOf((*MockCloudflareTunnelManager)(nil).GetTunnelToken), ctx, tunnelID)
 }
 
+// ListTunnelConnectors mocks base method.
+func (m *MockCloudflareTunnelManager) ListTunnelConnectors(ctx context.Context, tunnelID string) ([]domain.TunnelConnector, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListTunnelConnectors", ctx, tunnelID)
+	ret0, _ := ret[0].([]domain.TunnelConnector)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListTunnelConnectors indicates an expected call of ListTunnelConnectors.
+func (mr *MockCloudflareTunnelManagerMockRecorder) ListTunnelConnectors(ctx, tunnelID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListTunnelConnectors", reflect.TypeOf((*MockCloudflareTunnelManager)(nil).ListTunnelConnectors), ctx, tunnelID)
+}
+
+// ListZones mocks base method.
+func (m *MockCloudflareTunnelManager) ListZones(ctx context.Context, allowedZones []string) ([]domain.Zone, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListZones", ctx, allowedZones)
+	ret0, _ := ret[0].([]domain.Zone)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListZones indicates an expected call of ListZones.
+func (mr *MockCloudflareTunnelManagerMockRecorder) ListZones(ctx, allowedZones any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListZones", reflect.TypeOf((*MockCloudflareTunnelManager)(nil).ListZones), ctx, allowedZones)
+}
+
+// ResolveZone mocks base method.
+func (m *MockCloudflareTunnelManager) ResolveZone(ctx context.Context, hostname string, allowedZones []string) (domain.Zone, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResolveZone", ctx, hostname, allowedZones)
+	ret0, _ := ret[0].(domain.Zone)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ResolveZone indicates an expected call of ResolveZone.
+func (mr *MockCloudflareTunnelManagerMockRecorder) ResolveZone(ctx, hostname, allowedZones any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResolveZone", reflect.TypeOf((*MockCloudflareTunnelManager)(nil).ResolveZone), ctx, hostname, allowedZones)
+}
+
+// RotateTunnelSecret mocks base method.
+func (m *MockCloudflareTunnelManager) RotateTunnelSecret(ctx context.Context, tunnelID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RotateTunnelSecret", ctx, tunnelID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RotateTunnelSecret indicates an expected call of RotateTunnelSecret.
+func (mr *MockCloudflareTunnelManagerMockRecorder) RotateTunnelSecret(ctx, tunnelID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RotateTunnelSecret", reflect.TypeOf((*MockCloudflareTunnelManager)(nil).RotateTunnelSecret), ctx, tunnelID)
+}
+
 // UpdateDNS mocks base method.
-func (m *MockCloudflareTunnelManager) UpdateDNS(ctx context.Context, tunnelID, hostname string, current domain.DNSRecord) error {
+func (m *MockCloudflareTunnelManager) UpdateDNS(ctx context.Context, zoneID, tunnelID, hostname string, current domain.DNSRecord) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateDNS", ctx, tunnelID, hostname, current)
+	ret := m.ctrl.Call(m, "UpdateDNS", ctx, zoneID, tunnelID, hostname, current)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // UpdateDNS indicates an expected call of UpdateDNS.
-func (mr *MockCloudflareTunnelManagerMockRecorder) UpdateDNS(ctx, tunnelID, hostname, current any) *gomock.Call {
+func (mr *MockCloudflareTunnelManagerMockRecorder) UpdateDNS(ctx, zoneID, tunnelID, hostname, current any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateDNS", reflect.TypeOf((*MockCloudflareTunnelManager)(nil).UpdateDNS), ctx, tunnelID, hostname, current)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateDNS", reflect.TypeOf((*MockCloudflareTunnelManager)(nil).UpdateDNS), ctx, zoneID, tunnelID, hostname, current)
 }
 
 // UpdateTunnelConfiguration mocks base method.
-func (m *MockCloudflareTunnelManager) UpdateTunnelConfiguration(ctx context.Context, tunnelID string, config domain.TunnelConfiguration) error {
+func (m *MockCloudflareTunnelManager) UpdateTunnelConfiguration(ctx context.Context, tunnelID string, config domain.TunnelConfiguration) (domain.TunnelConfiguration, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "UpdateTunnelConfiguration", ctx, tunnelID, config)
-	ret0, _ := ret[0].(error)
-	return ret0
+	ret0, _ := ret[0].(domain.TunnelConfiguration)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // UpdateTunnelConfiguration indicates an expected call of UpdateTunnelConfiguration.
@@ -0,0 +1,176 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	cftv1beta1 "github.com/walnuts1018/cloudflare-tunnel-operator/api/v1beta1"
+	"k8s.io/utils/ptr"
+)
+
+// Annotations that override CloudflareTunnelSettings for the rules of a single Ingress.
+const (
+	httpHostHeaderAnnotation         = annotationPrefix + "http-host-header"
+	originServerNameAnnotation       = annotationPrefix + "origin-server-name"
+	caPoolAnnotation                 = annotationPrefix + "ca-pool"
+	noTLSVerifyAnnotation            = annotationPrefix + "no-tls-verify"
+	tlsTimeoutAnnotation             = annotationPrefix + "tls-timeout"
+	http2OriginAnnotation            = annotationPrefix + "http2-origin"
+	disableChunkedEncodingAnnotation = annotationPrefix + "disable-chunked-encoding"
+	connectTimeoutAnnotation         = annotationPrefix + "connect-timeout"
+	noHappyEyeballsAnnotation        = annotationPrefix + "no-happy-eyeballs"
+	proxyTypeAnnotation              = annotationPrefix + "proxy-type"
+	keepAliveTimeoutAnnotation       = annotationPrefix + "keep-alive-timeout"
+	keepAliveConnectionsAnnotation   = annotationPrefix + "keep-alive-connections"
+	tcpKeepAliveAnnotation           = annotationPrefix + "tcp-keep-alive"
+	preserveHostHeaderAnnotation     = annotationPrefix + "preserve-host-header"
+	bastionModeAnnotation            = annotationPrefix + "bastion-mode"
+	proxyAddressAnnotation           = annotationPrefix + "proxy-address"
+	proxyPortAnnotation              = annotationPrefix + "proxy-port"
+	accessTeamNameAnnotation         = annotationPrefix + "access-team-name"
+	// accessAudTagAnnotation is a comma-separated list of the Application Audience tags.
+	accessAudTagAnnotation   = annotationPrefix + "access-aud-tag"
+	accessRequiredAnnotation = annotationPrefix + "access-required"
+)
+
+// parseOriginRequestAnnotations returns the origin settings overridden by the annotations, or nil if there are none.
+// Invalid annotations are skipped, so that the rules fall back to the tunnel settings, and returned as an error.
+func parseOriginRequestAnnotations(annotations map[string]string) (*cftv1beta1.OriginRequest, error) {
+	var (
+		o    cftv1beta1.OriginRequest
+		set  bool
+		errs []error
+	)
+
+	parse := func(key string, f func(v string) error) {
+		v, ok := annotations[key]
+		if !ok {
+			return
+		}
+		if err := f(strings.TrimSpace(v)); err != nil {
+			errs = append(errs, fmt.Errorf("invalid annotation %s: %q: %w", key, v, err))
+			return
+		}
+		set = true
+	}
+
+	parse(httpHostHeaderAnnotation, func(v string) error {
+		o.HTTPHostHeader = ptr.To(v)
+		return nil
+	})
+	parse(originServerNameAnnotation, func(v string) error {
+		o.OriginServerName = ptr.To(v)
+		return nil
+	})
+	parse(caPoolAnnotation, func(v string) error {
+		o.CAPool = ptr.To(v)
+		return nil
+	})
+	parse(noTLSVerifyAnnotation, boolAnnotation(&o.NoTLSVerify))
+	parse(tlsTimeoutAnnotation, secondsAnnotation(&o.TLSTimeoutSeconds))
+	parse(http2OriginAnnotation, boolAnnotation(&o.HTTP2Origin))
+	parse(disableChunkedEncodingAnnotation, boolAnnotation(&o.DisableChunkedEncoding))
+	parse(connectTimeoutAnnotation, secondsAnnotation(&o.ConnectTimeoutSeconds))
+	parse(noHappyEyeballsAnnotation, boolAnnotation(&o.NoHappyEyeballs))
+	parse(proxyTypeAnnotation, func(v string) error {
+		if v != "" && v != "socks" {
+			return errors.New(`expected "" or "socks"`)
+		}
+		o.ProxyType = ptr.To(v)
+		return nil
+	})
+	parse(keepAliveTimeoutAnnotation, secondsAnnotation(&o.KeepAliveTimeoutSeconds))
+	parse(keepAliveConnectionsAnnotation, func(v string) error {
+		n, err := strconv.ParseInt(v, 10, 32)
+		if err != nil || n < 0 {
+			return errors.New("expected a non-negative integer")
+		}
+		o.KeepAliveConnections = ptr.To(int32(n))
+		return nil
+	})
+	parse(tcpKeepAliveAnnotation, secondsAnnotation(&o.TCPKeepAliveSeconds))
+	parse(preserveHostHeaderAnnotation, boolAnnotation(&o.PreserveHostHeader))
+	parse(bastionModeAnnotation, boolAnnotation(&o.BastionMode))
+	parse(proxyAddressAnnotation, func(v string) error {
+		o.ProxyAddress = ptr.To(v)
+		return nil
+	})
+	parse(proxyPortAnnotation, func(v string) error {
+		n, err := strconv.ParseUint(v, 10, 16)
+		if err != nil {
+			return errors.New("expected a port number")
+		}
+		o.ProxyPort = ptr.To(int32(n))
+		return nil
+	})
+	parse(accessTeamNameAnnotation, func(v string) error {
+		if v == "" {
+			return errors.New("team name is empty")
+		}
+		access := cftv1beta1.AccessConfig{TeamName: v}
+		if tags := annotations[accessAudTagAnnotation]; tags != "" {
+			for tag := range strings.SplitSeq(tags, ",") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					access.AudTag = append(access.AudTag, tag)
+				}
+			}
+		}
+		if required, ok := annotations[accessRequiredAnnotation]; ok {
+			b, err := strconv.ParseBool(strings.TrimSpace(required))
+			if err != nil {
+				return fmt.Errorf("invalid annotation %s: expected true or false", accessRequiredAnnotation)
+			}
+			access.Required = b
+		}
+		o.Access = &access
+		return nil
+	})
+	// Accessの設定はチーム名がないと使えないので、他のAnnotationだけがあれば無視せずにエラーにする
+	if _, ok := annotations[accessTeamNameAnnotation]; !ok {
+		for _, key := range []string{accessAudTagAnnotation, accessRequiredAnnotation} {
+			if _, ok := annotations[key]; ok {
+				errs = append(errs, fmt.Errorf("invalid annotation %s: %s is required", key, accessTeamNameAnnotation))
+			}
+		}
+	}
+
+	if !set {
+		return nil, errors.Join(errs...)
+	}
+	return &o, errors.Join(errs...)
+}
+
+func boolAnnotation(dst **bool) func(v string) error {
+	return func(v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return errors.New("expected true or false")
+		}
+		*dst = ptr.To(b)
+		return nil
+	}
+}
+
+// secondsAnnotation parses a duration such as "30s" or "1m", or a number of seconds.
+func secondsAnnotation(dst **int32) func(v string) error {
+	return func(v string) error {
+		var seconds int64
+		if n, err := strconv.ParseInt(v, 10, 32); err == nil {
+			seconds = n
+		} else {
+			d, err := time.ParseDuration(v)
+			if err != nil || d%time.Second != 0 {
+				return errors.New("expected a duration in whole seconds, e.g. 30s")
+			}
+			seconds = int64(d / time.Second)
+		}
+		if seconds < 0 || seconds > int64(^uint32(0)>>1) {
+			return errors.New("duration is out of range")
+		}
+		*dst = ptr.To(int32(seconds))
+		return nil
+	}
+}
@@ -0,0 +1,115 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	cftv1beta1 "github.com/walnuts1018/cloudflare-tunnel-operator/api/v1beta1"
+	"k8s.io/utils/ptr"
+)
+
+func Test_parseOriginRequestAnnotations(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        *cftv1beta1.OriginRequest
+		wantErr     bool
+	}{
+		{
+			name: "no annotations",
+			want: nil,
+		},
+		{
+			name: "all annotations",
+			annotations: map[string]string{
+				httpHostHeaderAnnotation:         "legacy.internal",
+				originServerNameAnnotation:       "legacy.internal",
+				caPoolAnnotation:                 "/etc/ca.pem",
+				noTLSVerifyAnnotation:            "true",
+				tlsTimeoutAnnotation:             "20s",
+				http2OriginAnnotation:            "true",
+				disableChunkedEncodingAnnotation: "false",
+				connectTimeoutAnnotation:         "2m",
+				noHappyEyeballsAnnotation:        "true",
+				proxyTypeAnnotation:              "socks",
+				keepAliveTimeoutAnnotation:       "120",
+				keepAliveConnectionsAnnotation:   "10",
+				tcpKeepAliveAnnotation:           "15s",
+				preserveHostHeaderAnnotation:     "true",
+				bastionModeAnnotation:            "false",
+				proxyAddressAnnotation:           "127.0.0.1",
+				proxyPortAnnotation:              "1080",
+				accessTeamNameAnnotation:         "walnuts",
+				accessAudTagAnnotation:           "aud1, aud2",
+				accessRequiredAnnotation:         "true",
+			},
+			want: &cftv1beta1.OriginRequest{
+				HTTPHostHeader:          ptr.To("legacy.internal"),
+				OriginServerName:        ptr.To("legacy.internal"),
+				CAPool:                  ptr.To("/etc/ca.pem"),
+				NoTLSVerify:             ptr.To(true),
+				TLSTimeoutSeconds:       ptr.To[int32](20),
+				HTTP2Origin:             ptr.To(true),
+				DisableChunkedEncoding:  ptr.To(false),
+				ConnectTimeoutSeconds:   ptr.To[int32](120),
+				NoHappyEyeballs:         ptr.To(true),
+				ProxyType:               ptr.To("socks"),
+				KeepAliveTimeoutSeconds: ptr.To[int32](120),
+				KeepAliveConnections:    ptr.To[int32](10),
+				TCPKeepAliveSeconds:     ptr.To[int32](15),
+				PreserveHostHeader:      ptr.To(true),
+				BastionMode:             ptr.To(false),
+				ProxyAddress:            ptr.To("127.0.0.1"),
+				ProxyPort:               ptr.To[int32](1080),
+				Access: &cftv1beta1.AccessConfig{
+					Required: true,
+					TeamName: "walnuts",
+					AudTag:   []string{"aud1", "aud2"},
+				},
+			},
+		},
+		{
+			name: "invalid annotations are skipped",
+			annotations: map[string]string{
+				noTLSVerifyAnnotation:    "yes",
+				connectTimeoutAnnotation: "60s",
+				tlsTimeoutAnnotation:     "1.5s",
+			},
+			want: &cftv1beta1.OriginRequest{
+				ConnectTimeoutSeconds: ptr.To[int32](60),
+			},
+			wantErr: true,
+		},
+		{
+			name: "access without team name",
+			annotations: map[string]string{
+				accessAudTagAnnotation:   "aud1",
+				accessRequiredAnnotation: "true",
+				http2OriginAnnotation:    "true",
+			},
+			want: &cftv1beta1.OriginRequest{
+				HTTP2Origin: ptr.To(true),
+			},
+			wantErr: true,
+		},
+		{
+			name: "only invalid annotations",
+			annotations: map[string]string{
+				keepAliveConnectionsAnnotation: "-1",
+			},
+			want:    nil,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseOriginRequestAnnotations(tt.annotations)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseOriginRequestAnnotations() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseOriginRequestAnnotations() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
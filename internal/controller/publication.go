@@ -0,0 +1,183 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	cftv1beta1 "github.com/walnuts1018/cloudflare-tunnel-operator/api/v1beta1"
+	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/domain"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// publicationAnnotation is set on the Ingress to the publication state of each host, in JSON.
+const publicationAnnotation = annotationPrefix + "publication"
+
+// hostPublication is the publication state of a host in Cloudflare.
+type hostPublication struct {
+	Host string `json:"host"`
+	// Tunnel is the namespace and name of the CloudflareTunnel that the host is published to.
+	Tunnel string `json:"tunnel"`
+	// Live is true if every rule of the host is in the tunnel configuration.
+	Live bool `json:"live"`
+	// DNSHealthy is true if the DNS record of the host points to the tunnel.
+	DNSHealthy bool `json:"dnsHealthy"`
+	// DNSObservedTime is when the DNS record was fetched from Cloudflare.
+	DNSObservedTime *metav1.Time `json:"dnsObservedTime,omitempty"`
+	Error           string       `json:"error,omitempty"`
+}
+
+func (p hostPublication) published() bool {
+	return p.Live && p.DNSHealthy && p.Error == ""
+}
+
+// observePublication returns the publication state of each host of rules.
+// The tunnel configuration is taken from the writer of the tunnel, which holds the configuration it fetched or pushed last,
+// and it is fetched from Cloudflare only if it is unknown.
+// The DNS records are fetched only for the hosts that were not reported healthy in previous within publishedRecheckInterval before now,
+// so that a DNS record changed outside of the operator is noticed without fetching every record on every reconcile.
+func observePublication(ctx context.Context, manager CloudflareTunnelManager, cfTunnel cftv1beta1.CloudflareTunnel, zones []string, rules []ingressRule, previous []hostPublication, now time.Time) ([]hostPublication, error) {
+	tunnelID := cfTunnel.Status.TunnelID
+	config, err := liveTunnelConfig(ctx, manager, tunnelID)
+	if err != nil {
+		return nil, err
+	}
+
+	tunnel := cfTunnel.Namespace + "/" + cfTunnel.Name
+	var publications []hostPublication
+	for _, hostname := range hostnames(rules) {
+		publication := hostPublication{
+			Host:   hostname,
+			Tunnel: tunnel,
+			Live:   true,
+		}
+		for _, rule := range rules {
+			if rule.Hostname != hostname {
+				continue
+			}
+			if !slices.ContainsFunc(config.Ingress, func(live cloudflare.UnvalidatedIngressRule) bool {
+				return live.Hostname == rule.Hostname && live.Path == rule.Path && live.Service == rule.Service
+			}) {
+				publication.Live = false
+			}
+		}
+
+		if i := slices.IndexFunc(previous, func(p hostPublication) bool {
+			return p.Host == hostname && p.Tunnel == tunnel && p.DNSHealthy && p.Error == "" &&
+				p.DNSObservedTime != nil && now.Sub(p.DNSObservedTime.Time) < publishedRecheckInterval
+		}); i >= 0 {
+			publication.DNSHealthy = true
+			publication.DNSObservedTime = previous[i].DNSObservedTime
+			publications = append(publications, publication)
+			continue
+		}
+
+		zone, err := manager.ResolveZone(ctx, hostname, zones)
+		if err != nil {
+			if !errors.Is(err, domain.ErrZoneNotFound) {
+				return nil, fmt.Errorf("failed to resolve zone: %w", err)
+			}
+			publication.Error = err.Error()
+			publications = append(publications, publication)
+			continue
+		}
+
+		dnsRecord, err := manager.GetDNS(ctx, zone.ID, tunnelID, hostname)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get DNS record: %w", err)
+		}
+		publication.DNSHealthy = dnsRecord.Healthy(tunnelID)
+		publication.DNSObservedTime = &metav1.Time{Time: now}
+		publications = append(publications, publication)
+	}
+	return publications, nil
+}
+
+// recordedPublication returns the publication state recorded in publicationAnnotation of obj.
+// A malformed annotation is ignored, so that the state is observed again.
+func recordedPublication(obj client.Object) []hostPublication {
+	value, ok := obj.GetAnnotations()[publicationAnnotation]
+	if !ok {
+		return nil
+	}
+
+	var publications []hostPublication
+	if err := json.Unmarshal([]byte(value), &publications); err != nil {
+		return nil
+	}
+	return publications
+}
+
+// recordPublication writes publications to publicationAnnotation of obj, or removes it if publications is empty.
+// An event is emitted only when the state changes, so that the same state is not reported on every reconcile or recheck.
+func recordPublication(ctx context.Context, c client.Client, recorder record.EventRecorder, obj client.Object, publications []hostPublication) error {
+	var value string
+	if len(publications) > 0 {
+		b, err := json.Marshal(publications)
+		if err != nil {
+			return fmt.Errorf("failed to marshal publication state: %w", err)
+		}
+		value = string(b)
+	}
+
+	annotations := obj.GetAnnotations()
+	if annotations[publicationAnnotation] == value {
+		return nil
+	}
+	previous := recordedPublication(obj)
+
+	patch := client.MergeFrom(obj.DeepCopyObject().(client.Object))
+	if value == "" {
+		delete(annotations, publicationAnnotation)
+	} else {
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[publicationAnnotation] = value
+	}
+	obj.SetAnnotations(annotations)
+
+	if err := c.Patch(ctx, obj, patch); err != nil {
+		return fmt.Errorf("failed to record publication state: %w", err)
+	}
+
+	if len(publications) == 0 || samePublication(previous, publications) {
+		return nil
+	}
+
+	var published, problems []string
+	for _, p := range publications {
+		switch {
+		case p.published():
+			published = append(published, p.Host)
+		case p.Error != "":
+			problems = append(problems, fmt.Sprintf("%s: %s", p.Host, p.Error))
+		case !p.Live:
+			problems = append(problems, fmt.Sprintf("%s: the rule is not in the configuration of Cloudflare Tunnel %s", p.Host, p.Tunnel))
+		default:
+			problems = append(problems, fmt.Sprintf("%s: the DNS record does not point to Cloudflare Tunnel %s", p.Host, p.Tunnel))
+		}
+	}
+	if len(problems) > 0 {
+		recorder.Event(obj, corev1.EventTypeWarning, "NotPublished", strings.Join(problems, "; "))
+		return nil
+	}
+	recorder.Eventf(obj, corev1.EventTypeNormal, "Published", "Published %s to Cloudflare Tunnel %s", strings.Join(published, ", "), publications[0].Tunnel)
+	return nil
+}
+
+// samePublication reports whether a and b are the same state, ignoring when the DNS records were observed.
+func samePublication(a, b []hostPublication) bool {
+	return slices.EqualFunc(a, b, func(x, y hostPublication) bool {
+		x.DNSObservedTime, y.DNSObservedTime = nil, nil
+		return x == y
+	})
+}
@@ -0,0 +1,222 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	cftv1beta1 "github.com/walnuts1018/cloudflare-tunnel-operator/api/v1beta1"
+	mock_controller "github.com/walnuts1018/cloudflare-tunnel-operator/internal/controller/mock"
+	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/domain"
+	"go.uber.org/mock/gomock"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_observePublication(t *testing.T) {
+	ctx := context.Background()
+	healthy := domain.DNSRecord{ID: "record", Type: "CNAME", Content: "observe-id.cfargotunnel.com", Proxied: ptr.To(true)}
+	config := domain.TunnelConfiguration{
+		Ingress: []cloudflare.UnvalidatedIngressRule{
+			{Hostname: "a.example.com", Path: "/api", Service: "http://api.default.svc:80"},
+			{Hostname: "a.example.com", Service: "http://web.default.svc:80"},
+			{Hostname: "b.example.com", Service: "http://old.default.svc:80"},
+			{Service: "http_status:404"},
+		},
+	}
+	rules := []ingressRule{
+		{Hostname: "a.example.com", Path: "/api", Service: "http://api.default.svc:80"},
+		{Hostname: "a.example.com", Service: "http://web.default.svc:80"},
+		{Hostname: "b.example.com", Service: "http://new.default.svc:80"},
+		{Hostname: "c.example.org", Service: "http://web.default.svc:80"},
+	}
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	observed := &metav1.Time{Time: now}
+	want := []hostPublication{
+		{Host: "a.example.com", Tunnel: "default/tunnel", Live: true, DNSHealthy: true, DNSObservedTime: observed},
+		{Host: "b.example.com", Tunnel: "default/tunnel", Live: false, DNSHealthy: false, DNSObservedTime: observed},
+		{Host: "c.example.org", Tunnel: "default/tunnel", Live: false, Error: domain.ErrZoneNotFound.Error()},
+	}
+
+	t.Run("fetches the unknown state from Cloudflare", func(t *testing.T) {
+		cfTunnel := cftv1beta1.CloudflareTunnel{
+			ObjectMeta: metav1.ObjectMeta{Name: "tunnel", Namespace: "default"},
+			Status:     cftv1beta1.CloudflareTunnelStatus{TunnelID: "observe-id"},
+		}
+		forgetTunnelConfigWriter("observe-id")
+		t.Cleanup(func() { forgetTunnelConfigWriter("observe-id") })
+
+		m := mock_controller.NewMockCloudflareTunnelManager(gomock.NewController(t))
+		m.EXPECT().GetTunnelConfiguration(ctx, "observe-id").Return(config, nil)
+		m.EXPECT().ResolveZone(ctx, "a.example.com", nil).Return(domain.Zone{ID: "zone"}, nil)
+		m.EXPECT().GetDNS(ctx, "zone", "observe-id", "a.example.com").Return(healthy, nil)
+		m.EXPECT().ResolveZone(ctx, "b.example.com", nil).Return(domain.Zone{ID: "zone"}, nil)
+		m.EXPECT().GetDNS(ctx, "zone", "observe-id", "b.example.com").Return(domain.DNSRecord{}, nil)
+		m.EXPECT().ResolveZone(ctx, "c.example.org", nil).Return(domain.Zone{}, domain.ErrZoneNotFound)
+
+		got, err := observePublication(ctx, m, cfTunnel, nil, rules, nil, now)
+		if err != nil {
+			t.Fatalf("observePublication() error = %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("observePublication() = %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("uses the configuration of the writer and the healthy hosts recorded before", func(t *testing.T) {
+		cfTunnel := cftv1beta1.CloudflareTunnel{
+			ObjectMeta: metav1.ObjectMeta{Name: "tunnel", Namespace: "default"},
+			Status:     cftv1beta1.CloudflareTunnelStatus{TunnelID: "observe-id"},
+		}
+		tunnelConfigWriterFor("observe-id").live = &config
+		t.Cleanup(func() { forgetTunnelConfigWriter("observe-id") })
+
+		m := mock_controller.NewMockCloudflareTunnelManager(gomock.NewController(t))
+		m.EXPECT().ResolveZone(ctx, "b.example.com", nil).Return(domain.Zone{ID: "zone"}, nil)
+		m.EXPECT().GetDNS(ctx, "zone", "observe-id", "b.example.com").Return(domain.DNSRecord{}, nil)
+		m.EXPECT().ResolveZone(ctx, "c.example.org", nil).Return(domain.Zone{}, domain.ErrZoneNotFound)
+
+		later := now.Add(publishedRecheckInterval - time.Second)
+		got, err := observePublication(ctx, m, cfTunnel, nil, rules, []hostPublication{
+			{Host: "a.example.com", Tunnel: "default/tunnel", Live: true, DNSHealthy: true, DNSObservedTime: observed},
+			{Host: "b.example.com", Tunnel: "default/other", Live: true, DNSHealthy: true, DNSObservedTime: observed},
+		}, later)
+		if err != nil {
+			t.Fatalf("observePublication() error = %v", err)
+		}
+		want := slices.Clone(want)
+		want[1].DNSObservedTime = &metav1.Time{Time: later}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("observePublication() = %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("checks the healthy hosts again after the recheck interval", func(t *testing.T) {
+		cfTunnel := cftv1beta1.CloudflareTunnel{
+			ObjectMeta: metav1.ObjectMeta{Name: "tunnel", Namespace: "default"},
+			Status:     cftv1beta1.CloudflareTunnelStatus{TunnelID: "observe-id"},
+		}
+		tunnelConfigWriterFor("observe-id").live = &config
+		t.Cleanup(func() { forgetTunnelConfigWriter("observe-id") })
+
+		// 外からDNSレコードが消されている
+		later := now.Add(publishedRecheckInterval)
+		m := mock_controller.NewMockCloudflareTunnelManager(gomock.NewController(t))
+		m.EXPECT().ResolveZone(ctx, "a.example.com", nil).Return(domain.Zone{ID: "zone"}, nil)
+		m.EXPECT().GetDNS(ctx, "zone", "observe-id", "a.example.com").Return(domain.DNSRecord{}, nil)
+
+		got, err := observePublication(ctx, m, cfTunnel, nil, rules[:2], []hostPublication{
+			{Host: "a.example.com", Tunnel: "default/tunnel", Live: true, DNSHealthy: true, DNSObservedTime: observed},
+		}, later)
+		if err != nil {
+			t.Fatalf("observePublication() error = %v", err)
+		}
+		want := []hostPublication{{Host: "a.example.com", Tunnel: "default/tunnel", Live: true, DNSObservedTime: &metav1.Time{Time: later}}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("observePublication() = %+v, want %+v", got, want)
+		}
+	})
+}
+
+func Test_recordedPublication(t *testing.T) {
+	published := []hostPublication{{Host: "a.example.com", Tunnel: "default/tunnel", Live: true, DNSHealthy: true}}
+	b, err := json.Marshal(published)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        []hostPublication
+	}{
+		{name: "not recorded"},
+		{name: "recorded", annotations: map[string]string{publicationAnnotation: string(b)}, want: published},
+		{name: "malformed", annotations: map[string]string{publicationAnnotation: "{"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ingress := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations}}
+			if got := recordedPublication(ingress); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("recordedPublication() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_recordPublication(t *testing.T) {
+	ctx := context.Background()
+
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "ingress", Namespace: "default", Annotations: map[string]string{"other": "value"}},
+	}
+	c := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(ingress).Build()
+	recorder := record.NewFakeRecorder(10)
+
+	published := []hostPublication{{Host: "a.example.com", Tunnel: "default/tunnel", Live: true, DNSHealthy: true}}
+	if err := recordPublication(ctx, c, recorder, ingress, published); err != nil {
+		t.Fatalf("recordPublication() error = %v", err)
+	}
+	if got := <-recorder.Events; got != "Normal Published Published a.example.com to Cloudflare Tunnel default/tunnel" {
+		t.Errorf("event = %q", got)
+	}
+
+	var got networkingv1.Ingress
+	if err := c.Get(ctx, client.ObjectKeyFromObject(ingress), &got); err != nil {
+		t.Fatal(err)
+	}
+	var gotPublications []hostPublication
+	if err := json.Unmarshal([]byte(got.Annotations[publicationAnnotation]), &gotPublications); err != nil {
+		t.Fatalf("failed to unmarshal %s annotation: %v", publicationAnnotation, err)
+	}
+	if !reflect.DeepEqual(gotPublications, published) {
+		t.Errorf("%s = %+v, want %+v", publicationAnnotation, gotPublications, published)
+	}
+	if got.Annotations["other"] != "value" {
+		t.Errorf("annotations = %v, want the other annotations to be kept", got.Annotations)
+	}
+
+	// 同じ状態ではEventを出さない
+	if err := recordPublication(ctx, c, recorder, ingress, published); err != nil {
+		t.Fatalf("recordPublication() error = %v", err)
+	}
+	if len(recorder.Events) != 0 {
+		t.Errorf("event = %q, want no event", <-recorder.Events)
+	}
+
+	// 確認し直しただけでもEventを出さない
+	rechecked := []hostPublication{{Host: "a.example.com", Tunnel: "default/tunnel", Live: true, DNSHealthy: true, DNSObservedTime: &metav1.Time{Time: time.Now()}}}
+	if err := recordPublication(ctx, c, recorder, ingress, rechecked); err != nil {
+		t.Fatalf("recordPublication() error = %v", err)
+	}
+	if len(recorder.Events) != 0 {
+		t.Errorf("event = %q, want no event", <-recorder.Events)
+	}
+
+	unhealthy := []hostPublication{{Host: "a.example.com", Tunnel: "default/tunnel", Live: true}}
+	if err := recordPublication(ctx, c, recorder, ingress, unhealthy); err != nil {
+		t.Fatalf("recordPublication() error = %v", err)
+	}
+	if got := <-recorder.Events; got != "Warning NotPublished a.example.com: the DNS record does not point to Cloudflare Tunnel default/tunnel" {
+		t.Errorf("event = %q", got)
+	}
+
+	if err := recordPublication(ctx, c, recorder, ingress, nil); err != nil {
+		t.Fatalf("recordPublication() error = %v", err)
+	}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(ingress), &got); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got.Annotations[publicationAnnotation]; ok {
+		t.Errorf("annotations = %v, want %s to be removed", got.Annotations, publicationAnnotation)
+	}
+}
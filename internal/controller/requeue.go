@@ -0,0 +1,25 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/domain"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// requeueOnRateLimit wraps the reconciler so that a request rate limited by the Cloudflare API is requeued
+// after the delay that the API asked for, instead of the exponential backoff of the workqueue.
+func requeueOnRateLimit(r reconcile.Reconciler) reconcile.Reconciler {
+	return reconcile.Func(func(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+		result, err := r.Reconcile(ctx, req)
+		if retryAfter, ok := domain.RetryAfter(err); ok {
+			log.FromContext(ctx).Info("rate limited by Cloudflare API, requeueing", "retryAfter", retryAfter, "error", err.Error())
+			// RequeueAfterが0だと再キューされないので、最低1秒待つ
+			return ctrl.Result{RequeueAfter: max(retryAfter, time.Second)}, nil
+		}
+		return result, err
+	})
+}
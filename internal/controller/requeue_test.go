@@ -0,0 +1,53 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/domain"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func Test_requeueOnRateLimit(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantResult ctrl.Result
+		wantErr    bool
+	}{
+		{
+			name:       "rate limited",
+			err:        fmt.Errorf("failed to update tunnel configs: %w", &domain.RateLimitError{RetryAfter: 30 * time.Second}),
+			wantResult: ctrl.Result{RequeueAfter: 30 * time.Second},
+		},
+		{
+			name:       "rate limited without delay",
+			err:        &domain.RateLimitError{},
+			wantResult: ctrl.Result{RequeueAfter: time.Second},
+		},
+		{
+			name:    "other errors",
+			err:     errors.New("failed"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := requeueOnRateLimit(reconcile.Func(func(context.Context, ctrl.Request) (ctrl.Result, error) {
+				return ctrl.Result{}, tt.err
+			}))
+
+			got, err := r.Reconcile(context.Background(), ctrl.Request{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Reconcile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.wantResult {
+				t.Errorf("Reconcile() = %v, want %v", got, tt.wantResult)
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/domain"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+const (
+	hostnameAnnotation = annotationPrefix + "hostname"
+	portAnnotation     = annotationPrefix + "port"
+	protocolAnnotation = annotationPrefix + "protocol"
+)
+
+// ServiceReconciler reconciles a Service object
+type ServiceReconciler struct {
+	client.Client
+	Scheme                  *runtime.Scheme
+	CloudflareTunnelManager CloudflareTunnelManager
+	Credentials             *CloudflareCredentialsCache
+	// MaxConcurrentReconciles is the number of resources reconciled in parallel. The default is 1.
+	MaxConcurrentReconciles int
+}
+
+// +kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;update;patch
+
+func (r *ServiceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	svc := &corev1.Service{}
+	if err := r.Get(ctx, req.NamespacedName, svc); err != nil {
+		if apierrors.IsNotFound(err) {
+			logger.Info("service resource not found")
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, fmt.Errorf("failed to get Service: %w", err)
+	}
+
+	if !svc.DeletionTimestamp.IsZero() {
+		if controllerutil.ContainsFinalizer(svc, finalizerName) {
+			if err := r.finalizeService(ctx, svc); err != nil {
+				return ctrl.Result{}, fmt.Errorf("failed to finalize Service: %w", err)
+			}
+
+			controllerutil.RemoveFinalizer(svc, finalizerName)
+			if err := r.Update(ctx, svc); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// hostname Annotationが外された場合は、最後に追加したトンネルからルールを消してFinalizerを外す
+	if svc.Annotations[hostnameAnnotation] == "" {
+		if err := releasePreviousTunnel(ctx, r.Client, r.CloudflareTunnelManager, r.Credentials, svc, ruleOwner("Service", svc), nil); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to remove rules from the previous Cloudflare Tunnel: %w", err)
+		}
+		if err := recordAppliedTunnel(ctx, r.Client, svc, nil); err != nil {
+			return ctrl.Result{}, err
+		}
+		if controllerutil.RemoveFinalizer(svc, finalizerName) {
+			if err := r.Update(ctx, svc); err != nil {
+				return ctrl.Result{}, fmt.Errorf("failed to remove finalizer from Service: %w", err)
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if !controllerutil.ContainsFinalizer(svc, finalizerName) {
+		controllerutil.AddFinalizer(svc, finalizerName)
+		if err := r.Update(ctx, svc); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to add finalizer to Service: %w", err)
+		}
+	}
+
+	cfTunnelName, err := detectCloudflareTunnelName(svc.Annotations)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to detect Cloudflare Tunnel name: %w", err)
+	}
+
+	cfTunnel, err := getCloudflareTunnel(ctx, r.Client, cfTunnelName)
+	if err != nil {
+		if errors.Is(err, ErrDefaultCloudflareTunnelNotExists) {
+			logger.Info("default Cloudflare Tunnel not exists")
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, fmt.Errorf("failed to get Cloudflare Tunnel: %w", err)
+	}
+
+	if !cfTunnel.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, fmt.Errorf("cloudflare tunnel is being deleted: %w", ErrCloudflareTunnelNotFound)
+	}
+
+	tunnelID := cfTunnel.Status.TunnelID
+	if tunnelID == "" {
+		return ctrl.Result{}, fmt.Errorf("tunnel ID is empty")
+	}
+
+	manager, zones, err := cloudflareTunnelManagerFor(ctx, r.Client, r.CloudflareTunnelManager, r.Credentials, cfTunnel)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to get Cloudflare Tunnel client: %w", err)
+	}
+
+	// 別のトンネルに移された場合は、前のトンネルからルールを消す
+	owner := ruleOwner("Service", svc)
+	if err := releasePreviousTunnel(ctx, r.Client, r.CloudflareTunnelManager, r.Credentials, svc, owner, &cfTunnel); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to remove rules from the previous Cloudflare Tunnel: %w", err)
+	}
+
+	// Ignore Annotationがついていたらエントリを追加しない
+	// 既に追加されていたら削除する
+	if checkToBeIgnored(svc.Annotations) {
+		if err := releaseTunnelRules(ctx, r.Client, manager, cfTunnel, zones, owner); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, recordAppliedTunnel(ctx, r.Client, svc, nil)
+	}
+
+	rule, err := serviceRule(*svc)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to build tunnel ingress rule: %w", err)
+	}
+
+	if err := applyTunnelRules(ctx, r.Client, manager, cfTunnel, zones, owner, []ingressRule{rule}); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, recordAppliedTunnel(ctx, r.Client, svc, &cfTunnel)
+}
+
+func (r *ServiceReconciler) finalizeService(ctx context.Context, svc *corev1.Service) error {
+	logger := log.FromContext(ctx)
+	cfTunnelName, err := detectCloudflareTunnelName(svc.Annotations)
+	if err != nil {
+		return fmt.Errorf("failed to detect Cloudflare Tunnel name: %w", err)
+	}
+
+	cfTunnel, err := getCloudflareTunnel(ctx, r.Client, cfTunnelName)
+	if err != nil {
+		if errors.Is(err, ErrDefaultCloudflareTunnelNotExists) {
+			logger.Info("default Cloudflare Tunnel not exists")
+			return nil
+		}
+		return fmt.Errorf("failed to get Cloudflare Tunnel: %w", err)
+	}
+
+	// CloudflareTunnelリソースの削除時にトンネルやレコードが削除されるので、何もせずに抜ける
+	if !cfTunnel.DeletionTimestamp.IsZero() {
+		logger.Info("cloudflare tunnel is being deleted, skip removing service from the tunnel")
+		return nil
+	}
+
+	tunnelID := cfTunnel.Status.TunnelID
+	if tunnelID == "" {
+		return fmt.Errorf("tunnel ID is empty")
+	}
+
+	manager, zones, err := cloudflareTunnelManagerFor(ctx, r.Client, r.CloudflareTunnelManager, r.Credentials, cfTunnel)
+	if err != nil {
+		return fmt.Errorf("failed to get Cloudflare Tunnel client: %w", err)
+	}
+
+	owner := ruleOwner("Service", svc)
+	if err := releasePreviousTunnel(ctx, r.Client, r.CloudflareTunnelManager, r.Credentials, svc, owner, &cfTunnel); err != nil {
+		return fmt.Errorf("failed to remove rules from the previous Cloudflare Tunnel: %w", err)
+	}
+
+	// 削除前にhostname Annotationが変わっていても、記録されたルールをすべて外す
+	return releaseTunnelRules(ctx, r.Client, manager, cfTunnel, zones, owner)
+}
+
+// serviceRule returns the tunnel ingress rule that sends the traffic for the hostname annotation to the Service.
+// The port annotation accepts a port name or number and defaults to the first port of the Service.
+func serviceRule(svc corev1.Service) (ingressRule, error) {
+	hostname := strings.TrimSpace(svc.Annotations[hostnameAnnotation])
+	if hostname == "" {
+		return ingressRule{}, fmt.Errorf("annotation %s is empty", hostnameAnnotation)
+	}
+
+	if len(svc.Spec.Ports) == 0 {
+		return ingressRule{}, fmt.Errorf("service %s/%s has no ports", svc.Namespace, svc.Name)
+	}
+	port := svc.Spec.Ports[0]
+	if v, ok := svc.Annotations[portAnnotation]; ok {
+		i := slices.IndexFunc(svc.Spec.Ports, func(p corev1.ServicePort) bool {
+			return p.Name == v || strconv.Itoa(int(p.Port)) == v
+		})
+		if i < 0 {
+			return ingressRule{}, fmt.Errorf("port %s not found in Service %s/%s", v, svc.Namespace, svc.Name)
+		}
+		port = svc.Spec.Ports[i]
+	}
+
+	protocol, err := detectOriginProtocol(svc.Annotations)
+	if err != nil {
+		return ingressRule{}, err
+	}
+	if protocol == "" {
+		protocol = defaultOriginProtocol(port)
+	}
+
+	return ingressRule{
+		Hostname: hostname,
+		Path:     "",
+		Service:  domain.OriginURL(protocol, fmt.Sprintf("%s.%s.svc", svc.Name, svc.Namespace), port.Port),
+	}, nil
+}
+
+// detectOriginProtocol returns the origin protocol set by the protocol annotation, or "" if it is not set.
+// Unix sockets are not allowed because they are not reachable through a Service.
+func detectOriginProtocol(annotations map[string]string) (domain.OriginProtocol, error) {
+	v, ok := annotations[protocolAnnotation]
+	if !ok {
+		return "", nil
+	}
+
+	protocol, err := domain.ParseOriginProtocol(v)
+	if err != nil {
+		return "", fmt.Errorf("invalid annotation value: %w", err)
+	}
+	if protocol.IsUnix() {
+		return "", fmt.Errorf("invalid annotation value: %w: unix sockets cannot be used with %s", domain.ErrUnsupportedOriginProtocol, protocolAnnotation)
+	}
+	return protocol, nil
+}
+
+// defaultOriginProtocol detects the protocol of the Service port from its number, name and appProtocol.
+func defaultOriginProtocol(port corev1.ServicePort) domain.OriginProtocol {
+	if port.Port == 443 || port.Name == "https" || ptr.Deref(port.AppProtocol, "") == "https" {
+		return domain.OriginProtocolHTTPS
+	}
+	return domain.OriginProtocolHTTP
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ServiceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// hostname Annotationがついている、もしくはFinalizerが残っているServiceだけを対象にする
+	exposed := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return obj.GetAnnotations()[hostnameAnnotation] != "" || controllerutil.ContainsFinalizer(obj, finalizerName)
+	})
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&corev1.Service{}, builder.WithPredicates(exposed)).
+		Named("service").
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
+		Complete(requeueOnRateLimit(r))
+}
@@ -0,0 +1,174 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/cloudflare/cloudflare-go"
+	cftv1beta1 "github.com/walnuts1018/cloudflare-tunnel-operator/api/v1beta1"
+	mock_controller "github.com/walnuts1018/cloudflare-tunnel-operator/internal/controller/mock"
+	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/domain"
+	"go.uber.org/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_serviceRule(t *testing.T) {
+	ports := []corev1.ServicePort{
+		{Name: "http", Port: 8080},
+		{Name: "https", Port: 8443},
+		{Name: "ssh", Port: 22},
+	}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		ports       []corev1.ServicePort
+		want        ingressRule
+		wantErr     bool
+	}{
+		{
+			name:        "first port",
+			annotations: map[string]string{hostnameAnnotation: "dashboard.walnuts.dev"},
+			ports:       ports,
+			want:        ingressRule{Hostname: "dashboard.walnuts.dev", Service: "http://dashboard.default.svc:8080"},
+		},
+		{
+			name:        "port name",
+			annotations: map[string]string{hostnameAnnotation: "dashboard.walnuts.dev", portAnnotation: "https"},
+			ports:       ports,
+			want:        ingressRule{Hostname: "dashboard.walnuts.dev", Service: "https://dashboard.default.svc:8443"},
+		},
+		{
+			name: "port number and protocol",
+			annotations: map[string]string{
+				hostnameAnnotation: "ssh.walnuts.dev",
+				portAnnotation:     "22",
+				protocolAnnotation: "SSH",
+			},
+			ports: ports,
+			want:  ingressRule{Hostname: "ssh.walnuts.dev", Service: "ssh://dashboard.default.svc:22"},
+		},
+		{
+			name:        "unknown port",
+			annotations: map[string]string{hostnameAnnotation: "dashboard.walnuts.dev", portAnnotation: "9090"},
+			ports:       ports,
+			wantErr:     true,
+		},
+		{
+			name:        "invalid protocol",
+			annotations: map[string]string{hostnameAnnotation: "dashboard.walnuts.dev", protocolAnnotation: "udp"},
+			ports:       ports,
+			wantErr:     true,
+		},
+		{
+			name:        "unix socket",
+			annotations: map[string]string{hostnameAnnotation: "dashboard.walnuts.dev", protocolAnnotation: "unix"},
+			ports:       ports,
+			wantErr:     true,
+		},
+		{
+			name:        "no ports",
+			annotations: map[string]string{hostnameAnnotation: "dashboard.walnuts.dev"},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:   "default",
+					Name:        "dashboard",
+					Annotations: tt.annotations,
+				},
+				Spec: corev1.ServiceSpec{Ports: tt.ports},
+			}
+			got, err := serviceRule(svc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("serviceRule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("serviceRule() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServiceReconciler_finalizeService(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	if err := cftv1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	cfTunnel := &cftv1beta1.CloudflareTunnel{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "tunnel"},
+		Spec: cftv1beta1.CloudflareTunnelSpec{
+			Settings: cftv1beta1.CloudflareTunnelSettings{CatchAllRule: "http_status:404"},
+		},
+		Status: cftv1beta1.CloudflareTunnelStatus{TunnelID: "finalize-service-id"},
+	}
+	rules, err := json.Marshal([]managedRule{
+		{Hostname: "old.walnuts.dev", Service: "http://web.default.svc:80", Owner: "Service/default/web"},
+		{Hostname: "api.walnuts.dev", Service: "http://api.default.svc:80", Owner: "Service/default/api"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "tunnel-managed-rules"},
+		Data:       map[string]string{managedRulesKey: string(rules)},
+	}
+	// 削除前にhostname Annotationが変えられていても、記録されたルールを外す
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "web",
+			Annotations: map[string]string{
+				cfTunnelAnnotation: "default/tunnel",
+				hostnameAnnotation: "new.walnuts.dev",
+			},
+		},
+	}
+
+	m := mock_controller.NewMockCloudflareTunnelManager(gomock.NewController(t))
+	m.EXPECT().GetTunnelConfiguration(gomock.Any(), "finalize-service-id").Return(domain.TunnelConfiguration{
+		Ingress: []cloudflare.UnvalidatedIngressRule{
+			{Hostname: "api.walnuts.dev", Service: "http://api.default.svc:80"},
+			{Hostname: "old.walnuts.dev", Service: "http://web.default.svc:80"},
+			{Service: "http_status:404"},
+		},
+	}, nil)
+	m.EXPECT().UpdateTunnelConfiguration(gomock.Any(), "finalize-service-id", gomock.Any()).DoAndReturn(func(_ context.Context, _ string, config domain.TunnelConfiguration) (domain.TunnelConfiguration, error) {
+		if len(config.Ingress) != 2 || config.Ingress[0].Hostname != "api.walnuts.dev" {
+			t.Errorf("UpdateTunnelConfiguration() ingress = %+v, want only api.walnuts.dev and the catch-all rule", config.Ingress)
+		}
+		return config, nil
+	})
+	m.EXPECT().ResolveZone(gomock.Any(), "old.walnuts.dev", gomock.Any()).Return(domain.Zone{ID: "zone-id"}, nil)
+	m.EXPECT().GetDNS(gomock.Any(), "zone-id", "finalize-service-id", "old.walnuts.dev").Return(domain.DNSRecord{
+		ID:      "record-id",
+		Type:    "CNAME",
+		Content: "finalize-service-id.cfargotunnel.com",
+		Proxied: ptr.To(true),
+	}, nil)
+	m.EXPECT().DeleteDNS(gomock.Any(), "zone-id", "record-id").Return(nil)
+	t.Cleanup(func() { forgetTunnelConfigWriter("finalize-service-id") })
+
+	r := &ServiceReconciler{
+		Client:                  fake.NewClientBuilder().WithScheme(scheme).WithObjects(cfTunnel, record, svc).Build(),
+		CloudflareTunnelManager: m,
+	}
+	if err := r.finalizeService(ctx, svc); err != nil {
+		t.Fatalf("finalizeService() error = %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	cftv1beta1 "github.com/walnuts1018/cloudflare-tunnel-operator/api/v1beta1"
+	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/domain"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// rotateTokenAnnotation requests a rotation of the tunnel secret. It is removed after the rotation.
+	rotateTokenAnnotation = annotationPrefix + "rotate-token"
+	// tokenChecksumAnnotation is set on the cloudflared Pods to the checksum of the tunnel token, so that they are restarted when it changes.
+	tokenChecksumAnnotation = annotationPrefix + "token-checksum"
+)
+
+// tokenRotationDue reports whether the tunnel secret should be rotated now, and why.
+func tokenRotationDue(cfTunnel cftv1beta1.CloudflareTunnel, now time.Time) (string, bool) {
+	if pending := cfTunnel.Status.PendingTokenRotation; pending != nil {
+		return pending.Reason, true
+	}
+	if _, ok := cfTunnel.Annotations[rotateTokenAnnotation]; ok {
+		return "requested by the " + rotateTokenAnnotation + " annotation", true
+	}
+	if untilTokenRotation(cfTunnel, now) == 0 {
+		return "scheduled by spec.tokenRotation", true
+	}
+	return "", false
+}
+
+// untilTokenRotation returns the time until the next scheduled rotation, which is zero if it is overdue.
+// It returns a negative value if the rotation is not scheduled.
+func untilTokenRotation(cfTunnel cftv1beta1.CloudflareTunnel, now time.Time) time.Duration {
+	if cfTunnel.Spec.TokenRotation == nil || cfTunnel.Spec.TokenRotation.Interval.Duration <= 0 {
+		return -1
+	}
+
+	// 一度もローテーションしていない場合は、作成時のトークンから数える
+	last := cfTunnel.CreationTimestamp.Time
+	if cfTunnel.Status.LastTokenRotationTime != nil {
+		last = cfTunnel.Status.LastTokenRotationTime.Time
+	}
+	return max(last.Add(cfTunnel.Spec.TokenRotation.Interval.Duration).Sub(now), 0)
+}
+
+// rotateToken rotates the secret of the tunnel and returns the new token.
+// The rotation is recorded in status.pendingTokenRotation with the checksum of the current token before the secret is rotated,
+// so that a retry after a failure does not rotate the secret again once the token in Cloudflare has changed.
+// The token has to be written to the Secret, and the cloudflared Pods are restarted by the change of tokenChecksumAnnotation.
+func (r *CloudflareTunnelReconciler) rotateToken(ctx context.Context, manager CloudflareTunnelManager, cfTunnel *cftv1beta1.CloudflareTunnel, reason string) (domain.CloudflareTunnelToken, error) {
+	current, err := manager.GetTunnelToken(ctx, cfTunnel.Status.TunnelID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get tunnel token: %w", err)
+	}
+
+	if cfTunnel.Status.PendingTokenRotation == nil {
+		cfTunnel.Status.PendingTokenRotation = &cftv1beta1.PendingTokenRotation{
+			RequestedTime:         metav1.Now(),
+			PreviousTokenChecksum: tokenChecksum(current),
+			Reason:                reason,
+		}
+		if err := r.Status().Update(ctx, cfTunnel); err != nil {
+			return "", fmt.Errorf("failed to record token rotation: %w", err)
+		}
+	}
+
+	// ローテーションを記録してから消すので、ここで失敗してもローテーションは続けられる
+	if err := r.clearRotateTokenAnnotation(ctx, cfTunnel); err != nil {
+		return "", err
+	}
+
+	// 前回のReconcileでローテーション済みの場合は、もう一度ローテーションしない
+	if tokenChecksum(current) != cfTunnel.Status.PendingTokenRotation.PreviousTokenChecksum {
+		return current, nil
+	}
+
+	if err := manager.RotateTunnelSecret(ctx, cfTunnel.Status.TunnelID); err != nil {
+		return "", fmt.Errorf("failed to rotate tunnel secret: %w", err)
+	}
+
+	token, err := manager.GetTunnelToken(ctx, cfTunnel.Status.TunnelID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get tunnel token: %w", err)
+	}
+	return token, nil
+}
+
+// completeTokenRotation records the rotation in the status after the new token has been written to the Secret.
+func (r *CloudflareTunnelReconciler) completeTokenRotation(ctx context.Context, cfTunnel *cftv1beta1.CloudflareTunnel) {
+	pending := cfTunnel.Status.PendingTokenRotation
+	if pending == nil {
+		return
+	}
+
+	log.FromContext(ctx).Info("Rotated tunnel token.", "tunnelID", cfTunnel.Status.TunnelID, "reason", pending.Reason)
+	r.Recorder.Eventf(cfTunnel, corev1.EventTypeNormal, "TokenRotated",
+		"Rotated the token of Cloudflare Tunnel %s (%s). The cloudflared Pods are restarted with the new token.", cfTunnel.Status.TunnelID, pending.Reason)
+	cfTunnel.Status.LastTokenRotationTime = ptr.To(metav1.Now())
+	cfTunnel.Status.PendingTokenRotation = nil
+}
+
+// clearRotateTokenAnnotation removes rotateTokenAnnotation after the rotation has been recorded in status.pendingTokenRotation.
+func (r *CloudflareTunnelReconciler) clearRotateTokenAnnotation(ctx context.Context, cfTunnel *cftv1beta1.CloudflareTunnel) error {
+	if _, ok := cfTunnel.Annotations[rotateTokenAnnotation]; !ok {
+		return nil
+	}
+
+	patch := client.MergeFrom(cfTunnel.DeepCopy())
+	delete(cfTunnel.Annotations, rotateTokenAnnotation)
+	if err := r.Patch(ctx, cfTunnel, patch); err != nil {
+		return fmt.Errorf("failed to remove %s annotation: %w", rotateTokenAnnotation, err)
+	}
+	return nil
+}
+
+func tokenChecksum(token domain.CloudflareTunnelToken) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
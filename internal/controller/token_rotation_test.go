@@ -0,0 +1,206 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cftv1beta1 "github.com/walnuts1018/cloudflare-tunnel-operator/api/v1beta1"
+	mock_controller "github.com/walnuts1018/cloudflare-tunnel-operator/internal/controller/mock"
+	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/domain"
+	"go.uber.org/mock/gomock"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_tokenRotationDue(t *testing.T) {
+	created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	rotation := &cftv1beta1.TokenRotation{Interval: metav1.Duration{Duration: 24 * time.Hour}}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		rotation    *cftv1beta1.TokenRotation
+		lastRotated *metav1.Time
+		now         time.Time
+		wantDue     bool
+		wantUntil   time.Duration
+	}{
+		{
+			name:      "not scheduled",
+			now:       created.Add(48 * time.Hour),
+			wantDue:   false,
+			wantUntil: -1,
+		},
+		{
+			name:        "requested by the annotation",
+			annotations: map[string]string{rotateTokenAnnotation: "true"},
+			now:         created,
+			wantDue:     true,
+			wantUntil:   -1,
+		},
+		{
+			name:      "counted from the creation",
+			rotation:  rotation,
+			now:       created.Add(6 * time.Hour),
+			wantDue:   false,
+			wantUntil: 18 * time.Hour,
+		},
+		{
+			name:      "overdue",
+			rotation:  rotation,
+			now:       created.Add(25 * time.Hour),
+			wantDue:   true,
+			wantUntil: 0,
+		},
+		{
+			name:        "counted from the last rotation",
+			rotation:    rotation,
+			lastRotated: &metav1.Time{Time: created.Add(24 * time.Hour)},
+			now:         created.Add(25 * time.Hour),
+			wantDue:     false,
+			wantUntil:   23 * time.Hour,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfTunnel := cftv1beta1.CloudflareTunnel{
+				ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.Time{Time: created}, Annotations: tt.annotations},
+				Spec:       cftv1beta1.CloudflareTunnelSpec{TokenRotation: tt.rotation},
+				Status:     cftv1beta1.CloudflareTunnelStatus{LastTokenRotationTime: tt.lastRotated},
+			}
+
+			if _, due := tokenRotationDue(cfTunnel, tt.now); due != tt.wantDue {
+				t.Errorf("tokenRotationDue() = %v, want %v", due, tt.wantDue)
+			}
+			if until := untilTokenRotation(cfTunnel, tt.now); until != tt.wantUntil {
+				t.Errorf("untilTokenRotation() = %v, want %v", until, tt.wantUntil)
+			}
+		})
+	}
+}
+
+func TestCloudflareTunnelReconciler_rotateToken(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	if err := cftv1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		pending     *cftv1beta1.PendingTokenRotation
+		remoteToken domain.CloudflareTunnelToken
+		wantRotate  bool
+		wantToken   domain.CloudflareTunnelToken
+	}{
+		{
+			name:        "rotate",
+			remoteToken: "old-token",
+			wantRotate:  true,
+			wantToken:   "new-token",
+		},
+		{
+			name: "retry before the rotation",
+			pending: &cftv1beta1.PendingTokenRotation{
+				PreviousTokenChecksum: tokenChecksum("old-token"),
+				Reason:                "requested by the annotation",
+			},
+			remoteToken: "old-token",
+			wantRotate:  true,
+			wantToken:   "new-token",
+		},
+		{
+			name: "retry after the rotation",
+			pending: &cftv1beta1.PendingTokenRotation{
+				PreviousTokenChecksum: tokenChecksum("old-token"),
+				Reason:                "requested by the annotation",
+			},
+			remoteToken: "new-token",
+			wantRotate:  false,
+			wantToken:   "new-token",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := mock_controller.NewMockCloudflareTunnelManager(gomock.NewController(t))
+			calls := []any{
+				m.EXPECT().GetTunnelToken(ctx, "test-id").Return(tt.remoteToken, nil),
+			}
+			if tt.wantRotate {
+				calls = append(calls,
+					m.EXPECT().RotateTunnelSecret(ctx, "test-id").Return(nil),
+					m.EXPECT().GetTunnelToken(ctx, "test-id").Return(domain.CloudflareTunnelToken("new-token"), nil),
+				)
+			}
+			gomock.InOrder(calls...)
+
+			cfTunnel := &cftv1beta1.CloudflareTunnel{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "tunnel",
+					Namespace:   "default",
+					Annotations: map[string]string{rotateTokenAnnotation: "true", "other": "value"},
+				},
+				Status: cftv1beta1.CloudflareTunnelStatus{TunnelID: "test-id", PendingTokenRotation: tt.pending},
+			}
+			r := &CloudflareTunnelReconciler{
+				Client:   fake.NewClientBuilder().WithScheme(scheme).WithObjects(cfTunnel).WithStatusSubresource(cfTunnel).Build(),
+				Recorder: record.NewFakeRecorder(10),
+			}
+
+			reason, _ := tokenRotationDue(*cfTunnel, time.Now())
+			token, err := r.rotateToken(ctx, m, cfTunnel, reason)
+			if err != nil {
+				t.Fatalf("rotateToken() error = %v", err)
+			}
+			if token != tt.wantToken {
+				t.Errorf("rotateToken() = %v, want %v", token, tt.wantToken)
+			}
+
+			var got cftv1beta1.CloudflareTunnel
+			if err := r.Get(ctx, client.ObjectKeyFromObject(cfTunnel), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Status.PendingTokenRotation == nil {
+				t.Fatal("PendingTokenRotation is not recorded")
+			}
+			if got.Status.PendingTokenRotation.PreviousTokenChecksum != tokenChecksum("old-token") {
+				t.Errorf("PreviousTokenChecksum = %v, want the checksum of the token before the rotation", got.Status.PendingTokenRotation.PreviousTokenChecksum)
+			}
+			if _, ok := got.Annotations[rotateTokenAnnotation]; ok {
+				t.Errorf("annotations = %v, want %s to be removed", got.Annotations, rotateTokenAnnotation)
+			}
+			if got.Annotations["other"] != "value" {
+				t.Errorf("annotations = %v, want the other annotations to be kept", got.Annotations)
+			}
+		})
+	}
+}
+
+func TestCloudflareTunnelReconciler_completeTokenRotation(t *testing.T) {
+	cfTunnel := &cftv1beta1.CloudflareTunnel{
+		Status: cftv1beta1.CloudflareTunnelStatus{
+			TunnelID: "test-id",
+			PendingTokenRotation: &cftv1beta1.PendingTokenRotation{
+				PreviousTokenChecksum: tokenChecksum("old-token"),
+				Reason:                "requested by the annotation",
+			},
+		},
+	}
+	r := &CloudflareTunnelReconciler{Recorder: record.NewFakeRecorder(10)}
+
+	r.completeTokenRotation(context.Background(), cfTunnel)
+	if cfTunnel.Status.PendingTokenRotation != nil {
+		t.Errorf("PendingTokenRotation = %v, want nil", cfTunnel.Status.PendingTokenRotation)
+	}
+	if cfTunnel.Status.LastTokenRotationTime == nil {
+		t.Error("LastTokenRotationTime is not set")
+	}
+	if tokenChecksum("new-token") == tokenChecksum("old-token") {
+		t.Error("tokenChecksum() does not change with the token")
+	}
+}
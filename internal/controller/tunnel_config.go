@@ -0,0 +1,276 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/cloudflare/cloudflare-go"
+	cftv1beta1 "github.com/walnuts1018/cloudflare-tunnel-operator/api/v1beta1"
+	"github.com/walnuts1018/cloudflare-tunnel-operator/internal/consts"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ErrRuleConflict is returned when a rule of the same hostname and path is already owned by another resource.
+var ErrRuleConflict = errors.New("rule is owned by another resource")
+
+// applyTunnelRules adds the rules to the tunnel configuration and creates DNS records for their hostnames.
+// The rules are recorded as owned by owner, so that only they are rewritten afterwards.
+// Rules that owner added before but are not in rules are removed with their DNS records.
+// Rules owned by another resource are not overwritten, and are reported by an error wrapping ErrRuleConflict
+// after the other rules are applied.
+func applyTunnelRules(
+	ctx context.Context,
+	c client.Client,
+	manager CloudflareTunnelManager,
+	cfTunnel cftv1beta1.CloudflareTunnel,
+	zones []string,
+	owner string,
+	rules []ingressRule,
+) error {
+	var (
+		previous []ingressRule
+		conflict error
+	)
+	remaining, err := tunnelConfigWriterFor(cfTunnel.Status.TunnelID).submit(ctx, c, manager, cfTunnel, func(owned managedRules) []ruleKey {
+		var stale []ruleKey
+		stale, conflict = replaceOwnerRules(owned, owner, rules)
+		// バッチ内で再適用されることがあるので、毎回作り直す
+		previous = previous[:0]
+		for _, key := range stale {
+			previous = append(previous, ingressRule{Hostname: key.Hostname, Path: key.Path})
+		}
+		return stale
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update Cloudflare Tunnel config: %w", err)
+	}
+
+	// 他のリソースが持っているルールのホスト名は、そのリソースがDNSレコードを管理する
+	applied := slices.DeleteFunc(slices.Clone(rules), func(rule ingressRule) bool {
+		return remaining[rule.key()].Owner != owner
+	})
+	for _, hostname := range hostnames(applied) {
+		if err := appendDNSRecord(ctx, manager, cfTunnel.Status.TunnelID, hostname, zones); err != nil {
+			return fmt.Errorf("failed to update Cloudflare DNS Record: %w", err)
+		}
+	}
+
+	// 使われなくなったホスト名のDNSレコードを消す
+	for _, hostname := range hostnames(previous) {
+		if slices.Contains(remaining.hostnames(), hostname) {
+			continue
+		}
+		if err := removeDNSRecord(ctx, manager, cfTunnel.Status.TunnelID, hostname, zones); err != nil {
+			return fmt.Errorf("failed to delete Cloudflare DNS Record: %w", err)
+		}
+	}
+	return conflict
+}
+
+// removeTunnelRules removes the rules owned by owner from the tunnel configuration,
+// and deletes DNS records for the hostnames that are no longer used by any rule of the operator.
+func removeTunnelRules(
+	ctx context.Context,
+	c client.Client,
+	manager CloudflareTunnelManager,
+	cfTunnel cftv1beta1.CloudflareTunnel,
+	zones []string,
+	owner string,
+	rules []ingressRule,
+) error {
+	var removed []ingressRule
+	remaining, err := tunnelConfigWriterFor(cfTunnel.Status.TunnelID).submit(ctx, c, manager, cfTunnel, func(owned managedRules) []ruleKey {
+		keys := removeOwnerRules(owned, owner, rules)
+		removed = removed[:0]
+		for _, key := range keys {
+			removed = append(removed, ingressRule{Hostname: key.Hostname, Path: key.Path})
+		}
+		return keys
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove Cloudflare Tunnel config: %w", err)
+	}
+
+	for _, hostname := range hostnames(removed) {
+		// 同じホスト名の別のルールが残っている場合はDNSレコードを消さない
+		if slices.Contains(remaining.hostnames(), hostname) {
+			continue
+		}
+		if err := removeDNSRecord(ctx, manager, cfTunnel.Status.TunnelID, hostname, zones); err != nil {
+			return fmt.Errorf("failed to delete Cloudflare DNS Record: %w", err)
+		}
+	}
+	return nil
+}
+
+// getCloudflareTunnel returns the CloudflareTunnel selected by the cloudflare-tunnel annotation,
+// or the default CloudflareTunnel if cfTunnelName is empty.
+func getCloudflareTunnel(ctx context.Context, c client.Reader, cfTunnelName types.NamespacedName) (cftv1beta1.CloudflareTunnel, error) {
+	var cfTunnel cftv1beta1.CloudflareTunnel
+
+	// use default Cloudflare Tunnel
+	if cfTunnelName.Name == "" || cfTunnelName.Namespace == "" {
+		var defaultCFTunnels cftv1beta1.CloudflareTunnelList
+		if err := c.List(ctx, &defaultCFTunnels, &client.ListOptions{
+			Limit:         1,
+			LabelSelector: labels.SelectorFromSet(map[string]string{consts.DefaultLabelKey: "true"}),
+		}); err != nil {
+			return cftv1beta1.CloudflareTunnel{}, fmt.Errorf("failed to list default Cloudflare Tunnel: %w", err)
+		}
+
+		if len(defaultCFTunnels.Items) == 0 {
+			return cftv1beta1.CloudflareTunnel{}, ErrDefaultCloudflareTunnelNotExists
+		}
+
+		cfTunnel = defaultCFTunnels.Items[0]
+	} else {
+		if err := c.Get(ctx, cfTunnelName, &cfTunnel); err != nil {
+			if apierrors.IsNotFound(err) {
+				return cftv1beta1.CloudflareTunnel{}, ErrCloudflareTunnelNotFound
+			}
+
+			return cftv1beta1.CloudflareTunnel{}, fmt.Errorf("failed to get Cloudflare Tunnel: %w", err)
+		}
+	}
+
+	return cfTunnel, nil
+}
+
+// replaceOwnerRules replaces the rules owned by owner in the ownership record with newRules,
+// and returns the keys of the rules that owner added before but are not in newRules.
+// Rules of newRules that are owned by another resource are left to it, and returned as an error wrapping ErrRuleConflict.
+func replaceOwnerRules(owned managedRules, owner string, newRules []ingressRule) ([]ruleKey, error) {
+	// 前回追加したルールのうち、今回のルールに含まれないものは消す
+	var stale []ruleKey
+	for key, rule := range owned {
+		if rule.Owner == owner {
+			delete(owned, key)
+			stale = append(stale, key)
+		}
+	}
+
+	var errs []error
+	for _, rule := range newRules {
+		// 先に追加したリソースのルールを上書きしない
+		if current, ok := owned[rule.key()]; ok && current.Owner != owner {
+			target := rule.Hostname
+			if rule.Path != "" {
+				target += " (path " + rule.Path + ")"
+			}
+			errs = append(errs, fmt.Errorf("%w: %s is owned by %s", ErrRuleConflict, target, current.Owner))
+			continue
+		}
+		owned[rule.key()] = managedRule{
+			Hostname:      rule.Hostname,
+			Path:          rule.Path,
+			Service:       rule.Service,
+			OriginRequest: rule.OriginRequest,
+			Owner:         owner,
+		}
+	}
+
+	return slices.DeleteFunc(stale, func(key ruleKey) bool {
+		_, ok := owned[key]
+		return ok
+	}), errors.Join(errs...)
+}
+
+// removeOwnerRules removes staleRules owned by owner from the ownership record and returns the keys of the removed rules.
+// Rules owned by others are left as they are.
+func removeOwnerRules(owned managedRules, owner string, staleRules []ingressRule) []ruleKey {
+	var removed []ruleKey
+	for _, rule := range staleRules {
+		if r, ok := owned[rule.key()]; ok && r.Owner == owner {
+			delete(owned, rule.key())
+			removed = append(removed, rule.key())
+		}
+	}
+	return removed
+}
+
+// buildIngressRules merges the owned rules into the live rules.
+// Owned rules are rebuilt with the current tunnel settings, and the other rules are passed through as they are,
+// or pruned if the tunnel is exclusive. The catch-all rule is always last.
+func buildIngressRules(live []cloudflare.UnvalidatedIngressRule, owned managedRules, tunnelSettings cftv1beta1.CloudflareTunnelSettings) []cloudflare.UnvalidatedIngressRule {
+	rules := make(map[ruleKey]cloudflare.UnvalidatedIngressRule, len(live)+len(owned))
+	if !tunnelSettings.Exclusive {
+		for _, rule := range live {
+			rules[ruleKey{Hostname: rule.Hostname, Path: rule.Path}] = rule
+		}
+	}
+
+	for key := range owned {
+		rules[key] = owned.toIngressRule(key, tunnelSettings)
+	}
+
+	rules[ruleKey{}] = cloudflare.UnvalidatedIngressRule{
+		Hostname:      "",
+		Path:          "",
+		Service:       tunnelSettings.CatchAllRule,
+		OriginRequest: nil,
+	}
+
+	return slices.SortedFunc(maps.Values(rules), compareRules)
+}
+
+func appendDNSRecord(ctx context.Context, manager CloudflareTunnelManager, tunnelID string, hostname string, zones []string) error {
+	// IngressとHTTPRouteが同じホスト名を共有するので、Reconciler間で共有するロックを使う
+	w := tunnelConfigWriterFor(tunnelID)
+	w.dnsMu.Lock()
+	defer w.dnsMu.Unlock()
+
+	zone, err := manager.ResolveZone(ctx, hostname, zones)
+	if err != nil {
+		return fmt.Errorf("failed to resolve zone: %w", err)
+	}
+
+	record, err := manager.GetDNS(ctx, zone.ID, tunnelID, hostname)
+	if err != nil {
+		return fmt.Errorf("failed to get DNS record: %v", err)
+	}
+
+	if record.ID == "" {
+		if err := manager.AddDNS(ctx, zone.ID, tunnelID, hostname); err != nil {
+			return fmt.Errorf("failed to add DNS record: %v", err)
+		}
+		return nil
+	} else if !record.Healthy(tunnelID) {
+		if err := manager.UpdateDNS(ctx, zone.ID, tunnelID, hostname, record); err != nil {
+			return fmt.Errorf("failed to update DNS record: %v", err)
+		}
+	}
+	return nil
+}
+
+func removeDNSRecord(ctx context.Context, manager CloudflareTunnelManager, tunnelID string, hostname string, zones []string) error {
+	// IngressとHTTPRouteが同じホスト名を共有するので、Reconciler間で共有するロックを使う
+	w := tunnelConfigWriterFor(tunnelID)
+	w.dnsMu.Lock()
+	defer w.dnsMu.Unlock()
+
+	zone, err := manager.ResolveZone(ctx, hostname, zones)
+	if err != nil {
+		return fmt.Errorf("failed to resolve zone: %w", err)
+	}
+
+	record, err := manager.GetDNS(ctx, zone.ID, tunnelID, hostname)
+	if err != nil {
+		return fmt.Errorf("failed to get DNS record: %v", err)
+	}
+
+	// 別のトンネルに向いているレコードは、そのトンネルのものなので消さない
+	if record.ID == "" || !record.PointsTo(tunnelID) {
+		return nil
+	} else {
+		if err := manager.DeleteDNS(ctx, zone.ID, record.ID); err != nil {
+			return fmt.Errorf("failed to delete DNS record: %v", err)
+		}
+	}
+	return nil
+}
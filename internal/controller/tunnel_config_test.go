@@ -0,0 +1,490 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/stretchr/testify/assert"
+	cftv1beta1 "github.com/walnuts1018/cloudflare-tunnel-operator/api/v1beta1"
+	mock_controller "github.com/walnuts1018/cloudflare-tunnel-operator/internal/controller/mock"
+	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/domain"
+	"go.uber.org/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_replaceOwnerRules(t *testing.T) {
+	ctx := context.Background()
+	type state struct {
+		Ingress []cloudflare.UnvalidatedIngressRule
+	}
+
+	type args struct {
+		tunnelID string
+		owned    managedRules
+		rules    []ingressRule
+	}
+	tests := []struct {
+		name      string
+		state     state
+		args      args
+		wantState state
+	}{
+		{
+			name: "normal",
+			state: state{
+				Ingress: []cloudflare.UnvalidatedIngressRule{
+					{
+						Hostname: "example1.walnuts.dev",
+						Service:  "https://example1:80",
+					},
+					{
+						Hostname: "example2.walnuts.dev",
+						Service:  "https://example2:80",
+					},
+					{
+						Hostname: "",
+						Service:  "CatchAll",
+					},
+				},
+			},
+			args: args{
+				tunnelID: "test",
+				rules: []ingressRule{
+					{
+						Hostname: "example3.walnuts.dev",
+						Service:  "https://192.168.0.1:443",
+					},
+				},
+			},
+			wantState: state{
+				Ingress: []cloudflare.UnvalidatedIngressRule{
+					{
+						Hostname: "example1.walnuts.dev",
+						Service:  "https://example1:80",
+					},
+					{
+						Hostname: "example2.walnuts.dev",
+						Service:  "https://example2:80",
+					},
+					{
+						Hostname: "example3.walnuts.dev",
+						Service:  "https://192.168.0.1:443",
+					},
+					{
+						Hostname: "",
+						Service:  "CatchAll",
+					},
+				},
+			},
+		},
+		{
+			name: "paths on a shared hostname",
+			state: state{
+				Ingress: []cloudflare.UnvalidatedIngressRule{
+					{
+						Hostname: "example1.walnuts.dev",
+						Path:     `^/api(/.*)?$`,
+						Service:  "http://api.default.svc:80",
+					},
+					{
+						Hostname: "*.walnuts.dev",
+						Service:  "http://wildcard.default.svc:80",
+					},
+					{
+						Hostname: "",
+						Service:  "CatchAll",
+					},
+				},
+			},
+			args: args{
+				tunnelID: "test",
+				rules: []ingressRule{
+					{
+						Hostname: "example1.walnuts.dev",
+						Path:     "",
+						Service:  "http://web.default.svc:80",
+					},
+					{
+						Hostname: "example1.walnuts.dev",
+						Path:     `^/api/v1(/.*)?$`,
+						Service:  "http://api-v1.default.svc:80",
+					},
+					{
+						Hostname: "example1.walnuts.dev",
+						Path:     `^/api$`,
+						Service:  "http://api-root.default.svc:80",
+					},
+				},
+			},
+			wantState: state{
+				Ingress: []cloudflare.UnvalidatedIngressRule{
+					{
+						Hostname: "example1.walnuts.dev",
+						Path:     `^/api/v1(/.*)?$`,
+						Service:  "http://api-v1.default.svc:80",
+					},
+					{
+						Hostname: "example1.walnuts.dev",
+						Path:     `^/api$`,
+						Service:  "http://api-root.default.svc:80",
+					},
+					{
+						Hostname: "example1.walnuts.dev",
+						Path:     `^/api(/.*)?$`,
+						Service:  "http://api.default.svc:80",
+					},
+					{
+						Hostname: "example1.walnuts.dev",
+						Path:     "",
+						Service:  "http://web.default.svc:80",
+					},
+					{
+						Hostname: "*.walnuts.dev",
+						Service:  "http://wildcard.default.svc:80",
+					},
+					{
+						Hostname: "",
+						Service:  "CatchAll",
+					},
+				},
+			},
+		},
+		{
+			name: "rules removed from the owner",
+			state: state{
+				Ingress: []cloudflare.UnvalidatedIngressRule{
+					{
+						Hostname: "example1.walnuts.dev",
+						Service:  "https://192.168.0.1:443",
+					},
+					{
+						Hostname: "example2.walnuts.dev",
+						Service:  "https://192.168.0.1:443",
+					},
+					{
+						Hostname: "",
+						Service:  "CatchAll",
+					},
+				},
+			},
+			args: args{
+				tunnelID: "test",
+				owned: managedRules{
+					{Hostname: "example1.walnuts.dev"}: {Hostname: "example1.walnuts.dev", Service: "https://192.168.0.1:443", Owner: "Ingress/default/test"},
+					{Hostname: "example2.walnuts.dev"}: {Hostname: "example2.walnuts.dev", Service: "https://192.168.0.1:443", Owner: "Ingress/default/test"},
+				},
+				rules: []ingressRule{
+					{
+						Hostname: "example2.walnuts.dev",
+						Service:  "https://192.168.0.1:443",
+					},
+				},
+			},
+			wantState: state{
+				Ingress: []cloudflare.UnvalidatedIngressRule{
+					{
+						Hostname: "example2.walnuts.dev",
+						Service:  "https://192.168.0.1:443",
+					},
+					{
+						Hostname: "",
+						Service:  "CatchAll",
+					},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gomockctrl := gomock.NewController(t)
+
+			mockCloudflareTunnelManager := mock_controller.NewMockCloudflareTunnelManager(gomockctrl)
+			mockCloudflareTunnelManager.EXPECT().GetTunnelConfiguration(gomock.Any(), tt.args.tunnelID).Return(domain.TunnelConfiguration{
+				Ingress: tt.state.Ingress,
+			}, nil)
+			mockCloudflareTunnelManager.EXPECT().UpdateTunnelConfiguration(gomock.Any(), tt.args.tunnelID, gomock.Any()).DoAndReturn(
+				func(ctx context.Context, tunnelID string, config domain.TunnelConfiguration) (domain.TunnelConfiguration, error) {
+					assert.Len(t, config.Ingress, len(tt.wantState.Ingress))
+					for i, rule := range config.Ingress {
+						assert.Equal(t, tt.wantState.Ingress[i].Hostname, rule.Hostname)
+						assert.Equal(t, tt.wantState.Ingress[i].Path, rule.Path)
+						assert.Equal(t, tt.wantState.Ingress[i].Service, rule.Service)
+					}
+					return config, nil
+				},
+			)
+
+			c, cfTunnel, w := newTestTunnelConfigWriter(t, tt.args.tunnelID, tt.args.owned)
+
+			owned, err := w.submit(ctx, c, mockCloudflareTunnelManager, cfTunnel, func(owned managedRules) []ruleKey {
+				stale, _ := replaceOwnerRules(owned, "Ingress/default/test", tt.args.rules)
+				return stale
+			})
+			if err != nil {
+				t.Fatalf("submit() error = %v", err)
+			}
+			assert.Len(t, owned, len(tt.args.rules))
+			for _, rule := range tt.args.rules {
+				assert.Equal(t, "Ingress/default/test", owned[rule.key()].Owner)
+			}
+		})
+	}
+}
+
+func Test_buildIngressRules(t *testing.T) {
+	foreign := cloudflare.UnvalidatedIngressRule{
+		Hostname: "dashboard.walnuts.dev",
+		Service:  "http://dashboard:80",
+		OriginRequest: &cloudflare.OriginRequestConfig{
+			NoTLSVerify: ptr.To(true),
+		},
+	}
+	live := []cloudflare.UnvalidatedIngressRule{
+		foreign,
+		{
+			Hostname: "web.walnuts.dev",
+			Service:  "http://web:80",
+		},
+		{
+			Service: "http_status:404",
+		},
+	}
+	owned := managedRules{
+		{Hostname: "web.walnuts.dev"}: {
+			Hostname:      "web.walnuts.dev",
+			Service:       "http://web:80",
+			OriginRequest: &cftv1beta1.OriginRequest{ConnectTimeoutSeconds: ptr.To(int32(120))},
+			Owner:         "Ingress/default/web",
+		},
+	}
+
+	t.Run("foreign rules are passed through", func(t *testing.T) {
+		got := buildIngressRules(live, owned, cftv1beta1.CloudflareTunnelSettings{CatchAllRule: "http_status:404"})
+		assert.Len(t, got, 3)
+		assert.Equal(t, foreign, got[0])
+		assert.Equal(t, "web.walnuts.dev", got[1].Hostname)
+		assert.Equal(t, 120*time.Second, got[1].OriginRequest.ConnectTimeout.Duration)
+		assert.Equal(t, "http_status:404", got[2].Service)
+	})
+
+	t.Run("exclusive", func(t *testing.T) {
+		got := buildIngressRules(live, owned, cftv1beta1.CloudflareTunnelSettings{CatchAllRule: "http_status:404", Exclusive: true})
+		assert.Len(t, got, 2)
+		assert.Equal(t, "web.walnuts.dev", got[0].Hostname)
+		assert.Equal(t, "http_status:404", got[1].Service)
+	})
+}
+
+func Test_removeOwnerRules(t *testing.T) {
+	ctx := context.Background()
+
+	live := []cloudflare.UnvalidatedIngressRule{
+		{Hostname: "dashboard.walnuts.dev", Service: "http://dashboard:80"},
+		{Hostname: "other.walnuts.dev", Service: "http://other:80"},
+		{Hostname: "web.walnuts.dev", Service: "http://web:80"},
+		{Service: "http_status:404"},
+	}
+	owned := managedRules{
+		{Hostname: "other.walnuts.dev"}: {Hostname: "other.walnuts.dev", Service: "http://other:80", Owner: "Ingress/default/other"},
+		{Hostname: "web.walnuts.dev"}:   {Hostname: "web.walnuts.dev", Service: "http://web:80", Owner: "Ingress/default/web"},
+	}
+
+	gomockctrl := gomock.NewController(t)
+	manager := mock_controller.NewMockCloudflareTunnelManager(gomockctrl)
+	manager.EXPECT().GetTunnelConfiguration(gomock.Any(), "test").Return(domain.TunnelConfiguration{Ingress: live}, nil)
+	manager.EXPECT().UpdateTunnelConfiguration(gomock.Any(), "test", gomock.Any()).DoAndReturn(func(_ context.Context, _ string, config domain.TunnelConfiguration) (domain.TunnelConfiguration, error) {
+		var hostnames []string
+		for _, rule := range config.Ingress {
+			hostnames = append(hostnames, rule.Hostname)
+		}
+		// 他のOwnerのルールと管理外のルールは消さない
+		assert.Equal(t, []string{"dashboard.walnuts.dev", "other.walnuts.dev", ""}, hostnames)
+		return config, nil
+	})
+
+	c, cfTunnel, w := newTestTunnelConfigWriter(t, "test", owned)
+	cfTunnel.Spec.Settings.CatchAllRule = "http_status:404"
+
+	got, err := w.submit(ctx, c, manager, cfTunnel, func(owned managedRules) []ruleKey {
+		return removeOwnerRules(owned, "Ingress/default/web", []ingressRule{
+			{Hostname: "web.walnuts.dev"},
+			{Hostname: "other.walnuts.dev"},
+			{Hostname: "dashboard.walnuts.dev"},
+		})
+	})
+	if err != nil {
+		t.Fatalf("submit() error = %v", err)
+	}
+	assert.Equal(t, []string{"other.walnuts.dev"}, got.hostnames())
+}
+
+func Test_tunnelConfigWriter_submit(t *testing.T) {
+	ctx := context.Background()
+
+	gomockctrl := gomock.NewController(t)
+	manager := mock_controller.NewMockCloudflareTunnelManager(gomockctrl)
+	// 同じウィンドウ内の変更は1回の更新にまとめる
+	manager.EXPECT().GetTunnelConfiguration(gomock.Any(), "test").Return(domain.TunnelConfiguration{
+		Ingress: []cloudflare.UnvalidatedIngressRule{{Service: "CatchAll"}},
+	}, nil)
+	manager.EXPECT().UpdateTunnelConfiguration(gomock.Any(), "test", gomock.Any()).DoAndReturn(func(_ context.Context, _ string, config domain.TunnelConfiguration) (domain.TunnelConfiguration, error) {
+		assert.Len(t, config.Ingress, 3)
+		return config, nil
+	})
+
+	c, cfTunnel, w := newTestTunnelConfigWriter(t, "test", nil)
+
+	owners := map[string]ingressRule{
+		"Ingress/default/web": {Hostname: "web.walnuts.dev", Service: "http://web:80"},
+		"Ingress/default/api": {Hostname: "api.walnuts.dev", Service: "http://api:80"},
+	}
+	var wg sync.WaitGroup
+	for owner, rule := range owners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := w.submit(ctx, c, manager, cfTunnel, func(owned managedRules) []ruleKey {
+				stale, _ := replaceOwnerRules(owned, owner, []ingressRule{rule})
+				return stale
+			}); err != nil {
+				t.Errorf("submit() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// 変更がなければAPIを呼ばない
+	for owner, rule := range owners {
+		owned, err := w.submit(ctx, c, manager, cfTunnel, func(owned managedRules) []ruleKey {
+			stale, _ := replaceOwnerRules(owned, owner, []ingressRule{rule})
+			return stale
+		})
+		if err != nil {
+			t.Fatalf("submit() error = %v", err)
+		}
+		assert.Len(t, owned, 2)
+	}
+}
+
+func Test_updateTunnelConfig(t *testing.T) {
+	ctx := context.Background()
+
+	gomockctrl := gomock.NewController(t)
+	manager := mock_controller.NewMockCloudflareTunnelManager(gomockctrl)
+	gomock.InOrder(
+		manager.EXPECT().GetTunnelConfiguration(gomock.Any(), "test").Return(domain.TunnelConfiguration{
+			Ingress: []cloudflare.UnvalidatedIngressRule{{Service: "CatchAll"}},
+			Version: 1,
+		}, nil),
+		manager.EXPECT().UpdateTunnelConfiguration(gomock.Any(), "test", gomock.Any()).Return(domain.TunnelConfiguration{}, domain.ErrConfigurationConflict),
+		// 読み直した設定に対してやり直す
+		manager.EXPECT().GetTunnelConfiguration(gomock.Any(), "test").Return(domain.TunnelConfiguration{
+			Ingress: []cloudflare.UnvalidatedIngressRule{
+				{Hostname: "dashboard.walnuts.dev", Service: "http://dashboard:80"},
+				{Service: "CatchAll"},
+			},
+			Version: 2,
+		}, nil),
+		manager.EXPECT().UpdateTunnelConfiguration(gomock.Any(), "test", gomock.Any()).DoAndReturn(func(_ context.Context, _ string, config domain.TunnelConfiguration) (domain.TunnelConfiguration, error) {
+			assert.Equal(t, 2, config.Version)
+			assert.Len(t, config.Ingress, 3)
+			config.Version = 3
+			return config, nil
+		}),
+	)
+
+	owned := managedRules{
+		{Hostname: "web.walnuts.dev"}: {Hostname: "web.walnuts.dev", Service: "http://web:80", Owner: "Ingress/default/web"},
+	}
+	config, drifted, err := updateTunnelConfig(ctx, manager, "test", func(live []cloudflare.UnvalidatedIngressRule) []cloudflare.UnvalidatedIngressRule {
+		return buildIngressRules(live, owned, cftv1beta1.CloudflareTunnelSettings{CatchAllRule: "CatchAll"})
+	})
+	if err != nil {
+		t.Fatalf("updateTunnelConfig() error = %v", err)
+	}
+	assert.Equal(t, 1, drifted)
+	// 更新後のバージョンはAPIの応答から取る
+	assert.Equal(t, 3, config.Version)
+}
+
+func Test_updateTunnelConfig_conflict(t *testing.T) {
+	ctx := context.Background()
+
+	gomockctrl := gomock.NewController(t)
+	manager := mock_controller.NewMockCloudflareTunnelManager(gomockctrl)
+	manager.EXPECT().GetTunnelConfiguration(gomock.Any(), "test").Return(domain.TunnelConfiguration{
+		Ingress: []cloudflare.UnvalidatedIngressRule{{Service: "CatchAll"}},
+		Version: 1,
+	}, nil).Times(tunnelConfigConflictRetries + 1)
+	manager.EXPECT().UpdateTunnelConfiguration(gomock.Any(), "test", gomock.Any()).Return(domain.TunnelConfiguration{}, domain.ErrConfigurationConflict).Times(tunnelConfigConflictRetries + 1)
+
+	owned := managedRules{
+		{Hostname: "web.walnuts.dev"}: {Hostname: "web.walnuts.dev", Service: "http://web:80", Owner: "Ingress/default/web"},
+	}
+	_, _, err := updateTunnelConfig(ctx, manager, "test", func(live []cloudflare.UnvalidatedIngressRule) []cloudflare.UnvalidatedIngressRule {
+		return buildIngressRules(live, owned, cftv1beta1.CloudflareTunnelSettings{CatchAllRule: "CatchAll"})
+	})
+	assert.ErrorIs(t, err, domain.ErrConfigurationConflict)
+}
+
+// newTestTunnelConfigWriter returns a client, a CloudflareTunnel and its writer that has loaded owned as the ownership record.
+func newTestTunnelConfigWriter(t *testing.T, tunnelID string, owned managedRules) (client.Client, cftv1beta1.CloudflareTunnel, *tunnelConfigWriter) {
+	t.Helper()
+
+	scheme := runtime.NewScheme()
+	if err := cftv1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	cfTunnel := cftv1beta1.CloudflareTunnel{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test", UID: types.UID(t.Name())},
+		Spec: cftv1beta1.CloudflareTunnelSpec{
+			Settings: cftv1beta1.CloudflareTunnelSettings{CatchAllRule: "CatchAll"},
+		},
+		Status: cftv1beta1.CloudflareTunnelStatus{TunnelID: tunnelID},
+	}
+
+	if owned == nil {
+		owned = managedRules{}
+	}
+	w := tunnelConfigWriterFor(cfTunnel.Status.TunnelID)
+	w.owned = owned
+	t.Cleanup(func() {
+		forgetTunnelConfigWriter(cfTunnel.Status.TunnelID)
+	})
+
+	return fake.NewClientBuilder().WithScheme(scheme).Build(), cfTunnel, w
+}
+
+func Test_replaceOwnerRules_conflict(t *testing.T) {
+	owned := managedRules{
+		{Hostname: "web.walnuts.dev"}: {Hostname: "web.walnuts.dev", Service: "http://web:80", Owner: "Ingress/default/web"},
+	}
+
+	stale, err := replaceOwnerRules(owned, "HTTPRoute/default/web", []ingressRule{
+		{Hostname: "web.walnuts.dev", Service: "http://other:80"},
+		{Hostname: "api.walnuts.dev", Service: "http://api:80"},
+	})
+	if !errors.Is(err, ErrRuleConflict) {
+		t.Errorf("replaceOwnerRules() error = %v, want %v", err, ErrRuleConflict)
+	}
+	assert.Empty(t, stale)
+
+	// 先に追加したリソースのルールは残り、衝突しないルールだけが追加される
+	assert.Equal(t, "Ingress/default/web", owned[ruleKey{Hostname: "web.walnuts.dev"}].Owner)
+	assert.Equal(t, "http://web:80", owned[ruleKey{Hostname: "web.walnuts.dev"}].Service)
+	assert.Equal(t, "HTTPRoute/default/web", owned[ruleKey{Hostname: "api.walnuts.dev"}].Owner)
+}
@@ -0,0 +1,384 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	cftv1beta1 "github.com/walnuts1018/cloudflare-tunnel-operator/api/v1beta1"
+	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/domain"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// tunnelConfigBatchWindow is how long the changes to a tunnel are collected before they are pushed together.
+var tunnelConfigBatchWindow = 200 * time.Millisecond
+
+// tunnelConfigConflictRetries is how many times the read-modify-write of a tunnel configuration is retried on a conflict.
+const tunnelConfigConflictRetries = 3
+
+var (
+	tunnelConfigWritersMu sync.Mutex
+	// tunnelConfigWriters holds the writers keyed by the tunnel ID.
+	// トンネルごとに別のロックを使うので、あるトンネルへの遅いAPI呼び出しが他のトンネルを待たせない
+	tunnelConfigWriters = map[string]*tunnelConfigWriter{}
+)
+
+// tunnelConfigWriterFor returns the writer of the tunnel, creating it if needed.
+func tunnelConfigWriterFor(tunnelID string) *tunnelConfigWriter {
+	tunnelConfigWritersMu.Lock()
+	defer tunnelConfigWritersMu.Unlock()
+
+	w, ok := tunnelConfigWriters[tunnelID]
+	if !ok {
+		w = &tunnelConfigWriter{}
+		tunnelConfigWriters[tunnelID] = w
+	}
+	return w
+}
+
+// forgetTunnelConfigWriter drops the writer of the tunnel and the state cached in it.
+func forgetTunnelConfigWriter(tunnelID string) {
+	tunnelConfigWritersMu.Lock()
+	defer tunnelConfigWritersMu.Unlock()
+	delete(tunnelConfigWriters, tunnelID)
+}
+
+// tunnelConfigChange is a change of the rules of an owner, submitted by a reconciler.
+type tunnelConfigChange struct {
+	// apply updates the ownership record and returns the keys of the rules that were released.
+	apply func(owned managedRules) []ruleKey
+
+	ctx      context.Context
+	client   client.Client
+	manager  CloudflareTunnelManager
+	cfTunnel cftv1beta1.CloudflareTunnel
+	done     chan tunnelConfigResult
+}
+
+type tunnelConfigResult struct {
+	owned managedRules
+	err   error
+}
+
+// tunnelConfigWriter is the single writer of the configuration of a tunnel.
+// It holds the desired rules of the tunnel, which are aggregated from every owner, and the configuration that was pushed last.
+// The changes submitted within tunnelConfigBatchWindow are pushed with one UpdateTunnelConfiguration,
+// and nothing is pushed if the configuration would not change.
+type tunnelConfigWriter struct {
+	// flushMu serializes the reads and writes of the tunnel configuration.
+	flushMu sync.Mutex
+	// dnsMu serializes the read-modify-write of the DNS records of the tunnel.
+	dnsMu sync.Mutex
+
+	mu sync.Mutex
+	// owned is the ownership record, which is nil until it is loaded.
+	// Informerのキャッシュは書き込み直後に古い値を返すことがあるので、最後に保存したものを使う
+	owned managedRules
+	// live is the configuration that was fetched or pushed last, or nil if it is unknown.
+	// It is only used to skip the changes that do not affect the tunnel.
+	live    *domain.TunnelConfiguration
+	pending []*tunnelConfigChange
+}
+
+// submit applies the change to the tunnel and returns the updated ownership record.
+// It returns immediately if the change does not affect the tunnel, and otherwise waits for the batch that the change is pushed in.
+func (w *tunnelConfigWriter) submit(
+	ctx context.Context,
+	c client.Client,
+	manager CloudflareTunnelManager,
+	cfTunnel cftv1beta1.CloudflareTunnel,
+	apply func(owned managedRules) []ruleKey,
+) (managedRules, error) {
+	w.mu.Lock()
+	if owned, ok := w.unchanged(cfTunnel, apply); ok {
+		w.mu.Unlock()
+		return owned, nil
+	}
+
+	change := &tunnelConfigChange{
+		apply:    apply,
+		ctx:      context.WithoutCancel(ctx),
+		client:   c,
+		manager:  manager,
+		cfTunnel: cfTunnel,
+		done:     make(chan tunnelConfigResult, 1),
+	}
+	w.pending = append(w.pending, change)
+	if len(w.pending) == 1 {
+		time.AfterFunc(tunnelConfigBatchWindow, w.flush)
+	}
+	w.mu.Unlock()
+
+	select {
+	case result := <-change.done:
+		return result.owned, result.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// unchanged reports whether the change can be skipped, because it changes neither the ownership record nor the pushed configuration.
+// w.mu must be held.
+func (w *tunnelConfigWriter) unchanged(cfTunnel cftv1beta1.CloudflareTunnel, apply func(owned managedRules) []ruleKey) (managedRules, bool) {
+	// 未反映の変更がある場合は、その後に適用する必要がある
+	if w.owned == nil || w.live == nil || len(w.pending) > 0 {
+		return nil, false
+	}
+
+	owned := maps.Clone(w.owned)
+	if released := apply(owned); len(released) > 0 || !reflect.DeepEqual(owned, w.owned) {
+		return nil, false
+	}
+
+	desired := buildIngressRules(w.live.Ingress, owned, cfTunnel.Spec.Settings)
+	if countDriftedRules(w.live.Ingress, desired) > 0 {
+		return nil, false
+	}
+	return owned, true
+}
+
+// flush pushes the pending changes together, and notifies the reconcilers that submitted them.
+func (w *tunnelConfigWriter) flush() {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	w.mu.Lock()
+	changes := w.pending
+	w.pending = nil
+	w.mu.Unlock()
+	if len(changes) == 0 {
+		return
+	}
+
+	// 最後に受け取ったCloudflareTunnelの設定を使う
+	last := changes[len(changes)-1]
+	owned, err := w.push(last.ctx, last.client, last.manager, last.cfTunnel, changes)
+	if err != nil {
+		w.mu.Lock()
+		// 失敗した場合、Cloudflare側の状態がわからないので次回取得し直す
+		w.live = nil
+		w.mu.Unlock()
+	}
+
+	for _, change := range changes {
+		change.done <- tunnelConfigResult{owned: maps.Clone(owned), err: err}
+	}
+}
+
+// push applies the changes to the ownership record, and updates the tunnel configuration and the record if they changed.
+// w.flushMu must be held.
+func (w *tunnelConfigWriter) push(
+	ctx context.Context,
+	c client.Client,
+	manager CloudflareTunnelManager,
+	cfTunnel cftv1beta1.CloudflareTunnel,
+	changes []*tunnelConfigChange,
+) (managedRules, error) {
+	logger := log.FromContext(ctx)
+
+	previous, err := w.load(ctx, c, cfTunnel)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load managed rules: %w", err)
+	}
+
+	owned := maps.Clone(previous)
+	var released []ruleKey
+	for _, change := range changes {
+		released = append(released, change.apply(owned)...)
+	}
+
+	config, drifted, err := updateTunnelConfig(ctx, manager, cfTunnel.Status.TunnelID, func(live []cloudflare.UnvalidatedIngressRule) []cloudflare.UnvalidatedIngressRule {
+		live = slices.DeleteFunc(slices.Clone(live), func(rule cloudflare.UnvalidatedIngressRule) bool {
+			key := ruleKey{Hostname: rule.Hostname, Path: rule.Path}
+			_, reowned := owned[key]
+			return slices.Contains(released, key) && !reowned
+		})
+		return buildIngressRules(live, owned, cfTunnel.Spec.Settings)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if drifted > 0 {
+		logger.Info("updated tunnel configuration", "changes", len(changes), "rules", drifted)
+	}
+
+	w.mu.Lock()
+	w.live = &config
+	w.mu.Unlock()
+
+	if !reflect.DeepEqual(owned, previous) {
+		if err := saveManagedRules(ctx, c, cfTunnel, owned); err != nil {
+			return nil, fmt.Errorf("failed to save managed rules: %w", err)
+		}
+	}
+
+	w.mu.Lock()
+	w.owned = owned
+	w.mu.Unlock()
+	return owned, nil
+}
+
+// sync replaces the ownership record with the rules built by desired from the record, and repairs the tunnel configuration
+// if it differs from them. Recorded rules that are not desired any more are pruned, while the rules that were never recorded are left as they are.
+// It returns the ownership record, the keys of the pruned rules and the number of drifted rules.
+func (w *tunnelConfigWriter) sync(
+	ctx context.Context,
+	c client.Client,
+	manager CloudflareTunnelManager,
+	cfTunnel cftv1beta1.CloudflareTunnel,
+	desired func(recorded managedRules) (managedRules, error),
+) (managedRules, []ruleKey, int, error) {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	recorded, err := w.load(ctx, c, cfTunnel)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("failed to load managed rules: %w", err)
+	}
+
+	owned, err := desired(maps.Clone(recorded))
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("failed to build desired rules: %w", err)
+	}
+
+	var pruned []ruleKey
+	for key := range recorded {
+		if _, ok := owned[key]; !ok {
+			pruned = append(pruned, key)
+		}
+	}
+	if len(owned) == 0 && len(pruned) == 0 {
+		return owned, nil, 0, nil
+	}
+
+	config, drifted, err := updateTunnelConfig(ctx, manager, cfTunnel.Status.TunnelID, func(live []cloudflare.UnvalidatedIngressRule) []cloudflare.UnvalidatedIngressRule {
+		live = slices.DeleteFunc(slices.Clone(live), func(rule cloudflare.UnvalidatedIngressRule) bool {
+			return slices.Contains(pruned, ruleKey{Hostname: rule.Hostname, Path: rule.Path})
+		})
+		return buildIngressRules(live, owned, cfTunnel.Spec.Settings)
+	})
+	if err != nil {
+		w.mu.Lock()
+		w.live = nil
+		w.mu.Unlock()
+		return nil, nil, 0, err
+	}
+	if drifted > 0 {
+		log.FromContext(ctx).Info("repaired drifted tunnel ingress rules", "count", drifted, "pruned", len(pruned))
+	}
+
+	w.mu.Lock()
+	w.live = &config
+	w.mu.Unlock()
+
+	if !reflect.DeepEqual(owned, recorded) {
+		if err := saveManagedRules(ctx, c, cfTunnel, owned); err != nil {
+			return nil, nil, 0, fmt.Errorf("failed to save managed rules: %w", err)
+		}
+	}
+
+	w.mu.Lock()
+	w.owned = owned
+	w.mu.Unlock()
+	return owned, pruned, drifted, nil
+}
+
+// updateTunnelConfig reads the tunnel configuration, builds the desired rules from the live ones and writes them if they differ.
+// It returns the written configuration and the number of rules that differed.
+// 管理外のルールを上書きしないよう、書き込む前には必ず最新の設定を取得し、その間に更新されていたらやり直す
+// 同じトンネルへのOperator内の書き込みはwriterのロックで直列化し、ダッシュボードなど外部からの更新はバージョンで検出する
+func updateTunnelConfig(
+	ctx context.Context,
+	manager CloudflareTunnelManager,
+	tunnelID string,
+	build func(live []cloudflare.UnvalidatedIngressRule) []cloudflare.UnvalidatedIngressRule,
+) (domain.TunnelConfiguration, int, error) {
+	for attempt := 0; ; attempt++ {
+		config, err := manager.GetTunnelConfiguration(ctx, tunnelID)
+		if err != nil {
+			return domain.TunnelConfiguration{}, 0, fmt.Errorf("failed to get tunnel configs: %w", err)
+		}
+
+		desired := build(config.Ingress)
+		drifted := countDriftedRules(config.Ingress, desired)
+		if drifted == 0 {
+			return config, 0, nil
+		}
+
+		config.Ingress = desired
+		updated, err := manager.UpdateTunnelConfiguration(ctx, tunnelID, config)
+		if errors.Is(err, domain.ErrConfigurationConflict) && attempt < tunnelConfigConflictRetries {
+			log.FromContext(ctx).Info("tunnel configuration was updated concurrently, retrying", "attempt", attempt+1)
+			continue
+		}
+		if err != nil {
+			return domain.TunnelConfiguration{}, 0, fmt.Errorf("failed to update tunnel configs: %w", err)
+		}
+		return updated, drifted, nil
+	}
+}
+
+// liveConfig returns the configuration that was fetched or pushed last, and false if it is unknown.
+func (w *tunnelConfigWriter) liveConfig() (domain.TunnelConfiguration, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.live == nil {
+		return domain.TunnelConfiguration{}, false
+	}
+	config := *w.live
+	config.Ingress = slices.Clone(w.live.Ingress)
+	return config, true
+}
+
+// liveTunnelConfig returns the configuration of the tunnel held by its writer, and fetches it from Cloudflare only if it is unknown.
+func liveTunnelConfig(ctx context.Context, manager CloudflareTunnelManager, tunnelID string) (domain.TunnelConfiguration, error) {
+	if config, ok := tunnelConfigWriterFor(tunnelID).liveConfig(); ok {
+		return config, nil
+	}
+
+	config, err := manager.GetTunnelConfiguration(ctx, tunnelID)
+	if err != nil {
+		return domain.TunnelConfiguration{}, fmt.Errorf("failed to get tunnel configs: %w", err)
+	}
+	return config, nil
+}
+
+// ownedRules returns a copy of the ownership record of the tunnel.
+func (w *tunnelConfigWriter) ownedRules(ctx context.Context, c client.Client, cfTunnel cftv1beta1.CloudflareTunnel) (managedRules, error) {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	owned, err := w.load(ctx, c, cfTunnel)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load managed rules: %w", err)
+	}
+	return maps.Clone(owned), nil
+}
+
+// load returns the ownership record, reading it from the ConfigMap on the first call. w.flushMu must be held.
+func (w *tunnelConfigWriter) load(ctx context.Context, c client.Client, cfTunnel cftv1beta1.CloudflareTunnel) (managedRules, error) {
+	w.mu.Lock()
+	owned := w.owned
+	w.mu.Unlock()
+	if owned != nil {
+		return owned, nil
+	}
+
+	owned, err := loadManagedRules(ctx, c, cfTunnel)
+	if err != nil {
+		return nil, err
+	}
+
+	w.mu.Lock()
+	w.owned = owned
+	w.mu.Unlock()
+	return owned, nil
+}
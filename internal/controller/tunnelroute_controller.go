@@ -0,0 +1,278 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/cloudflare/cloudflare-go"
+	cftv1beta1 "github.com/walnuts1018/cloudflare-tunnel-operator/api/v1beta1"
+	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/domain"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// TunnelRouteReconciler reconciles a TunnelRoute object
+type TunnelRouteReconciler struct {
+	client.Client
+	Scheme                  *runtime.Scheme
+	CloudflareTunnelManager CloudflareTunnelManager
+	Credentials             *CloudflareCredentialsCache
+	// MaxConcurrentReconciles is the number of resources reconciled in parallel. The default is 1.
+	MaxConcurrentReconciles int
+}
+
+// +kubebuilder:rbac:groups=cf-tunnel-operator.walnuts.dev,resources=tunnelroutes,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=cf-tunnel-operator.walnuts.dev,resources=tunnelroutes/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=cf-tunnel-operator.walnuts.dev,resources=tunnelroutes/finalizers,verbs=update
+
+func (r *TunnelRouteReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	route := &cftv1beta1.TunnelRoute{}
+	if err := r.Get(ctx, req.NamespacedName, route); err != nil {
+		if apierrors.IsNotFound(err) {
+			logger.Info("tunnelroute resource not found")
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, fmt.Errorf("failed to get TunnelRoute: %w", err)
+	}
+
+	if !route.DeletionTimestamp.IsZero() {
+		if controllerutil.ContainsFinalizer(route, finalizerName) {
+			if err := r.finalizeTunnelRoute(ctx, route); err != nil {
+				return ctrl.Result{}, fmt.Errorf("failed to finalize TunnelRoute: %w", err)
+			}
+
+			controllerutil.RemoveFinalizer(route, finalizerName)
+			if err := r.Update(ctx, route); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if !controllerutil.ContainsFinalizer(route, finalizerName) {
+		controllerutil.AddFinalizer(route, finalizerName)
+		if err := r.Update(ctx, route); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to add finalizer to TunnelRoute: %w", err)
+		}
+	}
+
+	// 不正なServiceはリトライしても直らないので、Statusに書いて終わる
+	if err := domain.ValidateOriginService(route.Spec.Service); err != nil {
+		return ctrl.Result{}, r.updateNotReady(ctx, route, "InvalidService", err.Error())
+	}
+
+	cfTunnel, err := getCloudflareTunnel(ctx, r.Client, tunnelRefName(*route))
+	if err != nil {
+		if errors.Is(err, ErrDefaultCloudflareTunnelNotExists) || errors.Is(err, ErrCloudflareTunnelNotFound) {
+			// 存在しないトンネルに移された場合も、前のトンネルからルールを消す
+			if err := releasePreviousTunnel(ctx, r.Client, r.CloudflareTunnelManager, r.Credentials, route, ruleOwner("TunnelRoute", route), nil); err != nil {
+				return ctrl.Result{}, fmt.Errorf("failed to remove rules from the previous Cloudflare Tunnel: %w", err)
+			}
+			if err := recordAppliedTunnel(ctx, r.Client, route, nil); err != nil {
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{}, r.updateNotReady(ctx, route, "TunnelNotFound", err.Error())
+		}
+		return ctrl.Result{}, fmt.Errorf("failed to get Cloudflare Tunnel: %w", err)
+	}
+
+	if !cfTunnel.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, fmt.Errorf("cloudflare tunnel is being deleted: %w", ErrCloudflareTunnelNotFound)
+	}
+
+	tunnelID := cfTunnel.Status.TunnelID
+	if tunnelID == "" {
+		if err := r.updateNotReady(ctx, route, "TunnelNotReady", "tunnel ID is empty"); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, fmt.Errorf("tunnel ID is empty")
+	}
+
+	manager, zones, err := cloudflareTunnelManagerFor(ctx, r.Client, r.CloudflareTunnelManager, r.Credentials, cfTunnel)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to get Cloudflare Tunnel client: %w", err)
+	}
+
+	// 別のトンネルに移された場合は、前のトンネルからルールを消す
+	owner := ruleOwner("TunnelRoute", route)
+	if err := releasePreviousTunnel(ctx, r.Client, r.CloudflareTunnelManager, r.Credentials, route, owner, &cfTunnel); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to remove rules from the previous Cloudflare Tunnel: %w", err)
+	}
+
+	rule := tunnelRouteRule(*route)
+	if err := applyTunnelRules(ctx, r.Client, manager, cfTunnel, zones, owner, []ingressRule{rule}); err != nil {
+		if err := r.updateNotReady(ctx, route, "ApplyFailed", err.Error()); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, err
+	}
+
+	if err := recordAppliedTunnel(ctx, r.Client, route, &cfTunnel); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	route.Status.TunnelID = tunnelID
+	if err := r.observeRule(ctx, manager, tunnelID, rule, zones, route); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, r.updateStatus(ctx, route)
+}
+
+// observeRule sets the conditions from the tunnel configuration and the DNS record that are actually in Cloudflare.
+// The tunnel configuration is taken from the writer of the tunnel, which holds the configuration it fetched or pushed last.
+func (r *TunnelRouteReconciler) observeRule(ctx context.Context, manager CloudflareTunnelManager, tunnelID string, rule ingressRule, zones []string, route *cftv1beta1.TunnelRoute) error {
+	config, err := liveTunnelConfig(ctx, manager, tunnelID)
+	if err != nil {
+		return err
+	}
+
+	if slices.ContainsFunc(config.Ingress, func(live cloudflare.UnvalidatedIngressRule) bool {
+		return live.Hostname == rule.Hostname && live.Path == rule.Path && live.Service == rule.Service
+	}) {
+		meta.SetStatusCondition(&route.Status.Conditions, metav1.Condition{
+			Type:   cftv1beta1.TypeTunnelRouteReady,
+			Status: metav1.ConditionTrue,
+			Reason: "RuleLive",
+		})
+	} else {
+		meta.SetStatusCondition(&route.Status.Conditions, metav1.Condition{
+			Type:    cftv1beta1.TypeTunnelRouteReady,
+			Status:  metav1.ConditionFalse,
+			Reason:  "RuleNotLive",
+			Message: "the rule is not found in the tunnel configuration",
+		})
+	}
+
+	zone, err := manager.ResolveZone(ctx, rule.Hostname, zones)
+	if err != nil {
+		if errors.Is(err, domain.ErrZoneNotFound) {
+			meta.SetStatusCondition(&route.Status.Conditions, metav1.Condition{
+				Type:    cftv1beta1.TypeTunnelRouteDNSHealthy,
+				Status:  metav1.ConditionFalse,
+				Reason:  "ZoneNotFound",
+				Message: err.Error(),
+			})
+			return nil
+		}
+		return fmt.Errorf("failed to resolve zone: %w", err)
+	}
+
+	record, err := manager.GetDNS(ctx, zone.ID, tunnelID, rule.Hostname)
+	if err != nil {
+		return fmt.Errorf("failed to get DNS record: %w", err)
+	}
+
+	if record.Healthy(tunnelID) {
+		meta.SetStatusCondition(&route.Status.Conditions, metav1.Condition{
+			Type:   cftv1beta1.TypeTunnelRouteDNSHealthy,
+			Status: metav1.ConditionTrue,
+			Reason: "Healthy",
+		})
+	} else {
+		meta.SetStatusCondition(&route.Status.Conditions, metav1.Condition{
+			Type:    cftv1beta1.TypeTunnelRouteDNSHealthy,
+			Status:  metav1.ConditionFalse,
+			Reason:  "Unhealthy",
+			Message: "the DNS record does not point to the tunnel",
+		})
+	}
+	return nil
+}
+
+func (r *TunnelRouteReconciler) updateNotReady(ctx context.Context, route *cftv1beta1.TunnelRoute, reason string, message string) error {
+	meta.SetStatusCondition(&route.Status.Conditions, metav1.Condition{
+		Type:    cftv1beta1.TypeTunnelRouteReady,
+		Status:  metav1.ConditionFalse,
+		Reason:  reason,
+		Message: message,
+	})
+	return r.updateStatus(ctx, route)
+}
+
+func (r *TunnelRouteReconciler) updateStatus(ctx context.Context, route *cftv1beta1.TunnelRoute) error {
+	route.Status.ObservedGeneration = route.Generation
+	if err := r.Status().Update(ctx, route); err != nil {
+		return fmt.Errorf("failed to update TunnelRoute status: %w", err)
+	}
+	return nil
+}
+
+func (r *TunnelRouteReconciler) finalizeTunnelRoute(ctx context.Context, route *cftv1beta1.TunnelRoute) error {
+	logger := log.FromContext(ctx)
+	owner := ruleOwner("TunnelRoute", route)
+
+	cfTunnel, err := getCloudflareTunnel(ctx, r.Client, tunnelRefName(*route))
+	if err != nil {
+		if errors.Is(err, ErrDefaultCloudflareTunnelNotExists) || errors.Is(err, ErrCloudflareTunnelNotFound) {
+			logger.Info("cloudflare tunnel not found, skip removing tunnelroute from the tunnel")
+			return releasePreviousTunnel(ctx, r.Client, r.CloudflareTunnelManager, r.Credentials, route, owner, nil)
+		}
+		return fmt.Errorf("failed to get Cloudflare Tunnel: %w", err)
+	}
+
+	// CloudflareTunnelリソースの削除時にトンネルやレコードが削除されるので、何もせずに抜ける
+	if !cfTunnel.DeletionTimestamp.IsZero() {
+		logger.Info("cloudflare tunnel is being deleted, skip removing tunnelroute from the tunnel")
+		return nil
+	}
+
+	tunnelID := cfTunnel.Status.TunnelID
+	if tunnelID == "" {
+		return fmt.Errorf("tunnel ID is empty")
+	}
+
+	manager, zones, err := cloudflareTunnelManagerFor(ctx, r.Client, r.CloudflareTunnelManager, r.Credentials, cfTunnel)
+	if err != nil {
+		return fmt.Errorf("failed to get Cloudflare Tunnel client: %w", err)
+	}
+
+	if err := releasePreviousTunnel(ctx, r.Client, r.CloudflareTunnelManager, r.Credentials, route, owner, &cfTunnel); err != nil {
+		return fmt.Errorf("failed to remove rules from the previous Cloudflare Tunnel: %w", err)
+	}
+
+	return removeTunnelRules(ctx, r.Client, manager, cfTunnel, zones, owner, []ingressRule{tunnelRouteRule(*route)})
+}
+
+func tunnelRouteRule(route cftv1beta1.TunnelRoute) ingressRule {
+	return ingressRule{
+		Hostname:      route.Spec.Hostname,
+		Path:          route.Spec.Path,
+		Service:       route.Spec.Service,
+		OriginRequest: route.Spec.OriginRequest,
+	}
+}
+
+// tunnelRefName returns the name of the referenced CloudflareTunnel, or an empty name for the default one.
+func tunnelRefName(route cftv1beta1.TunnelRoute) types.NamespacedName {
+	if route.Spec.TunnelRef == nil {
+		return types.NamespacedName{}
+	}
+
+	namespace := route.Spec.TunnelRef.Namespace
+	if namespace == "" {
+		namespace = route.Namespace
+	}
+	return types.NamespacedName{Namespace: namespace, Name: route.Spec.TunnelRef.Name}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *TunnelRouteReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&cftv1beta1.TunnelRoute{}).
+		Named("tunnelroute").
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
+		Complete(requeueOnRateLimit(r))
+}
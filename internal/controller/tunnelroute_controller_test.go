@@ -0,0 +1,251 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/cloudflare/cloudflare-go"
+	cftv1beta1 "github.com/walnuts1018/cloudflare-tunnel-operator/api/v1beta1"
+	"github.com/walnuts1018/cloudflare-tunnel-operator/internal/consts"
+	mock_controller "github.com/walnuts1018/cloudflare-tunnel-operator/internal/controller/mock"
+	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/domain"
+	"go.uber.org/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestTunnelRouteReconciler_Reconcile(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	if err := cftv1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	cfTunnel := &cftv1beta1.CloudflareTunnel{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "tunnel",
+			UID:       "tunnel-uid",
+			Labels:    map[string]string{consts.DefaultLabelKey: "true"},
+		},
+		Spec: cftv1beta1.CloudflareTunnelSpec{
+			Settings: cftv1beta1.CloudflareTunnelSettings{CatchAllRule: "http_status:404"},
+		},
+		Status: cftv1beta1.CloudflareTunnelStatus{TunnelID: "tunnel-id"},
+	}
+
+	tests := []struct {
+		name      string
+		service   string
+		expect    func(m *mock_controller.MockCloudflareTunnelManager)
+		wantReady metav1.ConditionStatus
+		wantDNS   metav1.ConditionStatus
+		reason    string
+	}{
+		{
+			name:    "rule is live",
+			service: "ssh://bastion.default.svc:22",
+			expect: func(m *mock_controller.MockCloudflareTunnelManager) {
+				// 書き込んだ設定はwriterが持っているので、ルールの確認では取得し直さない
+				m.EXPECT().GetTunnelConfiguration(gomock.Any(), "tunnel-id").Return(domain.TunnelConfiguration{}, nil)
+				m.EXPECT().UpdateTunnelConfiguration(gomock.Any(), "tunnel-id", gomock.Any()).DoAndReturn(func(_ context.Context, _ string, c domain.TunnelConfiguration) (domain.TunnelConfiguration, error) {
+					return c, nil
+				})
+				m.EXPECT().ResolveZone(gomock.Any(), "ssh.walnuts.dev", gomock.Any()).Return(domain.Zone{ID: "zone-id", Name: "walnuts.dev"}, nil).Times(2)
+				m.EXPECT().GetDNS(gomock.Any(), "zone-id", "tunnel-id", "ssh.walnuts.dev").Return(domain.DNSRecord{}, nil)
+				m.EXPECT().AddDNS(gomock.Any(), "zone-id", "tunnel-id", "ssh.walnuts.dev").Return(nil)
+				m.EXPECT().GetDNS(gomock.Any(), "zone-id", "tunnel-id", "ssh.walnuts.dev").Return(domain.DNSRecord{
+					ID:      "record-id",
+					Type:    "CNAME",
+					Content: "tunnel-id.cfargotunnel.com",
+					Proxied: ptr.To(true),
+				}, nil)
+			},
+			wantReady: metav1.ConditionTrue,
+			wantDNS:   metav1.ConditionTrue,
+			reason:    "RuleLive",
+		},
+		{
+			name:      "invalid service",
+			service:   "udp://dns.default.svc:53",
+			expect:    func(m *mock_controller.MockCloudflareTunnelManager) {},
+			wantReady: metav1.ConditionFalse,
+			reason:    "InvalidService",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gomockctrl := gomock.NewController(t)
+			manager := mock_controller.NewMockCloudflareTunnelManager(gomockctrl)
+			tt.expect(manager)
+			t.Cleanup(func() { forgetTunnelConfigWriter("tunnel-id") })
+
+			route := &cftv1beta1.TunnelRoute{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "ssh"},
+				Spec: cftv1beta1.TunnelRouteSpec{
+					Hostname: "ssh.walnuts.dev",
+					Service:  tt.service,
+				},
+			}
+
+			c := fake.NewClientBuilder().
+				WithScheme(scheme).
+				WithObjects(cfTunnel.DeepCopy(), route).
+				WithStatusSubresource(route).
+				Build()
+			r := &TunnelRouteReconciler{
+				Client:                  c,
+				Scheme:                  scheme,
+				CloudflareTunnelManager: manager,
+			}
+
+			if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(route)}); err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+
+			var got cftv1beta1.TunnelRoute
+			if err := c.Get(ctx, client.ObjectKeyFromObject(route), &got); err != nil {
+				t.Fatal(err)
+			}
+
+			ready := meta.FindStatusCondition(got.Status.Conditions, cftv1beta1.TypeTunnelRouteReady)
+			if ready == nil || ready.Status != tt.wantReady || ready.Reason != tt.reason {
+				t.Errorf("Ready condition = %v, want %v (%v)", ready, tt.wantReady, tt.reason)
+			}
+			if tt.wantDNS != "" {
+				if dns := meta.FindStatusCondition(got.Status.Conditions, cftv1beta1.TypeTunnelRouteDNSHealthy); dns == nil || dns.Status != tt.wantDNS {
+					t.Errorf("DNSHealthy condition = %v, want %v", dns, tt.wantDNS)
+				}
+			}
+		})
+	}
+}
+
+func TestTunnelRouteReconciler_Reconcile_tunnelRefChanged(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	if err := cftv1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	previous := &cftv1beta1.CloudflareTunnel{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "previous", UID: "previous-uid"},
+		Spec: cftv1beta1.CloudflareTunnelSpec{
+			Settings: cftv1beta1.CloudflareTunnelSettings{CatchAllRule: "http_status:404"},
+		},
+		Status: cftv1beta1.CloudflareTunnelStatus{TunnelID: "old-tunnel-id"},
+	}
+	current := &cftv1beta1.CloudflareTunnel{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "current", UID: "current-uid"},
+		Spec: cftv1beta1.CloudflareTunnelSpec{
+			Settings: cftv1beta1.CloudflareTunnelSettings{CatchAllRule: "http_status:404"},
+		},
+		Status: cftv1beta1.CloudflareTunnelStatus{TunnelID: "new-tunnel-id"},
+	}
+
+	rules, err := json.Marshal([]managedRule{
+		{Hostname: "ssh.walnuts.dev", Service: "ssh://bastion.default.svc:22", Owner: "TunnelRoute/default/ssh"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "previous-managed-rules"},
+		Data:       map[string]string{managedRulesKey: string(rules)},
+	}
+
+	route := &cftv1beta1.TunnelRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "default",
+			Name:        "ssh",
+			Annotations: map[string]string{appliedTunnelAnnotation: "default/previous"},
+			Finalizers:  []string{finalizerName},
+		},
+		Spec: cftv1beta1.TunnelRouteSpec{
+			TunnelRef: &cftv1beta1.TunnelReference{Name: "current"},
+			Hostname:  "ssh.walnuts.dev",
+			Service:   "ssh://bastion.default.svc:22",
+		},
+	}
+
+	gomockctrl := gomock.NewController(t)
+	manager := mock_controller.NewMockCloudflareTunnelManager(gomockctrl)
+
+	// 前のトンネルからはルールとDNSレコードが消される
+	manager.EXPECT().GetTunnelConfiguration(gomock.Any(), "old-tunnel-id").Return(domain.TunnelConfiguration{
+		Ingress: []cloudflare.UnvalidatedIngressRule{
+			{Hostname: "ssh.walnuts.dev", Service: "ssh://bastion.default.svc:22"},
+			{Service: "http_status:404"},
+		},
+	}, nil)
+	manager.EXPECT().UpdateTunnelConfiguration(gomock.Any(), "old-tunnel-id", gomock.Any()).DoAndReturn(func(_ context.Context, _ string, config domain.TunnelConfiguration) (domain.TunnelConfiguration, error) {
+		if len(config.Ingress) != 1 {
+			t.Errorf("UpdateTunnelConfiguration() ingress = %+v, want only the catch-all rule", config.Ingress)
+		}
+		return config, nil
+	})
+	manager.EXPECT().ResolveZone(gomock.Any(), "ssh.walnuts.dev", gomock.Any()).Return(domain.Zone{ID: "zone-id"}, nil).AnyTimes()
+	manager.EXPECT().GetDNS(gomock.Any(), "zone-id", "old-tunnel-id", "ssh.walnuts.dev").Return(domain.DNSRecord{
+		ID:      "previous-record-id",
+		Type:    "CNAME",
+		Content: "old-tunnel-id.cfargotunnel.com",
+		Proxied: ptr.To(true),
+	}, nil)
+	manager.EXPECT().DeleteDNS(gomock.Any(), "zone-id", "previous-record-id").Return(nil)
+
+	// 新しいトンネルに追加される
+	var config domain.TunnelConfiguration
+	manager.EXPECT().GetTunnelConfiguration(gomock.Any(), "new-tunnel-id").DoAndReturn(func(context.Context, string) (domain.TunnelConfiguration, error) {
+		return config, nil
+	}).AnyTimes()
+	manager.EXPECT().UpdateTunnelConfiguration(gomock.Any(), "new-tunnel-id", gomock.Any()).DoAndReturn(func(_ context.Context, _ string, c domain.TunnelConfiguration) (domain.TunnelConfiguration, error) {
+		config = c
+		return c, nil
+	})
+	manager.EXPECT().GetDNS(gomock.Any(), "zone-id", "new-tunnel-id", "ssh.walnuts.dev").Return(domain.DNSRecord{}, nil)
+	manager.EXPECT().AddDNS(gomock.Any(), "zone-id", "new-tunnel-id", "ssh.walnuts.dev").Return(nil)
+	manager.EXPECT().GetDNS(gomock.Any(), "zone-id", "new-tunnel-id", "ssh.walnuts.dev").Return(domain.DNSRecord{
+		ID:      "record-id",
+		Type:    "CNAME",
+		Content: "new-tunnel-id.cfargotunnel.com",
+		Proxied: ptr.To(true),
+	}, nil)
+
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(previous, current, record, route).
+		WithStatusSubresource(route).
+		Build()
+	r := &TunnelRouteReconciler{
+		Client:                  c,
+		Scheme:                  scheme,
+		CloudflareTunnelManager: manager,
+	}
+
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(route)}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+
+	var got cftv1beta1.TunnelRoute
+	if err := c.Get(ctx, client.ObjectKeyFromObject(route), &got); err != nil {
+		t.Fatal(err)
+	}
+	if v := got.Annotations[appliedTunnelAnnotation]; v != "default/current" {
+		t.Errorf("applied tunnel annotation = %v, want default/current", v)
+	}
+}
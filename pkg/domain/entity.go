@@ -6,17 +6,79 @@ import (
 	"github.com/cloudflare/cloudflare-go"
 )
 
+type CloudflareCredentials struct {
+	APIToken  string
+	AccountID string
+}
+
 type CloudflareTunnel struct {
-	ID   string
-	Name string
+	ID           string
+	Name         string
+	RemoteConfig bool
+	Deleted      bool
 }
 
 type CloudflareTunnelToken string
 
-type TunnelConfiguration cloudflare.TunnelConfiguration
+type TunnelConfiguration struct {
+	Ingress       []cloudflare.UnvalidatedIngressRule
+	WarpRouting   *cloudflare.WarpRoutingConfig
+	OriginRequest cloudflare.OriginRequestConfig
+	// Version is the version of the configuration in Cloudflare, which is incremented on every update.
+	// If it is set, the configuration is updated only if the version in Cloudflare is still the same.
+	Version int
+}
+
+// TunnelConnector is a cloudflared instance that is registered to a tunnel.
+type TunnelConnector struct {
+	ID      string
+	Version string
+	Arch    string
+	// Connections are the connections of the connector to the Cloudflare edge.
+	Connections []TunnelConnection
+}
+
+type TunnelConnection struct {
+	ID       string
+	Colo     string
+	OriginIP string
+	// PendingReconnect is true if the connection is being closed and will be reconnected.
+	PendingReconnect bool
+}
+
+// Active reports whether the connector has at least one connection that serves requests.
+func (c TunnelConnector) Active() bool {
+	return c.ActiveConnections() > 0
+}
+
+// ActiveConnections returns the number of the connections that are not pending reconnect.
+func (c TunnelConnector) ActiveConnections() int {
+	n := 0
+	for _, conn := range c.Connections {
+		if !conn.PendingReconnect {
+			n++
+		}
+	}
+	return n
+}
 
 type DNSRecord cloudflare.DNSRecord
 
 func (d DNSRecord) Healthy(tunnelID string) bool {
-	return d.ID != "" && d.Type == "CNAME" && *d.Proxied && d.Content == fmt.Sprintf("%v.cfargotunnel.com", tunnelID)
+	return d.PointsTo(tunnelID) && *d.Proxied
+}
+
+// PointsTo reports whether the record is a CNAME to the tunnel.
+func (d DNSRecord) PointsTo(tunnelID string) bool {
+	return d.ID != "" && d.Type == "CNAME" && d.Content == TunnelCNAME(tunnelID)
+}
+
+// TunnelCNAME returns the hostname that the DNS records of the tunnel point to.
+func TunnelCNAME(tunnelID string) string {
+	return fmt.Sprintf("%v.cfargotunnel.com", tunnelID)
+}
+
+type Zone struct {
+	ID   string
+	Name string
 }
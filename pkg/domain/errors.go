@@ -0,0 +1,46 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrZoneNotFound              = errors.New("zone not found")
+	ErrUnsupportedOriginProtocol = errors.New("unsupported origin protocol")
+	ErrConfigurationConflict     = errors.New("tunnel configuration was updated concurrently")
+	ErrTunnelNotFound            = errors.New("tunnel not found")
+	ErrTunnelNotAdoptable        = errors.New("tunnel cannot be adopted")
+	ErrRateLimited               = errors.New("rate limited by Cloudflare API")
+	ErrCloudflareUnavailable     = errors.New("cloudflare API is unavailable")
+)
+
+// RateLimitError is returned when the Cloudflare API is still rate limiting the requests after the retries.
+// It matches ErrRateLimited with errors.Is.
+type RateLimitError struct {
+	// RetryAfter is how long to wait before the next request.
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%v: retry after %v", ErrRateLimited, e.RetryAfter)
+}
+
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// RetryAfter returns how long to wait before retrying, if err is caused by the rate limit of the Cloudflare API.
+func RetryAfter(err error) (time.Duration, bool) {
+	var rateLimitErr *RateLimitError
+	if !errors.As(err, &rateLimitErr) {
+		return 0, false
+	}
+	return rateLimitErr.RetryAfter, true
+}
+
+// IsTransient reports whether err is caused by a temporary failure of the Cloudflare API, and the request can be retried as it is.
+func IsTransient(err error) bool {
+	return errors.Is(err, ErrRateLimited) || errors.Is(err, ErrCloudflareUnavailable)
+}
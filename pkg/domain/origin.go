@@ -0,0 +1,103 @@
+package domain
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// OriginProtocol is the scheme of the origin service of a tunnel ingress rule.
+type OriginProtocol string
+
+const (
+	OriginProtocolHTTP    OriginProtocol = "http"
+	OriginProtocolHTTPS   OriginProtocol = "https"
+	OriginProtocolTCP     OriginProtocol = "tcp"
+	OriginProtocolSSH     OriginProtocol = "ssh"
+	OriginProtocolRDP     OriginProtocol = "rdp"
+	OriginProtocolSMB     OriginProtocol = "smb"
+	OriginProtocolUnix    OriginProtocol = "unix"
+	OriginProtocolUnixTLS OriginProtocol = "unix+tls"
+)
+
+var OriginProtocols = []OriginProtocol{
+	OriginProtocolHTTP,
+	OriginProtocolHTTPS,
+	OriginProtocolTCP,
+	OriginProtocolSSH,
+	OriginProtocolRDP,
+	OriginProtocolSMB,
+	OriginProtocolUnix,
+	OriginProtocolUnixTLS,
+}
+
+func ParseOriginProtocol(v string) (OriginProtocol, error) {
+	p := OriginProtocol(strings.ToLower(strings.TrimSpace(v)))
+	if !slices.Contains(OriginProtocols, p) {
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedOriginProtocol, v)
+	}
+	return p, nil
+}
+
+// OriginProtocolOf returns the protocol of the origin service URL, e.g. "ssh" for "ssh://bastion:22".
+func OriginProtocolOf(service string) OriginProtocol {
+	scheme, _, _ := strings.Cut(service, ":")
+	return OriginProtocol(strings.ToLower(scheme))
+}
+
+// IsStream reports whether cloudflared proxies the origin as a raw TCP stream instead of HTTP.
+// HTTP-only origin settings such as httpHostHeader do not apply to these origins.
+func (p OriginProtocol) IsStream() bool {
+	switch p {
+	case OriginProtocolTCP, OriginProtocolSSH, OriginProtocolRDP, OriginProtocolSMB:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsUnix reports whether the origin is a unix socket, which is addressed by a path instead of host and port.
+func (p OriginProtocol) IsUnix() bool {
+	return p == OriginProtocolUnix || p == OriginProtocolUnixTLS
+}
+
+// OriginURL returns the service URL of a tunnel ingress rule, e.g. "ssh://bastion.default.svc:22".
+func OriginURL(protocol OriginProtocol, host string, port int32) string {
+	return fmt.Sprintf("%s://%s:%d", protocol, host, port)
+}
+
+// ValidateOriginService checks that the service URL of a tunnel ingress rule can be used by cloudflared.
+func ValidateOriginService(service string) error {
+	if code, ok := strings.CutPrefix(service, "http_status:"); ok {
+		if n, err := strconv.Atoi(code); err != nil || n < 100 || n > 599 {
+			return fmt.Errorf("invalid HTTP status code: %s", code)
+		}
+		return nil
+	}
+	if service == "hello_world" || service == "bastion" {
+		return nil
+	}
+
+	protocol := OriginProtocolOf(service)
+	if !slices.Contains(OriginProtocols, protocol) {
+		return fmt.Errorf("%w: %s", ErrUnsupportedOriginProtocol, service)
+	}
+
+	if protocol.IsUnix() {
+		if path := strings.TrimPrefix(service, string(protocol)+":"); path == "" {
+			return fmt.Errorf("unix socket path is empty: %s", service)
+		}
+		return nil
+	}
+
+	u, err := url.Parse(service)
+	if err != nil {
+		return fmt.Errorf("invalid service URL: %w", err)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("service URL has no host: %s", service)
+	}
+	return nil
+}
@@ -8,19 +8,141 @@ import (
 	"k8s.io/utils/ptr"
 )
 
-func ToOriginRequestConfig(tunnelSettings cftv1beta1.CloudflareTunnelSettings, hostname string) *cloudflare.OriginRequestConfig {
+// ToOriginRequestConfig builds the origin settings of a rule from the tunnel settings.
+// Fields set in overrides take precedence over the tunnel settings.
+func ToOriginRequestConfig(tunnelSettings cftv1beta1.CloudflareTunnelSettings, hostname string, service string, overrides *cftv1beta1.OriginRequest) *cloudflare.OriginRequestConfig {
+	tunnelSettings = mergeOriginRequest(tunnelSettings, overrides)
+
+	// TCPやSSHなどのオリジンにはHTTP用の設定を渡さない
+	if OriginProtocolOf(service).IsStream() {
+		return &cloudflare.OriginRequestConfig{
+			ConnectTimeout:  ptr.To(cloudflare.TunnelDuration{Duration: time.Duration(tunnelSettings.ConnectTimeoutSeconds) * time.Second}),
+			TCPKeepAlive:    tunnelDuration(tunnelSettings.TCPKeepAliveSeconds),
+			NoHappyEyeballs: ptr.To(tunnelSettings.NoHappyEyeballs),
+			BastionMode:     ptr.To(tunnelSettings.BastionMode),
+			ProxyAddress:    nonZero(tunnelSettings.ProxyAddress),
+			ProxyPort:       proxyPort(tunnelSettings.ProxyPort),
+			ProxyType:       ptr.To(tunnelSettings.ProxyType),
+			IPRules:         ipRules(tunnelSettings.IPRules),
+		}
+	}
+
+	// PreserveHostHeaderの場合はHostヘッダを書き換えず、リクエストのものをそのまま渡す
+	var httpHostHeader *string
+	if !tunnelSettings.PreserveHostHeader {
+		httpHostHeader = ptr.To(hostname)
+	}
+	originServerName := hostname
+	if overrides != nil {
+		if overrides.HTTPHostHeader != nil {
+			httpHostHeader = overrides.HTTPHostHeader
+		}
+		originServerName = ptr.Deref(overrides.OriginServerName, originServerName)
+	}
+
 	return &cloudflare.OriginRequestConfig{
-		HTTPHostHeader:         ptr.To(hostname),
-		OriginServerName:       ptr.To(hostname),
+		HTTPHostHeader:         httpHostHeader,
+		OriginServerName:       ptr.To(originServerName),
 		CAPool:                 tunnelSettings.CAPool,
 		NoTLSVerify:            ptr.To(tunnelSettings.NoTLSVerify),
 		TLSTimeout:             ptr.To(cloudflare.TunnelDuration{Duration: time.Duration(tunnelSettings.TLSTimeoutSeconds) * time.Second}),
 		Http2Origin:            ptr.To(tunnelSettings.HTTP2Origin),
 		DisableChunkedEncoding: ptr.To(tunnelSettings.DisableChunkedEncoding),
 		ConnectTimeout:         ptr.To(cloudflare.TunnelDuration{Duration: time.Duration(tunnelSettings.ConnectTimeoutSeconds) * time.Second}),
+		TCPKeepAlive:           tunnelDuration(tunnelSettings.TCPKeepAliveSeconds),
 		NoHappyEyeballs:        ptr.To(tunnelSettings.NoHappyEyeballs),
+		BastionMode:            ptr.To(tunnelSettings.BastionMode),
+		ProxyAddress:           nonZero(tunnelSettings.ProxyAddress),
+		ProxyPort:              proxyPort(tunnelSettings.ProxyPort),
 		ProxyType:              ptr.To(tunnelSettings.ProxyType),
+		IPRules:                ipRules(tunnelSettings.IPRules),
 		KeepAliveTimeout:       ptr.To(cloudflare.TunnelDuration{Duration: time.Duration(tunnelSettings.KeepAliveTimeoutSeconds) * time.Second}),
 		KeepAliveConnections:   ptr.To(int(tunnelSettings.KeepAliveConnections)),
+		Access:                 accessConfig(tunnelSettings.Access),
+	}
+}
+
+func mergeOriginRequest(tunnelSettings cftv1beta1.CloudflareTunnelSettings, overrides *cftv1beta1.OriginRequest) cftv1beta1.CloudflareTunnelSettings {
+	if overrides == nil {
+		return tunnelSettings
+	}
+
+	if overrides.CAPool != nil {
+		tunnelSettings.CAPool = overrides.CAPool
+	}
+	tunnelSettings.NoTLSVerify = ptr.Deref(overrides.NoTLSVerify, tunnelSettings.NoTLSVerify)
+	tunnelSettings.TLSTimeoutSeconds = ptr.Deref(overrides.TLSTimeoutSeconds, tunnelSettings.TLSTimeoutSeconds)
+	tunnelSettings.HTTP2Origin = ptr.Deref(overrides.HTTP2Origin, tunnelSettings.HTTP2Origin)
+	tunnelSettings.DisableChunkedEncoding = ptr.Deref(overrides.DisableChunkedEncoding, tunnelSettings.DisableChunkedEncoding)
+	tunnelSettings.ConnectTimeoutSeconds = ptr.Deref(overrides.ConnectTimeoutSeconds, tunnelSettings.ConnectTimeoutSeconds)
+	tunnelSettings.NoHappyEyeballs = ptr.Deref(overrides.NoHappyEyeballs, tunnelSettings.NoHappyEyeballs)
+	tunnelSettings.ProxyType = ptr.Deref(overrides.ProxyType, tunnelSettings.ProxyType)
+	tunnelSettings.KeepAliveTimeoutSeconds = ptr.Deref(overrides.KeepAliveTimeoutSeconds, tunnelSettings.KeepAliveTimeoutSeconds)
+	tunnelSettings.KeepAliveConnections = ptr.Deref(overrides.KeepAliveConnections, tunnelSettings.KeepAliveConnections)
+	tunnelSettings.TCPKeepAliveSeconds = ptr.Deref(overrides.TCPKeepAliveSeconds, tunnelSettings.TCPKeepAliveSeconds)
+	tunnelSettings.PreserveHostHeader = ptr.Deref(overrides.PreserveHostHeader, tunnelSettings.PreserveHostHeader)
+	tunnelSettings.BastionMode = ptr.Deref(overrides.BastionMode, tunnelSettings.BastionMode)
+	tunnelSettings.ProxyAddress = ptr.Deref(overrides.ProxyAddress, tunnelSettings.ProxyAddress)
+	tunnelSettings.ProxyPort = ptr.Deref(overrides.ProxyPort, tunnelSettings.ProxyPort)
+	if overrides.IPRules != nil {
+		tunnelSettings.IPRules = overrides.IPRules
+	}
+	if overrides.Access != nil {
+		tunnelSettings.Access = overrides.Access
+	}
+	return tunnelSettings
+}
+
+// tunnelDuration returns nil for zero, so that cloudflared uses its default.
+func tunnelDuration(seconds int32) *cloudflare.TunnelDuration {
+	if seconds == 0 {
+		return nil
+	}
+	return &cloudflare.TunnelDuration{Duration: time.Duration(seconds) * time.Second}
+}
+
+func nonZero[T comparable](v T) *T {
+	var zero T
+	if v == zero {
+		return nil
+	}
+	return &v
+}
+
+func proxyPort(port int32) *uint {
+	if port <= 0 {
+		return nil
+	}
+	return ptr.To(uint(port))
+}
+
+func ipRules(rules []cftv1beta1.IPRule) []cloudflare.IngressIPRule {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	result := make([]cloudflare.IngressIPRule, 0, len(rules))
+	for _, rule := range rules {
+		var ports []int
+		for _, port := range rule.Ports {
+			ports = append(ports, int(port))
+		}
+		result = append(result, cloudflare.IngressIPRule{
+			Prefix: ptr.To(rule.Prefix),
+			Ports:  ports,
+			Allow:  rule.Allow,
+		})
+	}
+	return result
+}
+
+func accessConfig(access *cftv1beta1.AccessConfig) *cloudflare.AccessConfig {
+	if access == nil {
+		return nil
+	}
+	return &cloudflare.AccessConfig{
+		Required: access.Required,
+		TeamName: access.TeamName,
+		AudTag:   access.AudTag,
 	}
 }
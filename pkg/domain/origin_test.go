@@ -0,0 +1,162 @@
+package domain
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	cftv1beta1 "github.com/walnuts1018/cloudflare-tunnel-operator/api/v1beta1"
+	"k8s.io/utils/ptr"
+)
+
+func TestParseOriginProtocol(t *testing.T) {
+	tests := []struct {
+		name    string
+		v       string
+		want    OriginProtocol
+		wantErr error
+	}{
+		{name: "ssh", v: "ssh", want: OriginProtocolSSH},
+		{name: "case insensitive", v: " RDP ", want: OriginProtocolRDP},
+		{name: "unix+tls", v: "unix+tls", want: OriginProtocolUnixTLS},
+		{name: "unsupported", v: "udp", wantErr: ErrUnsupportedOriginProtocol},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseOriginProtocol(tt.v)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseOriginProtocol() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseOriginProtocol() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateOriginService(t *testing.T) {
+	tests := []struct {
+		service string
+		wantErr bool
+	}{
+		{service: "http://web.default.svc:80"},
+		{service: "ssh://bastion.default.svc:22"},
+		{service: "unix:/run/web.sock"},
+		{service: "unix+tls:/run/web.sock"},
+		{service: "http_status:404"},
+		{service: "hello_world"},
+		{service: "bastion"},
+		{service: "unix:", wantErr: true},
+		{service: "http_status:999", wantErr: true},
+		{service: "udp://dns.default.svc:53", wantErr: true},
+		{service: "http://", wantErr: true},
+		{service: "web.default.svc:80", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.service, func(t *testing.T) {
+			if err := ValidateOriginService(tt.service); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateOriginService() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestToOriginRequestConfig(t *testing.T) {
+	settings := cftv1beta1.CloudflareTunnelSettings{
+		ConnectTimeoutSeconds: 30,
+		ProxyType:             "socks",
+	}
+
+	tests := []struct {
+		name           string
+		service        string
+		wantHostHeader bool
+	}{
+		{name: "http", service: "http://web.default.svc:80", wantHostHeader: true},
+		{name: "unix socket", service: "unix:/run/web.sock", wantHostHeader: true},
+		{name: "ssh", service: "ssh://bastion.default.svc:22", wantHostHeader: false},
+		{name: "tcp", service: "tcp://db.default.svc:5432", wantHostHeader: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ToOriginRequestConfig(settings, "example.walnuts.dev", tt.service, nil)
+			if (got.HTTPHostHeader != nil) != tt.wantHostHeader {
+				t.Errorf("ToOriginRequestConfig().HTTPHostHeader = %v, want set = %v", got.HTTPHostHeader, tt.wantHostHeader)
+			}
+			if got.ProxyType == nil || *got.ProxyType != "socks" {
+				t.Errorf("ToOriginRequestConfig().ProxyType = %v, want socks", got.ProxyType)
+			}
+		})
+	}
+
+	t.Run("overrides", func(t *testing.T) {
+		got := ToOriginRequestConfig(settings, "example.walnuts.dev", "https://legacy.default.svc:443", &cftv1beta1.OriginRequest{
+			HTTPHostHeader:        ptr.To("legacy.internal"),
+			NoTLSVerify:           ptr.To(true),
+			ConnectTimeoutSeconds: ptr.To(int32(120)),
+		})
+		if *got.HTTPHostHeader != "legacy.internal" {
+			t.Errorf("ToOriginRequestConfig().HTTPHostHeader = %v, want legacy.internal", *got.HTTPHostHeader)
+		}
+		if *got.OriginServerName != "example.walnuts.dev" {
+			t.Errorf("ToOriginRequestConfig().OriginServerName = %v, want example.walnuts.dev", *got.OriginServerName)
+		}
+		if !*got.NoTLSVerify {
+			t.Errorf("ToOriginRequestConfig().NoTLSVerify = false, want true")
+		}
+		if got.ConnectTimeout.Duration != 120*time.Second {
+			t.Errorf("ToOriginRequestConfig().ConnectTimeout = %v, want 2m", got.ConnectTimeout.Duration)
+		}
+	})
+
+	t.Run("preserve host header", func(t *testing.T) {
+		settings := settings
+		settings.PreserveHostHeader = true
+
+		got := ToOriginRequestConfig(settings, "*.walnuts.dev", "https://web.default.svc:443", nil)
+		if got.HTTPHostHeader != nil {
+			t.Errorf("ToOriginRequestConfig().HTTPHostHeader = %v, want nil", *got.HTTPHostHeader)
+		}
+		if *got.OriginServerName != "*.walnuts.dev" {
+			t.Errorf("ToOriginRequestConfig().OriginServerName = %v, want *.walnuts.dev", *got.OriginServerName)
+		}
+
+		got = ToOriginRequestConfig(settings, "*.walnuts.dev", "https://web.default.svc:443", &cftv1beta1.OriginRequest{
+			HTTPHostHeader: ptr.To("web.internal"),
+		})
+		if got.HTTPHostHeader == nil || *got.HTTPHostHeader != "web.internal" {
+			t.Errorf("ToOriginRequestConfig().HTTPHostHeader = %v, want web.internal", got.HTTPHostHeader)
+		}
+	})
+
+	t.Run("access and proxy", func(t *testing.T) {
+		settings := settings
+		settings.TCPKeepAliveSeconds = 30
+		settings.Access = &cftv1beta1.AccessConfig{TeamName: "walnuts", AudTag: []string{"aud"}}
+		settings.IPRules = []cftv1beta1.IPRule{{Prefix: "10.0.0.0/8", Ports: []int32{22}, Allow: true}}
+
+		got := ToOriginRequestConfig(settings, "example.walnuts.dev", "http://web.default.svc:80", &cftv1beta1.OriginRequest{
+			Access: &cftv1beta1.AccessConfig{Required: true, TeamName: "walnuts", AudTag: []string{"web"}},
+		})
+		if got.Access == nil || !got.Access.Required || got.Access.AudTag[0] != "web" {
+			t.Errorf("ToOriginRequestConfig().Access = %+v, want the override", got.Access)
+		}
+		if got.TCPKeepAlive == nil || got.TCPKeepAlive.Duration != 30*time.Second {
+			t.Errorf("ToOriginRequestConfig().TCPKeepAlive = %v, want 30s", got.TCPKeepAlive)
+		}
+
+		got = ToOriginRequestConfig(settings, "ssh.walnuts.dev", "ssh://bastion.default.svc:22", &cftv1beta1.OriginRequest{
+			ProxyAddress: ptr.To("127.0.0.1"),
+			ProxyPort:    ptr.To(int32(1080)),
+		})
+		if got.Access != nil {
+			t.Errorf("ToOriginRequestConfig().Access = %+v, want nil for ssh", got.Access)
+		}
+		if got.ProxyAddress == nil || *got.ProxyAddress != "127.0.0.1" || got.ProxyPort == nil || *got.ProxyPort != 1080 {
+			t.Errorf("ToOriginRequestConfig() proxy = %v:%v, want 127.0.0.1:1080", got.ProxyAddress, got.ProxyPort)
+		}
+		if len(got.IPRules) != 1 || *got.IPRules[0].Prefix != "10.0.0.0/8" || got.IPRules[0].Ports[0] != 22 || !got.IPRules[0].Allow {
+			t.Errorf("ToOriginRequestConfig().IPRules = %+v, want the tunnel settings", got.IPRules)
+		}
+	})
+}
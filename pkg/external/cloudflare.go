@@ -3,38 +3,57 @@ package external
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"time"
 
 	cloudflare "github.com/cloudflare/cloudflare-go"
 	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/domain"
 	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/utils/random"
+	"golang.org/x/time/rate"
 	"k8s.io/utils/ptr"
 )
 
 type CloudflareTunnelClient struct {
 	client    *cloudflare.API
 	accountId string
-	zoneID    string
+	zones     *zoneCache
 	random    random.Random
 }
 
-func NewCloudflareTunnelClient(apiToken string, accountId string, zoneID string, random random.Random) (*CloudflareTunnelClient, error) {
-	client, err := cloudflare.NewWithAPIToken(apiToken)
+// NewCloudflareTunnelClient returns a client that sends at most rateLimit requests per second,
+// and retries the requests that are rate limited or failed on the server side.
+// If baseURL is empty, the client talks to the Cloudflare API.
+func NewCloudflareTunnelClient(apiToken string, accountId string, baseURL string, zoneRefreshInterval time.Duration, rateLimit float64, random random.Random) (*CloudflareTunnelClient, error) {
+	opts := []cloudflare.Option{
+		cloudflare.HTTPClient(&http.Client{Transport: newRetryTransport(http.DefaultTransport, rateLimit)}),
+		// レート制限とリトライはretryTransportで行う
+		cloudflare.UsingRateLimit(float64(rate.Inf)),
+		cloudflare.UsingRetryPolicy(0, 0, 0),
+	}
+	if baseURL != "" {
+		opts = append(opts, cloudflare.BaseURL(baseURL))
+	}
+
+	client, err := cloudflare.NewWithAPIToken(apiToken, opts...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize cloudflare provider: %v", err)
+		return nil, fmt.Errorf("failed to initialize cloudflare provider: %w", err)
 	}
 	return &CloudflareTunnelClient{
 		client:    client,
 		accountId: accountId,
-		zoneID:    zoneID,
-		random:    random,
+		zones: &zoneCache{
+			refreshInterval: zoneRefreshInterval,
+		},
+		random: random,
 	}, nil
 }
 
 func (c *CloudflareTunnelClient) CreateTunnel(ctx context.Context, name string) (domain.CloudflareTunnel, error) {
 	secret, err := c.random.SecureString(32, random.Alphanumeric)
 	if err != nil {
-		return domain.CloudflareTunnel{}, fmt.Errorf("failed to generate secret: %v", err)
+		return domain.CloudflareTunnel{}, fmt.Errorf("failed to generate secret: %w", err)
 	}
 
 	t, err := c.client.CreateTunnel(ctx, cloudflare.AccountIdentifier(c.accountId), cloudflare.TunnelCreateParams{
@@ -43,18 +62,32 @@ func (c *CloudflareTunnelClient) CreateTunnel(ctx context.Context, name string)
 		Secret:    secret,
 	})
 	if err != nil {
-		return domain.CloudflareTunnel{}, fmt.Errorf("failed to create tunnel: %v", err)
+		return domain.CloudflareTunnel{}, fmt.Errorf("failed to create tunnel: %w", err)
 	}
 
-	return domain.CloudflareTunnel{
-		ID:   t.ID,
-		Name: t.Name,
-	}, nil
+	return toDomainTunnel(t), nil
+}
+
+// RotateTunnelSecret replaces the secret of the tunnel with a new one, so that the tokens issued before are no longer accepted for new connections.
+// The connections that are already established are kept.
+func (c *CloudflareTunnelClient) RotateTunnelSecret(ctx context.Context, tunnelID string) error {
+	secret, err := c.random.SecureString(32, random.Alphanumeric)
+	if err != nil {
+		return fmt.Errorf("failed to generate secret: %w", err)
+	}
+
+	// cloudflare-goのUpdateTunnelはURLにトンネルIDを含めないので、直接リクエストする
+	if _, err := c.client.Raw(ctx, http.MethodPatch, fmt.Sprintf("/accounts/%s/cfd_tunnel/%s", c.accountId, tunnelID), cloudflare.TunnelUpdateParams{
+		Secret: secret,
+	}, nil); err != nil {
+		return fmt.Errorf("failed to rotate tunnel secret: %w", tunnelError(err))
+	}
+	return nil
 }
 
 func (c *CloudflareTunnelClient) DeleteTunnel(ctx context.Context, id string) error {
 	if err := c.client.DeleteTunnel(ctx, cloudflare.AccountIdentifier(c.accountId), id); err != nil {
-		return fmt.Errorf("failed to delete tunnel: %v", err)
+		return fmt.Errorf("failed to delete tunnel: %w", tunnelError(err))
 	}
 	return nil
 }
@@ -62,7 +95,7 @@ func (c *CloudflareTunnelClient) DeleteTunnel(ctx context.Context, id string) er
 func (c *CloudflareTunnelClient) GetTunnelToken(ctx context.Context, tunnelID string) (domain.CloudflareTunnelToken, error) {
 	t, err := c.client.GetTunnelToken(ctx, cloudflare.AccountIdentifier(c.accountId), tunnelID)
 	if err != nil {
-		return "", fmt.Errorf("failed to get tunnel token: %v", err)
+		return "", fmt.Errorf("failed to get tunnel token: %w", tunnelError(err))
 	}
 	return domain.CloudflareTunnelToken(t), nil
 }
@@ -70,13 +103,77 @@ func (c *CloudflareTunnelClient) GetTunnelToken(ctx context.Context, tunnelID st
 func (c *CloudflareTunnelClient) GetTunnel(ctx context.Context, id string) (domain.CloudflareTunnel, error) {
 	t, err := c.client.GetTunnel(ctx, cloudflare.AccountIdentifier(c.accountId), id)
 	if err != nil {
-		return domain.CloudflareTunnel{}, fmt.Errorf("failed to get tunnel: %v", err)
+		return domain.CloudflareTunnel{}, fmt.Errorf("failed to get tunnel: %w", tunnelError(err))
+	}
+
+	return toDomainTunnel(t), nil
+}
+
+// FindTunnelByName returns the tunnel that is not deleted and has the given name.
+// If no tunnel is found, it returns an empty CloudflareTunnel.
+func (c *CloudflareTunnelClient) FindTunnelByName(ctx context.Context, name string) (domain.CloudflareTunnel, error) {
+	tunnels, _, err := c.client.ListTunnels(ctx, cloudflare.AccountIdentifier(c.accountId), cloudflare.TunnelListParams{
+		Name:      name,
+		IsDeleted: ptr.To(false),
+	})
+	if err != nil {
+		return domain.CloudflareTunnel{}, fmt.Errorf("failed to list tunnels: %w", err)
+	}
+
+	switch len(tunnels) {
+	case 0:
+		return domain.CloudflareTunnel{}, nil
+	case 1:
+		return toDomainTunnel(tunnels[0]), nil
+	default:
+		return domain.CloudflareTunnel{}, fmt.Errorf("multiple tunnels found with name %s", name)
+	}
+}
+
+// ListTunnelConnectors returns the cloudflared connectors that are currently registered to the tunnel.
+func (c *CloudflareTunnelClient) ListTunnelConnectors(ctx context.Context, tunnelID string) ([]domain.TunnelConnector, error) {
+	connections, err := c.client.ListTunnelConnections(ctx, cloudflare.AccountIdentifier(c.accountId), tunnelID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tunnel connections: %w", tunnelError(err))
 	}
 
+	connectors := make([]domain.TunnelConnector, 0, len(connections))
+	for _, connection := range connections {
+		connector := domain.TunnelConnector{
+			ID:          connection.ID,
+			Version:     connection.Version,
+			Arch:        connection.Arch,
+			Connections: make([]domain.TunnelConnection, 0, len(connection.Connections)),
+		}
+		for _, conn := range connection.Connections {
+			connector.Connections = append(connector.Connections, domain.TunnelConnection{
+				ID:               conn.ID,
+				Colo:             conn.ColoName,
+				OriginIP:         conn.OriginIP,
+				PendingReconnect: conn.IsPendingReconnect,
+			})
+		}
+		connectors = append(connectors, connector)
+	}
+	return connectors, nil
+}
+
+// tunnelError maps the 404 error of the tunnel APIs to domain.ErrTunnelNotFound.
+func tunnelError(err error) error {
+	var notFound *cloudflare.NotFoundError
+	if errors.As(err, &notFound) {
+		return fmt.Errorf("%w: %w", domain.ErrTunnelNotFound, err)
+	}
+	return err
+}
+
+func toDomainTunnel(t cloudflare.Tunnel) domain.CloudflareTunnel {
 	return domain.CloudflareTunnel{
-		ID:   t.ID,
-		Name: t.Name,
-	}, nil
+		ID:           t.ID,
+		Name:         t.Name,
+		RemoteConfig: t.RemoteConfig,
+		Deleted:      t.DeletedAt != nil,
+	}
 }
 
 func (c *CloudflareTunnelClient) DeleteToken(ctx context.Context, tunnelID string) error {
@@ -86,20 +183,54 @@ func (c *CloudflareTunnelClient) DeleteToken(ctx context.Context, tunnelID strin
 func (c *CloudflareTunnelClient) GetTunnelConfiguration(ctx context.Context, tunnelID string) (domain.TunnelConfiguration, error) {
 	result, err := c.client.GetTunnelConfiguration(ctx, cloudflare.AccountIdentifier(c.accountId), tunnelID)
 	if err != nil {
-		return domain.TunnelConfiguration{}, fmt.Errorf("failed to get tunnel configs: %v", err)
+		return domain.TunnelConfiguration{}, fmt.Errorf("failed to get tunnel configs: %w", tunnelError(err))
 	}
-	return domain.TunnelConfiguration(result.Config), nil
+	return domain.TunnelConfiguration{
+		Ingress:       result.Config.Ingress,
+		WarpRouting:   result.Config.WarpRouting,
+		OriginRequest: result.Config.OriginRequest,
+		Version:       result.Version,
+	}, nil
 }
 
-func (c *CloudflareTunnelClient) UpdateTunnelConfiguration(ctx context.Context, tunnelID string, config domain.TunnelConfiguration) error {
-	_, err := c.client.UpdateTunnelConfiguration(ctx, cloudflare.AccountIdentifier(c.accountId), cloudflare.TunnelConfigurationParams{
+// UpdateTunnelConfiguration updates the tunnel configuration and returns the configuration that Cloudflare stored, with its new version.
+// If config.Version is set and the configuration in Cloudflare has another version, domain.ErrConfigurationConflict is returned.
+// It is also returned when the version stored by the update shows that another update was made after the check.
+func (c *CloudflareTunnelClient) UpdateTunnelConfiguration(ctx context.Context, tunnelID string, config domain.TunnelConfiguration) (domain.TunnelConfiguration, error) {
+	// APIには条件付き更新がないので、書き込む直前にバージョンを確認する
+	if config.Version != 0 {
+		current, err := c.client.GetTunnelConfiguration(ctx, cloudflare.AccountIdentifier(c.accountId), tunnelID)
+		if err != nil {
+			return domain.TunnelConfiguration{}, fmt.Errorf("failed to get tunnel configs: %w", tunnelError(err))
+		}
+		if current.Version != config.Version {
+			return domain.TunnelConfiguration{}, fmt.Errorf("%w: read version %d, current version %d", domain.ErrConfigurationConflict, config.Version, current.Version)
+		}
+	}
+
+	result, err := c.client.UpdateTunnelConfiguration(ctx, cloudflare.AccountIdentifier(c.accountId), cloudflare.TunnelConfigurationParams{
 		TunnelID: tunnelID,
-		Config:   cloudflare.TunnelConfiguration(config),
+		Config: cloudflare.TunnelConfiguration{
+			Ingress:       config.Ingress,
+			WarpRouting:   config.WarpRouting,
+			OriginRequest: config.OriginRequest,
+		},
 	})
 	if err != nil {
-		return fmt.Errorf("failed to update tunnel configs: %v", err)
+		return domain.TunnelConfiguration{}, fmt.Errorf("failed to update tunnel configs: %w", tunnelError(err))
 	}
-	return nil
+
+	updated := domain.TunnelConfiguration{
+		Ingress:       result.Config.Ingress,
+		WarpRouting:   result.Config.WarpRouting,
+		OriginRequest: result.Config.OriginRequest,
+		Version:       result.Version,
+	}
+	// 確認と書き込みの間に別の更新があった場合は、読み直して反映し直せるようにする
+	if config.Version != 0 && updated.Version != config.Version+1 {
+		return updated, fmt.Errorf("%w: read version %d, stored version %d", domain.ErrConfigurationConflict, config.Version, updated.Version)
+	}
+	return updated, nil
 }
 
 const managedBy = "cloudflare-tunnel-operator"
@@ -109,35 +240,35 @@ type DNSComment struct {
 	TunnelID  string `json:"tunnelID"`
 }
 
-func (c *CloudflareTunnelClient) AddDNS(ctx context.Context, tunnelID string, hostname string) error {
+func (c *CloudflareTunnelClient) AddDNS(ctx context.Context, zoneID string, tunnelID string, hostname string) error {
 	comment, err := json.Marshal(DNSComment{
 		ManagedBy: managedBy,
 		TunnelID:  tunnelID,
 	})
 	if err != nil {
-		return fmt.Errorf("failed to generate comment: %v", err)
+		return fmt.Errorf("failed to generate comment: %w", err)
 	}
 
-	if _, err := c.client.CreateDNSRecord(ctx, cloudflare.ZoneIdentifier(c.zoneID), cloudflare.CreateDNSRecordParams{
+	if _, err := c.client.CreateDNSRecord(ctx, cloudflare.ZoneIdentifier(zoneID), cloudflare.CreateDNSRecordParams{
 		Name:    hostname,
 		TTL:     1, // auto
 		Proxied: ptr.To(true),
 		Type:    "CNAME",
-		Content: fmt.Sprintf("%v.cfargotunnel.com", tunnelID),
+		Content: domain.TunnelCNAME(tunnelID),
 		Comment: string(comment),
 	}); err != nil {
-		return fmt.Errorf("failed to create DNS record: %v", err)
+		return fmt.Errorf("failed to create DNS record: %w", err)
 	}
 	return nil
 }
 
-func (c *CloudflareTunnelClient) GetDNS(ctx context.Context, tunnelID string, hostname string) (domain.DNSRecord, error) {
-	records, _, err := c.client.ListDNSRecords(ctx, cloudflare.ZoneIdentifier(c.zoneID), cloudflare.ListDNSRecordsParams{
+func (c *CloudflareTunnelClient) GetDNS(ctx context.Context, zoneID string, tunnelID string, hostname string) (domain.DNSRecord, error) {
+	records, _, err := c.client.ListDNSRecords(ctx, cloudflare.ZoneIdentifier(zoneID), cloudflare.ListDNSRecordsParams{
 		Name: hostname,
 		Type: "CNAME",
 	})
 	if err != nil {
-		return domain.DNSRecord{}, fmt.Errorf("failed to get DNS record: %v", err)
+		return domain.DNSRecord{}, fmt.Errorf("failed to get DNS record: %w", err)
 	}
 
 	if len(records) == 0 {
@@ -147,60 +278,63 @@ func (c *CloudflareTunnelClient) GetDNS(ctx context.Context, tunnelID string, ho
 	return domain.DNSRecord(records[0]), nil
 }
 
-func (c *CloudflareTunnelClient) UpdateDNS(ctx context.Context, tunnelID string, hostname string, current domain.DNSRecord) error {
+func (c *CloudflareTunnelClient) UpdateDNS(ctx context.Context, zoneID string, tunnelID string, hostname string, current domain.DNSRecord) error {
 	comment, err := json.Marshal(DNSComment{
 		ManagedBy: managedBy,
 		TunnelID:  tunnelID,
 	})
 	if err != nil {
-		return fmt.Errorf("failed to generate comment: %v", err)
+		return fmt.Errorf("failed to generate comment: %w", err)
 	}
 
-	if _, err := c.client.UpdateDNSRecord(ctx, cloudflare.ZoneIdentifier(c.zoneID), cloudflare.UpdateDNSRecordParams{
+	if _, err := c.client.UpdateDNSRecord(ctx, cloudflare.ZoneIdentifier(zoneID), cloudflare.UpdateDNSRecordParams{
 		ID:      current.ID,
 		Name:    hostname,
 		TTL:     1, // auto
 		Proxied: ptr.To(true),
 		Type:    "CNAME",
 		Comment: ptr.To(string(comment)),
-		Content: fmt.Sprintf("%v.cfargotunnel.com", tunnelID),
+		Content: domain.TunnelCNAME(tunnelID),
 	}); err != nil {
-		return fmt.Errorf("failed to update DNS record: %v", err)
+		return fmt.Errorf("failed to update DNS record: %w", err)
 	}
 	return nil
 }
 
-func (c *CloudflareTunnelClient) DeleteDNS(ctx context.Context, tunnelID string, recordID string) error {
-	if err := c.client.DeleteDNSRecord(ctx, cloudflare.ZoneIdentifier(c.zoneID), recordID); err != nil {
-		return fmt.Errorf("failed to delete DNS record: %v", err)
+func (c *CloudflareTunnelClient) DeleteDNS(ctx context.Context, zoneID string, recordID string) error {
+	if err := c.client.DeleteDNSRecord(ctx, cloudflare.ZoneIdentifier(zoneID), recordID); err != nil {
+		return fmt.Errorf("failed to delete DNS record: %w", err)
 	}
 	return nil
 }
 
-func (c *CloudflareTunnelClient) DeleteAllDNS(ctx context.Context, tunnelID string) error {
+func (c *CloudflareTunnelClient) DeleteAllDNS(ctx context.Context, tunnelID string, allowedZones []string) error {
 	comment, err := json.Marshal(DNSComment{
 		ManagedBy: managedBy,
 		TunnelID:  tunnelID,
 	})
 	if err != nil {
-		return fmt.Errorf("failed to generate comment: %v", err)
+		return fmt.Errorf("failed to generate comment: %w", err)
 	}
 
-	records, _, err := c.client.ListDNSRecords(ctx, cloudflare.ZoneIdentifier(c.zoneID), cloudflare.ListDNSRecordsParams{
-		Type:    "CNAME",
-		Comment: string(comment),
-	})
+	zones, err := c.ListZones(ctx, allowedZones)
 	if err != nil {
-		return fmt.Errorf("failed to get DNS record: %v", err)
+		return fmt.Errorf("failed to list zones: %w", err)
 	}
 
-	if len(records) == 0 {
-		return nil
-	}
+	for _, zone := range zones {
+		records, _, err := c.client.ListDNSRecords(ctx, cloudflare.ZoneIdentifier(zone.ID), cloudflare.ListDNSRecordsParams{
+			Type:    "CNAME",
+			Comment: string(comment),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to get DNS record: %w", err)
+		}
 
-	for _, record := range records {
-		if err := c.client.DeleteDNSRecord(ctx, cloudflare.ZoneIdentifier(c.zoneID), record.ID); err != nil {
-			return fmt.Errorf("failed to update tunnel configs: %v", err)
+		for _, record := range records {
+			if err := c.client.DeleteDNSRecord(ctx, cloudflare.ZoneIdentifier(zone.ID), record.ID); err != nil {
+				return fmt.Errorf("failed to delete DNS record: %w", err)
+			}
 		}
 	}
 	return nil
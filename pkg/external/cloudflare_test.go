@@ -2,13 +2,44 @@ package external
 
 import (
 	"context"
+	"net/http"
 	"os"
+	"time"
 
+	cloudflare "github.com/cloudflare/cloudflare-go"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/domain"
+	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/external/fake"
 	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/utils/random"
+	"golang.org/x/time/rate"
 )
 
+const testAccountID = "account-id"
+
+// newFakeClient starts a fake Cloudflare API server and returns a client connected to it, which retries without waiting.
+func newFakeClient() (*CloudflareTunnelClient, *fake.Server) {
+	server := fake.NewServer(testAccountID)
+	DeferCleanup(server.Close)
+
+	transport := newRetryTransport(http.DefaultTransport, 1000)
+	transport.sleep = func(*http.Request, time.Duration) error { return nil }
+	api, err := cloudflare.NewWithAPIToken("token",
+		cloudflare.BaseURL(server.URL),
+		cloudflare.HTTPClient(&http.Client{Transport: transport}),
+		cloudflare.UsingRateLimit(float64(rate.Inf)),
+		cloudflare.UsingRetryPolicy(0, 0, 0),
+	)
+	Expect(err).NotTo(HaveOccurred())
+
+	return &CloudflareTunnelClient{
+		client:    api,
+		accountId: testAccountID,
+		zones:     &zoneCache{refreshInterval: DefaultZoneRefreshInterval},
+		random:    random.NewDummy(),
+	}, server
+}
+
 var _ = Describe("Cloudflare", func() {
 	Context("Default", func() {
 		ctx := context.Background()
@@ -16,22 +47,24 @@ var _ = Describe("Cloudflare", func() {
 		var client *CloudflareTunnelClient
 
 		BeforeEach(func() {
+			// 認証情報がない場合はfakeサーバーに対して実行する
 			apiToken, ok := os.LookupEnv("CLOUDFLARE_API_TOKEN")
 			if !ok {
-				Fail("CLOUDFLARE_API_TOKEN is not set")
+				server := fake.NewServer(testAccountID)
+				DeferCleanup(server.Close)
+
+				var err error
+				client, err = NewCloudflareTunnelClient("token", testAccountID, server.URL, DefaultZoneRefreshInterval, DefaultRateLimit, random.NewDummy())
+				Expect(err).NotTo(HaveOccurred())
+				return
 			}
 			accountId, ok := os.LookupEnv("CLOUDFLARE_ACCOUNT_ID")
 			if !ok {
 				Fail("CLOUDFLARE_ACCOUNT_ID is not set")
 			}
 
-			zoneID, ok := os.LookupEnv("CLOUDFLARE_ZONE_ID")
-			if !ok {
-				Fail("CLOUDFLARE_ZONE_ID is not set")
-			}
-
 			var err error
-			client, err = NewCloudflareTunnelClient(apiToken, accountId, zoneID, random.NewDummy())
+			client, err = NewCloudflareTunnelClient(apiToken, accountId, "", DefaultZoneRefreshInterval, DefaultRateLimit, random.NewDummy())
 			Expect(err).NotTo(HaveOccurred())
 		})
 
@@ -51,6 +84,195 @@ var _ = Describe("Cloudflare", func() {
 			token, err := client.GetTunnelToken(ctx, tunnel.ID)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(token).NotTo(BeEmpty())
+
+			By("Find Tunnel By Name")
+			found, err := client.FindTunnelByName(ctx, tunnel.Name)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found.ID).To(Equal(tunnel.ID))
+
+			By("Delete Tunnel")
+			Expect(client.DeleteTunnel(ctx, tunnel.ID)).To(Succeed())
+			gotTunnel, err = client.GetTunnel(ctx, tunnel.ID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(gotTunnel.Deleted).To(BeTrue())
+		})
+	})
+
+	Context("Fake", func() {
+		ctx := context.Background()
+
+		var client *CloudflareTunnelClient
+		var server *fake.Server
+
+		BeforeEach(func() {
+			client, server = newFakeClient()
+		})
+
+		It("updates the tunnel configuration", func() {
+			tunnel, err := client.CreateTunnel(ctx, "test")
+			Expect(err).NotTo(HaveOccurred())
+
+			config, err := client.GetTunnelConfiguration(ctx, tunnel.ID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.Ingress).To(BeEmpty())
+
+			config.Ingress = []cloudflare.UnvalidatedIngressRule{
+				{Hostname: "a.example.com", Service: "http://a.default.svc:80"},
+				{Service: "http_status:404"},
+			}
+			updated, err := client.UpdateTunnelConfiguration(ctx, tunnel.ID, config)
+			Expect(err).NotTo(HaveOccurred())
+
+			got, version, ok := server.TunnelConfiguration(tunnel.ID)
+			Expect(ok).To(BeTrue())
+			Expect(got.Ingress).To(Equal(config.Ingress))
+			Expect(version).To(Equal(1))
+
+			By("returning the version of the stored configuration")
+			Expect(updated.Ingress).To(Equal(config.Ingress))
+			Expect(updated.Version).To(Equal(version))
+
+			By("detecting the update from outside of the client")
+			config, err = client.GetTunnelConfiguration(ctx, tunnel.ID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(server.SetTunnelConfiguration(tunnel.ID, cloudflare.TunnelConfiguration{})).To(BeTrue())
+			_, err = client.UpdateTunnelConfiguration(ctx, tunnel.ID, config)
+			Expect(err).To(MatchError(domain.ErrConfigurationConflict))
+		})
+
+		It("rotates the tunnel secret", func() {
+			tunnel := server.CreateTunnel("test")
+			before, err := client.GetTunnelToken(ctx, tunnel.ID)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(client.RotateTunnelSecret(ctx, tunnel.ID)).To(Succeed())
+			after, err := client.GetTunnelToken(ctx, tunnel.ID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(after).NotTo(Equal(before))
+
+			Expect(server.DeleteTunnel(tunnel.ID)).To(BeTrue())
+			Expect(client.RotateTunnelSecret(ctx, tunnel.ID)).To(MatchError(domain.ErrTunnelNotFound))
+		})
+
+		It("lists the connectors of the tunnel", func() {
+			tunnel := server.CreateTunnel("test")
+			connectors, err := client.ListTunnelConnectors(ctx, tunnel.ID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(connectors).To(BeEmpty())
+
+			Expect(server.SetConnections(tunnel.ID, []cloudflare.Connection{{
+				ID:      "connector-a",
+				Version: "2025.11.1",
+				Arch:    "linux_amd64",
+				Connections: []cloudflare.TunnelConnection{
+					{ID: "conn-1", ColoName: "nrt01", OriginIP: "192.0.2.1"},
+					{ID: "conn-2", ColoName: "kix01", OriginIP: "192.0.2.1", IsPendingReconnect: true},
+				},
+			}})).To(BeTrue())
+
+			connectors, err = client.ListTunnelConnectors(ctx, tunnel.ID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(connectors).To(Equal([]domain.TunnelConnector{{
+				ID:      "connector-a",
+				Version: "2025.11.1",
+				Arch:    "linux_amd64",
+				Connections: []domain.TunnelConnection{
+					{ID: "conn-1", Colo: "nrt01", OriginIP: "192.0.2.1"},
+					{ID: "conn-2", Colo: "kix01", OriginIP: "192.0.2.1", PendingReconnect: true},
+				},
+			}}))
+			Expect(connectors[0].ActiveConnections()).To(Equal(1))
+
+			Expect(server.DeleteTunnel(tunnel.ID)).To(BeTrue())
+			_, err = client.ListTunnelConnectors(ctx, tunnel.ID)
+			Expect(err).To(MatchError(domain.ErrTunnelNotFound))
+		})
+
+		It("returns ErrTunnelNotFound for a deleted tunnel", func() {
+			tunnel := server.CreateTunnel("test")
+			Expect(server.DeleteTunnel(tunnel.ID)).To(BeTrue())
+
+			_, err := client.GetTunnelToken(ctx, tunnel.ID)
+			Expect(err).To(MatchError(domain.ErrTunnelNotFound))
+			_, err = client.GetTunnelConfiguration(ctx, tunnel.ID)
+			Expect(err).To(MatchError(domain.ErrTunnelNotFound))
+			Expect(client.DeleteTunnel(ctx, tunnel.ID)).To(MatchError(domain.ErrTunnelNotFound))
+
+			found, err := client.FindTunnelByName(ctx, "test")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found.ID).To(BeEmpty())
+		})
+
+		It("manages the DNS records of the tunnel", func() {
+			zone := server.AddZone("example.com")
+			other := server.AddZone("example.net")
+
+			By("adding the records")
+			Expect(client.AddDNS(ctx, zone.ID, "tunnel-a", "a.example.com")).To(Succeed())
+			Expect(client.AddDNS(ctx, other.ID, "tunnel-a", "a.example.net")).To(Succeed())
+			Expect(client.AddDNS(ctx, zone.ID, "tunnel-b", "b.example.com")).To(Succeed())
+			Expect(client.AddDNS(ctx, zone.ID, "tunnel-b", "b.example.com")).NotTo(Succeed())
+
+			record, err := client.GetDNS(ctx, zone.ID, "tunnel-a", "a.example.com")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(record.Healthy("tunnel-a")).To(BeTrue())
+
+			By("pointing a record to another tunnel")
+			Expect(client.UpdateDNS(ctx, zone.ID, "tunnel-b", "a.example.com", record)).To(Succeed())
+			record, err = client.GetDNS(ctx, zone.ID, "tunnel-b", "a.example.com")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(record.Healthy("tunnel-b")).To(BeTrue())
+
+			By("deleting the records of a tunnel in the allowed zones")
+			Expect(client.DeleteAllDNS(ctx, "tunnel-b", []string{"example.com"})).To(Succeed())
+			Expect(server.DNSRecords(zone.ID)).To(BeEmpty())
+			Expect(server.DNSRecords(other.ID)).To(HaveLen(1))
+
+			Expect(client.DeleteAllDNS(ctx, "tunnel-a", nil)).To(Succeed())
+			Expect(server.DNSRecords(other.ID)).To(BeEmpty())
+		})
+
+		It("retries the injected faults", func() {
+			server.InjectFault(fake.Fault{Path: "/cfd_tunnel", Status: http.StatusTooManyRequests, RetryAfter: time.Second, Times: 1})
+
+			tunnel, err := client.CreateTunnel(ctx, "test")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(server.Requests()).To(HaveLen(2))
+			Expect(server.Tunnels()).To(ConsistOf(HaveField("ID", tunnel.ID)))
+
+			By("retrying the server errors of idempotent requests")
+			server.InjectFault(fake.Fault{Method: http.MethodGet, Status: http.StatusInternalServerError, Times: 2})
+			_, err = client.GetTunnel(ctx, tunnel.ID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(server.Requests()).To(HaveLen(5))
+
+			By("not retrying the server errors of non-idempotent requests")
+			server.InjectFault(fake.Fault{Method: http.MethodPost, Status: http.StatusInternalServerError, Times: 1})
+			_, err = client.CreateTunnel(ctx, "test-2")
+			Expect(err).To(MatchError(domain.ErrCloudflareUnavailable))
+			Expect(server.Requests()).To(HaveLen(6))
+			Expect(server.Tunnels()).To(HaveLen(1))
+
+			By("giving up after the retries")
+			server.InjectFault(fake.Fault{Status: http.StatusTooManyRequests, RetryAfter: time.Minute})
+			_, err = client.GetTunnel(ctx, tunnel.ID)
+			Expect(err).To(MatchError(domain.ErrRateLimited))
+			retryAfter, ok := domain.RetryAfter(err)
+			Expect(ok).To(BeTrue())
+			Expect(retryAfter).To(Equal(time.Minute))
+
+			server.ClearFaults()
+			_, err = client.GetTunnel(ctx, tunnel.ID)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("delays the responses", func() {
+			server.SetLatency(time.Second)
+			ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+			defer cancel()
+
+			_, err := client.GetTunnel(ctx, "tunnel-id")
+			Expect(err).To(MatchError(context.DeadlineExceeded))
 		})
 	})
 })
@@ -1,12 +1,14 @@
 package external
 
-// import (
-// 	_ "github.com/joho/godotenv/autoload"
-// 	. "github.com/onsi/ginkgo/v2"
-// 	. "github.com/onsi/gomega"
-// )
+import (
+	"testing"
 
-// func TestExternal(t *testing.T) {
-// 	RegisterFailHandler(Fail)
-// 	RunSpecs(t, "External Suite")
-// }
+	_ "github.com/joho/godotenv/autoload"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestExternal(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "External Suite")
+}
@@ -0,0 +1,206 @@
+package fake
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+
+	cloudflare "github.com/cloudflare/cloudflare-go"
+	"github.com/google/uuid"
+)
+
+// AddZone adds a zone and returns it.
+func (s *Server) AddZone(name string) cloudflare.Zone {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zone := cloudflare.Zone{
+		ID:     strings.ReplaceAll(uuid.NewString(), "-", ""),
+		Name:   name,
+		Status: "active",
+	}
+	s.zones = append(s.zones, zone)
+	return zone
+}
+
+// DNSRecords returns the DNS records of the zone.
+func (s *Server) DNSRecords(zoneID string) []cloudflare.DNSRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.records[zoneID])
+}
+
+func (s *Server) zone(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		found := slices.ContainsFunc(s.zones, func(z cloudflare.Zone) bool { return z.ID == r.PathValue("zone") })
+		s.mu.Unlock()
+
+		if !found {
+			writeError(w, http.StatusNotFound, 1001, "Invalid zone identifier")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) listZones(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zones := []cloudflare.Zone{}
+	for _, z := range s.zones {
+		if name := r.URL.Query().Get("name"); name != "" && z.Name != name {
+			continue
+		}
+		zones = append(zones, z)
+	}
+	writeList(w, r, zones)
+}
+
+func (s *Server) listDNSRecords(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := []cloudflare.DNSRecord{}
+	for _, record := range s.records[r.PathValue("zone")] {
+		if v := query.Get("type"); v != "" && record.Type != v {
+			continue
+		}
+		if v := query.Get("name"); v != "" && record.Name != v {
+			continue
+		}
+		if v := query.Get("content"); v != "" && record.Content != v {
+			continue
+		}
+		if v := query.Get("comment"); v != "" && record.Comment != v {
+			continue
+		}
+		records = append(records, record)
+	}
+	writeList(w, r, records)
+}
+
+func (s *Server) createDNSRecord(w http.ResponseWriter, r *http.Request) {
+	var record cloudflare.DNSRecord
+	if err := json.NewDecoder(r.Body).Decode(&record); err != nil || record.Type == "" || record.Name == "" {
+		writeError(w, http.StatusBadRequest, 9000, "Invalid DNS record")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zoneID := r.PathValue("zone")
+	if s.conflictingRecord(zoneID, record) {
+		writeError(w, http.StatusBadRequest, 81053, "An A, AAAA, or CNAME record with that host already exists.")
+		return
+	}
+
+	record.ID = strings.ReplaceAll(uuid.NewString(), "-", "")
+	record.CreatedOn = s.now()
+	record.ModifiedOn = record.CreatedOn
+	record.Proxiable = true
+	s.records[zoneID] = append(s.records[zoneID], record)
+	writeResult(w, record)
+}
+
+func (s *Server) getDNSRecord(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.recordIndex(r.PathValue("zone"), r.PathValue("record"))
+	if !ok {
+		writeRecordNotFound(w)
+		return
+	}
+	writeResult(w, s.records[r.PathValue("zone")][i])
+}
+
+func (s *Server) updateDNSRecord(w http.ResponseWriter, r *http.Request) {
+	// PATCHでは指定されたフィールドだけを更新する
+	var patch struct {
+		Type    *string `json:"type"`
+		Name    *string `json:"name"`
+		Content *string `json:"content"`
+		TTL     *int    `json:"ttl"`
+		Proxied *bool   `json:"proxied"`
+		Comment *string `json:"comment"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		writeError(w, http.StatusBadRequest, 9000, "Invalid DNS record")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zoneID := r.PathValue("zone")
+	i, ok := s.recordIndex(zoneID, r.PathValue("record"))
+	if !ok {
+		writeRecordNotFound(w)
+		return
+	}
+
+	record := s.records[zoneID][i]
+	if r.Method == http.MethodPut {
+		record = cloudflare.DNSRecord{ID: record.ID, CreatedOn: record.CreatedOn, Proxiable: true}
+	}
+	setIfPresent(&record.Type, patch.Type)
+	setIfPresent(&record.Name, patch.Name)
+	setIfPresent(&record.Content, patch.Content)
+	setIfPresent(&record.TTL, patch.TTL)
+	setIfPresent(&record.Comment, patch.Comment)
+	if patch.Proxied != nil {
+		record.Proxied = patch.Proxied
+	}
+	record.ModifiedOn = s.now()
+
+	s.records[zoneID][i] = record
+	writeResult(w, record)
+}
+
+func (s *Server) deleteDNSRecord(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zoneID := r.PathValue("zone")
+	i, ok := s.recordIndex(zoneID, r.PathValue("record"))
+	if !ok {
+		writeRecordNotFound(w)
+		return
+	}
+
+	id := s.records[zoneID][i].ID
+	s.records[zoneID] = slices.Delete(s.records[zoneID], i, i+1)
+	writeResult(w, map[string]string{"id": id})
+}
+
+// conflictingRecord reports whether a CNAME record cannot be created because of an existing record, like the real API. s.mu must be held.
+func (s *Server) conflictingRecord(zoneID string, record cloudflare.DNSRecord) bool {
+	return slices.ContainsFunc(s.records[zoneID], func(existing cloudflare.DNSRecord) bool {
+		if existing.Name != record.Name {
+			return false
+		}
+		return existing.Type == "CNAME" || record.Type == "CNAME"
+	})
+}
+
+// recordIndex returns the index of the record in the zone. s.mu must be held.
+func (s *Server) recordIndex(zoneID string, recordID string) (int, bool) {
+	i := slices.IndexFunc(s.records[zoneID], func(record cloudflare.DNSRecord) bool { return record.ID == recordID })
+	return i, i >= 0
+}
+
+func setIfPresent[T any](dst *T, v *T) {
+	if v != nil {
+		*dst = *v
+	}
+}
+
+func writeRecordNotFound(w http.ResponseWriter) {
+	writeError(w, http.StatusNotFound, 81044, "Record does not exist.")
+}
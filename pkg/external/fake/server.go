@@ -0,0 +1,251 @@
+// Package fake provides an in-memory stand-in for the Cloudflare v4 API,
+// which serves the endpoints used by external.CloudflareTunnelClient.
+package fake
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cloudflare "github.com/cloudflare/cloudflare-go"
+)
+
+// Server is a fake Cloudflare API server that keeps the tunnels, their configurations, zones and DNS records in memory.
+// Pass Server.URL to the client as the base URL of the API.
+type Server struct {
+	*httptest.Server
+
+	// AccountID is the only account that the server accepts.
+	AccountID string
+
+	mu       sync.Mutex
+	tunnels  []*tunnel
+	zones    []cloudflare.Zone
+	records  map[string][]cloudflare.DNSRecord
+	faults   []*Fault
+	latency  time.Duration
+	requests []Request
+	now      func() time.Time
+}
+
+// Request is a request that the server received.
+type Request struct {
+	Method string
+	Path   string
+}
+
+// Fault is an error or a delay injected into the responses of the server.
+type Fault struct {
+	// Method matches the method of the request. Empty matches every method.
+	Method string
+	// Path matches the requests whose path contains it. Empty matches every path.
+	Path string
+	// Status is the status code to respond with. If it is zero, the request is served normally after Latency.
+	Status int
+	// RetryAfter is sent as the Retry-After header if it is set.
+	RetryAfter time.Duration
+	// Latency delays the response.
+	Latency time.Duration
+	// Times is the number of requests the fault applies to. Zero means every request.
+	Times int
+}
+
+// NewServer starts a fake server for the account.
+func NewServer(accountID string) *Server {
+	s := NewUnstartedServer(accountID)
+	s.Start()
+	return s
+}
+
+// NewUnstartedServer returns a fake server for the account without starting it,
+// so that the listener can be replaced before calling Start.
+func NewUnstartedServer(accountID string) *Server {
+	s := &Server{
+		AccountID: accountID,
+		records:   map[string][]cloudflare.DNSRecord{},
+		now:       time.Now,
+	}
+	s.Server = httptest.NewUnstartedServer(s.handler())
+	return s
+}
+
+// InjectFault adds a fault. Faults are matched in the order they were added.
+func (s *Server) InjectFault(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &f)
+}
+
+// ClearFaults removes every fault.
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+}
+
+// SetLatency delays every response by d.
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// Requests returns the requests that the server received, including the ones that failed by a fault.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /accounts/{account}/cfd_tunnel", s.account(s.listTunnels))
+	mux.HandleFunc("POST /accounts/{account}/cfd_tunnel", s.account(s.createTunnel))
+	mux.HandleFunc("GET /accounts/{account}/cfd_tunnel/{tunnel}", s.account(s.getTunnel))
+	mux.HandleFunc("PATCH /accounts/{account}/cfd_tunnel/{tunnel}", s.account(s.updateTunnel))
+	mux.HandleFunc("DELETE /accounts/{account}/cfd_tunnel/{tunnel}", s.account(s.deleteTunnel))
+	mux.HandleFunc("GET /accounts/{account}/cfd_tunnel/{tunnel}/token", s.account(s.getTunnelToken))
+	mux.HandleFunc("GET /accounts/{account}/cfd_tunnel/{tunnel}/connections", s.account(s.listTunnelConnections))
+	mux.HandleFunc("GET /accounts/{account}/cfd_tunnel/{tunnel}/configurations", s.account(s.getTunnelConfiguration))
+	mux.HandleFunc("PUT /accounts/{account}/cfd_tunnel/{tunnel}/configurations", s.account(s.updateTunnelConfiguration))
+
+	mux.HandleFunc("GET /zones", s.listZones)
+	mux.HandleFunc("GET /zones/{zone}/dns_records", s.zone(s.listDNSRecords))
+	mux.HandleFunc("POST /zones/{zone}/dns_records", s.zone(s.createDNSRecord))
+	mux.HandleFunc("GET /zones/{zone}/dns_records/{record}", s.zone(s.getDNSRecord))
+	mux.HandleFunc("PATCH /zones/{zone}/dns_records/{record}", s.zone(s.updateDNSRecord))
+	mux.HandleFunc("PUT /zones/{zone}/dns_records/{record}", s.zone(s.updateDNSRecord))
+	mux.HandleFunc("DELETE /zones/{zone}/dns_records/{record}", s.zone(s.deleteDNSRecord))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 本物のAPIと同じく、/client/v4以下でも受け付ける
+		r.URL.Path = strings.TrimPrefix(r.URL.Path, "/client/v4")
+
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			writeError(w, http.StatusBadRequest, 10000, "Authentication error")
+			return
+		}
+
+		if s.fault(w, r) {
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// fault records the request and applies the first matching fault. It reports whether the response was written.
+func (s *Server) fault(w http.ResponseWriter, r *http.Request) bool {
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Method: r.Method, Path: r.URL.Path})
+	latency := s.latency
+
+	var matched *Fault
+	for i, f := range s.faults {
+		if (f.Method != "" && f.Method != r.Method) || !strings.Contains(r.URL.Path, f.Path) {
+			continue
+		}
+		matched = f
+		if f.Times > 0 {
+			if f.Times--; f.Times == 0 {
+				s.faults = append(s.faults[:i:i], s.faults[i+1:]...)
+			}
+		}
+		break
+	}
+	s.mu.Unlock()
+
+	if matched != nil {
+		latency += matched.Latency
+	}
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return true
+		}
+	}
+
+	if matched == nil || matched.Status == 0 {
+		return false
+	}
+	if matched.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(matched.RetryAfter.Seconds())))
+	}
+	writeError(w, matched.Status, 10000+matched.Status, http.StatusText(matched.Status))
+	return true
+}
+
+func (s *Server) account(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("account") != s.AccountID {
+			writeError(w, http.StatusForbidden, 9109, "Unauthorized to access requested resource")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// response is the envelope of every response of the Cloudflare v4 API.
+type response struct {
+	Success    bool                      `json:"success"`
+	Errors     []cloudflare.ResponseInfo `json:"errors"`
+	Messages   []cloudflare.ResponseInfo `json:"messages"`
+	Result     any                       `json:"result"`
+	ResultInfo *cloudflare.ResultInfo    `json:"result_info,omitempty"`
+}
+
+func writeResult(w http.ResponseWriter, result any) {
+	writeJSON(w, http.StatusOK, response{
+		Success:  true,
+		Errors:   []cloudflare.ResponseInfo{},
+		Messages: []cloudflare.ResponseInfo{},
+		Result:   result,
+	})
+}
+
+// writeList writes the page of the items that is requested by the page and per_page parameters.
+func writeList[T any](w http.ResponseWriter, r *http.Request, items []T) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	page = max(page, 1)
+	perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if perPage < 1 {
+		perPage = 100
+	}
+
+	start := min((page-1)*perPage, len(items))
+	end := min(start+perPage, len(items))
+	result := append([]T{}, items[start:end]...)
+
+	writeJSON(w, http.StatusOK, response{
+		Success:  true,
+		Errors:   []cloudflare.ResponseInfo{},
+		Messages: []cloudflare.ResponseInfo{},
+		Result:   result,
+		ResultInfo: &cloudflare.ResultInfo{
+			Page:       page,
+			PerPage:    perPage,
+			TotalPages: max((len(items)+perPage-1)/perPage, 1),
+			Count:      len(result),
+			Total:      len(items),
+		},
+	})
+}
+
+func writeError(w http.ResponseWriter, status int, code int, message string) {
+	writeJSON(w, status, response{
+		Success:  false,
+		Errors:   []cloudflare.ResponseInfo{{Code: code, Message: message}},
+		Messages: []cloudflare.ResponseInfo{},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,299 @@
+package fake
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	cloudflare "github.com/cloudflare/cloudflare-go"
+	"github.com/google/uuid"
+	"k8s.io/utils/ptr"
+)
+
+type tunnel struct {
+	cloudflare.Tunnel
+	secret  string
+	config  cloudflare.TunnelConfiguration
+	version int
+	// connections are the connectors registered to the tunnel. The fake does not run cloudflared, so they are set by SetConnections.
+	connections []cloudflare.Connection
+}
+
+// CreateTunnel adds a tunnel as if it was created outside of the operator.
+func (s *Server) CreateTunnel(name string) cloudflare.Tunnel {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.createTunnelLocked(name, "").Tunnel
+}
+
+// Tunnels returns every tunnel, including the deleted ones.
+func (s *Server) Tunnels() []cloudflare.Tunnel {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tunnels := make([]cloudflare.Tunnel, 0, len(s.tunnels))
+	for _, t := range s.tunnels {
+		tunnels = append(tunnels, t.Tunnel)
+	}
+	return tunnels
+}
+
+// DeleteTunnel deletes the tunnel as if it was deleted outside of the operator. It reports whether the tunnel existed.
+func (s *Server) DeleteTunnel(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.activeTunnel(id)
+	if !ok {
+		return false
+	}
+	t.DeletedAt = ptr.To(s.now())
+	return true
+}
+
+// TunnelConfiguration returns the configuration of the tunnel and its version.
+func (s *Server) TunnelConfiguration(id string) (cloudflare.TunnelConfiguration, int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.activeTunnel(id)
+	if !ok {
+		return cloudflare.TunnelConfiguration{}, 0, false
+	}
+	return t.config, t.version, true
+}
+
+// SetTunnelConfiguration replaces the configuration of the tunnel as if it was edited on the dashboard.
+func (s *Server) SetTunnelConfiguration(id string, config cloudflare.TunnelConfiguration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.activeTunnel(id)
+	if !ok {
+		return false
+	}
+	t.config = config
+	t.version++
+	return true
+}
+
+// SetConnections replaces the connectors registered to the tunnel as if cloudflared connected to or disconnected from the edge.
+func (s *Server) SetConnections(id string, connections []cloudflare.Connection) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.activeTunnel(id)
+	if !ok {
+		return false
+	}
+	t.connections = connections
+	if len(connections) > 0 {
+		t.Status = "healthy"
+	} else {
+		t.Status = "inactive"
+	}
+	return true
+}
+
+func (s *Server) createTunnelLocked(name string, secret string) *tunnel {
+	t := &tunnel{
+		Tunnel: cloudflare.Tunnel{
+			ID:           uuid.NewString(),
+			Name:         name,
+			CreatedAt:    ptr.To(s.now()),
+			TunnelType:   "cfd_tunnel",
+			Status:       "inactive",
+			RemoteConfig: true,
+		},
+		secret: secret,
+	}
+	s.tunnels = append(s.tunnels, t)
+	return t
+}
+
+// tunnel returns the tunnel with the ID, including the deleted ones. s.mu must be held.
+func (s *Server) tunnel(id string) (*tunnel, bool) {
+	for _, t := range s.tunnels {
+		if t.ID == id {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// activeTunnel returns the tunnel with the ID if it is not deleted. s.mu must be held.
+func (s *Server) activeTunnel(id string) (*tunnel, bool) {
+	t, ok := s.tunnel(id)
+	if !ok || t.DeletedAt != nil {
+		return nil, false
+	}
+	return t, true
+}
+
+func (s *Server) listTunnels(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tunnels := []cloudflare.Tunnel{}
+	for _, t := range s.tunnels {
+		if name := query.Get("name"); name != "" && t.Name != name {
+			continue
+		}
+		if id := query.Get("uuid"); id != "" && t.ID != id {
+			continue
+		}
+		if deleted, err := strconv.ParseBool(query.Get("is_deleted")); err == nil && deleted != (t.DeletedAt != nil) {
+			continue
+		}
+		tunnels = append(tunnels, t.Tunnel)
+	}
+	writeList(w, r, tunnels)
+}
+
+func (s *Server) createTunnel(w http.ResponseWriter, r *http.Request) {
+	var params cloudflare.TunnelCreateParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil || params.Name == "" {
+		writeError(w, http.StatusBadRequest, 1001, "Invalid request body")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.tunnels {
+		if t.Name == params.Name && t.DeletedAt == nil {
+			writeError(w, http.StatusConflict, 1013, "You already have a tunnel with this name")
+			return
+		}
+	}
+	writeResult(w, s.createTunnelLocked(params.Name, params.Secret).Tunnel)
+}
+
+func (s *Server) getTunnel(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 削除されたトンネルも、deleted_atが設定された状態で取得できる
+	t, ok := s.tunnel(r.PathValue("tunnel"))
+	if !ok {
+		writeTunnelNotFound(w)
+		return
+	}
+	writeResult(w, t.Tunnel)
+}
+
+func (s *Server) updateTunnel(w http.ResponseWriter, r *http.Request) {
+	var params cloudflare.TunnelUpdateParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		writeError(w, http.StatusBadRequest, 1001, "Invalid request body")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.activeTunnel(r.PathValue("tunnel"))
+	if !ok {
+		writeTunnelNotFound(w)
+		return
+	}
+	if params.Name != "" {
+		t.Name = params.Name
+	}
+	if params.Secret != "" {
+		t.secret = params.Secret
+	}
+	writeResult(w, t.Tunnel)
+}
+
+func (s *Server) deleteTunnel(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.activeTunnel(r.PathValue("tunnel"))
+	if !ok {
+		writeTunnelNotFound(w)
+		return
+	}
+	t.DeletedAt = ptr.To(s.now())
+	writeResult(w, t.Tunnel)
+}
+
+func (s *Server) getTunnelToken(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.activeTunnel(r.PathValue("tunnel"))
+	if !ok {
+		writeTunnelNotFound(w)
+		return
+	}
+
+	token, _ := json.Marshal(map[string]string{
+		"a": s.AccountID,
+		"t": t.ID,
+		"s": t.secret,
+	})
+	writeResult(w, base64.StdEncoding.EncodeToString(token))
+}
+
+func (s *Server) listTunnelConnections(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.activeTunnel(r.PathValue("tunnel"))
+	if !ok {
+		writeTunnelNotFound(w)
+		return
+	}
+	writeResult(w, append([]cloudflare.Connection{}, t.connections...))
+}
+
+func (s *Server) getTunnelConfiguration(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.activeTunnel(r.PathValue("tunnel"))
+	if !ok {
+		writeTunnelNotFound(w)
+		return
+	}
+	writeResult(w, cloudflare.TunnelConfigurationResult{
+		TunnelID: t.ID,
+		Config:   t.config,
+		Version:  t.version,
+	})
+}
+
+func (s *Server) updateTunnelConfiguration(w http.ResponseWriter, r *http.Request) {
+	var params struct {
+		Config cloudflare.TunnelConfiguration `json:"config"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		writeError(w, http.StatusBadRequest, 1001, "Invalid request body")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.activeTunnel(r.PathValue("tunnel"))
+	if !ok {
+		writeTunnelNotFound(w)
+		return
+	}
+	t.config = params.Config
+	t.version++
+	writeResult(w, cloudflare.TunnelConfigurationResult{
+		TunnelID: t.ID,
+		Config:   t.config,
+		Version:  t.version,
+	})
+}
+
+func writeTunnelNotFound(w http.ResponseWriter) {
+	writeError(w, http.StatusNotFound, 1003, "Tunnel not found")
+}
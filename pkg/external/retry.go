@@ -0,0 +1,144 @@
+package external
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/domain"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// DefaultRateLimit is the default number of requests per second, which equals the Cloudflare API limit of 1200 requests per 5 minutes.
+	DefaultRateLimit = 4.0
+
+	defaultMaxRetries    = 4
+	defaultMinRetryDelay = 1 * time.Second
+	defaultMaxRetryDelay = 30 * time.Second
+)
+
+// retryTransport limits the request rate with a token bucket,
+// and retries the requests that failed with 429 or 5xx with exponential backoff, respecting the Retry-After header.
+// Transport errors and 5xx are retried only for idempotent methods, because the server may have applied the request.
+// If the retries are exhausted, it returns domain.RateLimitError or domain.ErrCloudflareUnavailable.
+type retryTransport struct {
+	base          http.RoundTripper
+	limiter       *rate.Limiter
+	maxRetries    int
+	minRetryDelay time.Duration
+	maxRetryDelay time.Duration
+	// sleep waits for d or until the request is canceled. テストで差し替える
+	sleep func(req *http.Request, d time.Duration) error
+}
+
+func newRetryTransport(base http.RoundTripper, rateLimit float64) *retryTransport {
+	return &retryTransport{
+		base:          base,
+		limiter:       rate.NewLimiter(rate.Limit(rateLimit), max(1, int(rateLimit))),
+		maxRetries:    defaultMaxRetries,
+		minRetryDelay: defaultMinRetryDelay,
+		maxRetryDelay: defaultMaxRetryDelay,
+		sleep:         sleepContext,
+	}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := t.limiter.Wait(req.Context()); err != nil {
+			return nil, fmt.Errorf("failed to wait for rate limiter: %w", err)
+		}
+
+		r := req
+		if attempt > 0 && req.Body != nil {
+			// 送信済みのBodyは読めないので作り直す
+			if req.GetBody == nil {
+				return nil, fmt.Errorf("%w: request body cannot be retried", domain.ErrCloudflareUnavailable)
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("failed to get request body: %w", err)
+			}
+			r = req.Clone(req.Context())
+			r.Body = body
+		}
+
+		resp, err := t.base.RoundTrip(r)
+		if err != nil {
+			if req.Context().Err() != nil || attempt >= t.maxRetries || !idempotent(req.Method) {
+				return nil, fmt.Errorf("%w: %w", domain.ErrCloudflareUnavailable, err)
+			}
+			if err := t.sleep(req, t.backoff(attempt)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < http.StatusInternalServerError {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		delay := t.backoff(attempt)
+		if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			delay = retryAfter
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests {
+			// 長いRetry-Afterの間ワーカーを止めないよう、呼び出し元に再キューを任せる
+			if attempt >= t.maxRetries || delay > t.maxRetryDelay {
+				return nil, &domain.RateLimitError{RetryAfter: delay}
+			}
+		} else if attempt >= t.maxRetries || !idempotent(req.Method) {
+			return nil, fmt.Errorf("%w: %s %s returned HTTP %d", domain.ErrCloudflareUnavailable, req.Method, req.URL.Path, resp.StatusCode)
+		}
+
+		if err := t.sleep(req, min(delay, t.maxRetryDelay)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// idempotent reports whether the request can be sent again even if the server may have applied it.
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// backoff returns the delay before the retry after the attempt, which doubles on every attempt.
+func (t *retryTransport) backoff(attempt int) time.Duration {
+	delay := time.Duration(float64(t.minRetryDelay) * math.Pow(2, float64(attempt)))
+	return min(delay, t.maxRetryDelay)
+}
+
+// parseRetryAfter parses the Retry-After header, which is either seconds or an HTTP date.
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(t.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+func sleepContext(req *http.Request, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-req.Context().Done():
+		return req.Context().Err()
+	}
+}
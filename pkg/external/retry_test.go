@@ -0,0 +1,235 @@
+package external
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	cloudflare "github.com/cloudflare/cloudflare-go"
+	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/domain"
+)
+
+type response struct {
+	status     int
+	retryAfter string
+}
+
+func Test_retryTransport(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		responses  []response
+		wantErr    error
+		wantDelays []time.Duration
+	}{
+		{
+			name:      "success",
+			responses: []response{{status: http.StatusOK}},
+		},
+		{
+			name:       "retry after the rate limit",
+			responses:  []response{{status: http.StatusTooManyRequests, retryAfter: "5"}, {status: http.StatusOK}},
+			wantDelays: []time.Duration{5 * time.Second},
+		},
+		{
+			name:       "exponential backoff on server errors",
+			responses:  []response{{status: http.StatusBadGateway}, {status: http.StatusServiceUnavailable}, {status: http.StatusOK}},
+			wantDelays: []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			name:       "server errors of non-idempotent requests are not retried",
+			method:     http.MethodPost,
+			responses:  []response{{status: http.StatusBadGateway}},
+			wantErr:    domain.ErrCloudflareUnavailable,
+			wantDelays: nil,
+		},
+		{
+			name:       "non-idempotent requests are retried after the rate limit",
+			method:     http.MethodPost,
+			responses:  []response{{status: http.StatusTooManyRequests, retryAfter: "5"}, {status: http.StatusOK}},
+			wantDelays: []time.Duration{5 * time.Second},
+		},
+		{
+			name:       "rate limit longer than the max retry delay is returned without waiting",
+			responses:  []response{{status: http.StatusTooManyRequests, retryAfter: "60"}},
+			wantErr:    domain.ErrRateLimited,
+			wantDelays: nil,
+		},
+		{
+			name:       "retry after of server errors is clamped to the max retry delay",
+			responses:  []response{{status: http.StatusServiceUnavailable, retryAfter: "60"}, {status: http.StatusOK}},
+			wantDelays: []time.Duration{30 * time.Second},
+		},
+		{
+			name:       "client errors are not retried",
+			responses:  []response{{status: http.StatusNotFound}},
+			wantDelays: nil,
+		},
+		{
+			name: "rate limited after the retries",
+			responses: []response{
+				{status: http.StatusTooManyRequests, retryAfter: "1"},
+				{status: http.StatusTooManyRequests, retryAfter: "1"},
+				{status: http.StatusTooManyRequests, retryAfter: "60"},
+			},
+			wantErr:    domain.ErrRateLimited,
+			wantDelays: []time.Duration{time.Second, time.Second},
+		},
+		{
+			name:       "unavailable after the retries",
+			responses:  []response{{status: http.StatusInternalServerError}, {status: http.StatusInternalServerError}, {status: http.StatusInternalServerError}},
+			wantErr:    domain.ErrCloudflareUnavailable,
+			wantDelays: []time.Duration{time.Second, 2 * time.Second},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bodies []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				bodies = append(bodies, string(body))
+
+				resp := tt.responses[len(bodies)-1]
+				if resp.retryAfter != "" {
+					w.Header().Set("Retry-After", resp.retryAfter)
+				}
+				w.WriteHeader(resp.status)
+			}))
+			defer server.Close()
+
+			var delays []time.Duration
+			transport := newRetryTransport(http.DefaultTransport, 1000)
+			transport.maxRetries = 2
+			transport.sleep = func(_ *http.Request, d time.Duration) error {
+				delays = append(delays, d)
+				return nil
+			}
+
+			method := tt.method
+			if method == "" {
+				method = http.MethodPut
+			}
+			req, err := http.NewRequest(method, server.URL, strings.NewReader("body"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := transport.RoundTrip(req)
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Fatalf("RoundTrip() error = %v, want %v", err, tt.wantErr)
+			}
+			if resp != nil {
+				resp.Body.Close()
+			}
+
+			if len(delays) != len(tt.wantDelays) {
+				t.Fatalf("delays = %v, want %v", delays, tt.wantDelays)
+			}
+			for i := range delays {
+				if delays[i] != tt.wantDelays[i] {
+					t.Errorf("delays = %v, want %v", delays, tt.wantDelays)
+				}
+			}
+			for _, body := range bodies {
+				if body != "body" {
+					t.Errorf("request body = %q, want it to be sent on every attempt", body)
+				}
+			}
+
+			if retryAfter, ok := domain.RetryAfter(err); ok && retryAfter != 60*time.Second {
+				t.Errorf("RetryAfter() = %v, want the delay of the last response", retryAfter)
+			}
+		})
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func Test_retryTransport_transportError(t *testing.T) {
+	tests := []struct {
+		method       string
+		wantAttempts int
+	}{
+		{method: http.MethodGet, wantAttempts: 3},
+		{method: http.MethodDelete, wantAttempts: 3},
+		{method: http.MethodPost, wantAttempts: 1},
+		{method: http.MethodPatch, wantAttempts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			attempts := 0
+			transport := newRetryTransport(roundTripperFunc(func(*http.Request) (*http.Response, error) {
+				attempts++
+				return nil, errors.New("connection reset by peer")
+			}), 1000)
+			transport.maxRetries = 2
+			transport.sleep = func(*http.Request, time.Duration) error { return nil }
+
+			req, err := http.NewRequest(tt.method, "http://example.com", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := transport.RoundTrip(req); !errors.Is(err, domain.ErrCloudflareUnavailable) {
+				t.Errorf("RoundTrip() error = %v, want %v", err, domain.ErrCloudflareUnavailable)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func Test_parseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		v      string
+		want   time.Duration
+		wantOK bool
+	}{
+		{name: "empty", v: "", wantOK: false},
+		{name: "seconds", v: "120", want: 2 * time.Minute, wantOK: true},
+		{name: "http date", v: "Wed, 01 Jan 2025 00:00:30 GMT", want: 30 * time.Second, wantOK: true},
+		{name: "past date", v: "Tue, 31 Dec 2024 23:59:00 GMT", want: 0, wantOK: true},
+		{name: "invalid", v: "soon", wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseRetryAfter(tt.v, now)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("parseRetryAfter() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestCloudflareTunnelClient_tunnelNotFound(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"success":false,"errors":[{"code":1003,"message":"Tunnel not found"}],"messages":[],"result":null}`))
+	}))
+	defer server.Close()
+
+	api, err := cloudflare.NewWithAPIToken("token", cloudflare.BaseURL(server.URL), cloudflare.UsingRetryPolicy(0, 0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &CloudflareTunnelClient{client: api, accountId: "account-id"}
+
+	if _, err := client.GetTunnel(context.Background(), "tunnel-id"); !errors.Is(err, domain.ErrTunnelNotFound) {
+		t.Errorf("GetTunnel() error = %v, want %v", err, domain.ErrTunnelNotFound)
+	}
+	if _, err := client.GetTunnelToken(context.Background(), "tunnel-id"); !errors.Is(err, domain.ErrTunnelNotFound) {
+		t.Errorf("GetTunnelToken() error = %v, want %v", err, domain.ErrTunnelNotFound)
+	}
+}
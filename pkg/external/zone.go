@@ -0,0 +1,117 @@
+package external
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/domain"
+)
+
+const DefaultZoneRefreshInterval = 10 * time.Minute
+
+// zoneCache holds the zones visible to the API token and refreshes them periodically.
+type zoneCache struct {
+	mu              sync.Mutex
+	zones           []domain.Zone
+	fetchedAt       time.Time
+	refreshInterval time.Duration
+}
+
+func (c *CloudflareTunnelClient) ListZones(ctx context.Context, allowedZones []string) ([]domain.Zone, error) {
+	zones, err := c.cachedZones(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return filterZones(zones, allowedZones), nil
+}
+
+// ResolveZone returns the zone that the hostname belongs to.
+// If multiple zones match, the one with the longest suffix is chosen.
+func (c *CloudflareTunnelClient) ResolveZone(ctx context.Context, hostname string, allowedZones []string) (domain.Zone, error) {
+	zones, err := c.ListZones(ctx, allowedZones)
+	if err != nil {
+		return domain.Zone{}, err
+	}
+
+	zone, ok := matchZone(hostname, zones)
+	if !ok {
+		return domain.Zone{}, fmt.Errorf("%w: %s", domain.ErrZoneNotFound, hostname)
+	}
+	return zone, nil
+}
+
+func (c *CloudflareTunnelClient) cachedZones(ctx context.Context) ([]domain.Zone, error) {
+	c.zones.mu.Lock()
+	defer c.zones.mu.Unlock()
+
+	if c.zones.zones != nil && time.Since(c.zones.fetchedAt) < c.zones.refreshInterval {
+		return c.zones.zones, nil
+	}
+
+	result, err := c.client.ListZones(ctx)
+	if err != nil {
+		if c.zones.zones != nil {
+			// 取得に失敗した場合は古いキャッシュを使い続ける
+			return c.zones.zones, nil
+		}
+		return nil, fmt.Errorf("failed to list zones: %w", err)
+	}
+
+	zones := make([]domain.Zone, 0, len(result))
+	for _, z := range result {
+		zones = append(zones, domain.Zone{
+			ID:   z.ID,
+			Name: z.Name,
+		})
+	}
+
+	c.zones.zones = zones
+	c.zones.fetchedAt = time.Now()
+	return zones, nil
+}
+
+// filterZones returns the zones whose name or ID is contained in allowedZones.
+// An empty allowedZones means that every zone is allowed.
+func filterZones(zones []domain.Zone, allowedZones []string) []domain.Zone {
+	if len(allowedZones) == 0 {
+		return zones
+	}
+
+	filtered := make([]domain.Zone, 0, len(allowedZones))
+	for _, zone := range zones {
+		if slices.ContainsFunc(allowedZones, func(allowed string) bool {
+			return allowed == zone.ID || normalizeHostname(allowed) == normalizeHostname(zone.Name)
+		}) {
+			filtered = append(filtered, zone)
+		}
+	}
+	return filtered
+}
+
+func matchZone(hostname string, zones []domain.Zone) (domain.Zone, bool) {
+	hostname = normalizeHostname(hostname)
+
+	var (
+		matched domain.Zone
+		found   bool
+	)
+	for _, zone := range zones {
+		name := normalizeHostname(zone.Name)
+		if hostname != name && !strings.HasSuffix(hostname, "."+name) {
+			continue
+		}
+		if !found || len(name) > len(normalizeHostname(matched.Name)) {
+			matched = zone
+			found = true
+		}
+	}
+	return matched, found
+}
+
+func normalizeHostname(hostname string) string {
+	return strings.TrimSuffix(strings.ToLower(hostname), ".")
+}
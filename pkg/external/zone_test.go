@@ -0,0 +1,110 @@
+package external
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/domain"
+)
+
+func Test_matchZone(t *testing.T) {
+	zones := []domain.Zone{
+		{ID: "1", Name: "walnuts.dev"},
+		{ID: "2", Name: "sub.walnuts.dev"},
+		{ID: "3", Name: "example.com"},
+	}
+
+	tests := []struct {
+		name     string
+		hostname string
+		want     domain.Zone
+		wantOK   bool
+	}{
+		{
+			name:     "apex",
+			hostname: "walnuts.dev",
+			want:     domain.Zone{ID: "1", Name: "walnuts.dev"},
+			wantOK:   true,
+		},
+		{
+			name:     "subdomain",
+			hostname: "www.walnuts.dev",
+			want:     domain.Zone{ID: "1", Name: "walnuts.dev"},
+			wantOK:   true,
+		},
+		{
+			name:     "longest suffix",
+			hostname: "www.sub.walnuts.dev",
+			want:     domain.Zone{ID: "2", Name: "sub.walnuts.dev"},
+			wantOK:   true,
+		},
+		{
+			name:     "case insensitive",
+			hostname: "WWW.Example.COM.",
+			want:     domain.Zone{ID: "3", Name: "example.com"},
+			wantOK:   true,
+		},
+		{
+			name:     "partial label",
+			hostname: "notwalnuts.dev",
+			wantOK:   false,
+		},
+		{
+			name:     "unknown",
+			hostname: "example.net",
+			wantOK:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := matchZone(tt.hostname, zones)
+			if ok != tt.wantOK {
+				t.Fatalf("matchZone() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matchZone() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_filterZones(t *testing.T) {
+	zones := []domain.Zone{
+		{ID: "1", Name: "walnuts.dev"},
+		{ID: "2", Name: "example.com"},
+		{ID: "3", Name: "example.net"},
+	}
+
+	tests := []struct {
+		name         string
+		allowedZones []string
+		want         []domain.Zone
+	}{
+		{
+			name:         "no allow-list",
+			allowedZones: nil,
+			want:         zones,
+		},
+		{
+			name:         "by name and ID",
+			allowedZones: []string{"walnuts.dev", "3"},
+			want: []domain.Zone{
+				{ID: "1", Name: "walnuts.dev"},
+				{ID: "3", Name: "example.net"},
+			},
+		},
+		{
+			name:         "nothing matches",
+			allowedZones: []string{"example.org"},
+			want:         []domain.Zone{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := filterZones(zones, tt.allowedZones)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("filterZones() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,14 +3,18 @@ package e2e
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"time"
 
+	cloudflare "github.com/cloudflare/cloudflare-go"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
+	"github.com/walnuts1018/cloudflare-tunnel-operator/pkg/external/fake"
 	"github.com/walnuts1018/cloudflare-tunnel-operator/test/utils"
 )
 
@@ -26,9 +30,17 @@ const metricsServiceName = "cloudflare-tunnel-operator-controller-manager-metric
 // metricsRoleBindingName is the name of the RBAC that will be created to allow get the metrics data
 const metricsRoleBindingName = "cloudflare-tunnel-operator-metrics-binding"
 
+// fakeAccountID is the Cloudflare account ID that the controller-manager uses with the fake Cloudflare API
+const fakeAccountID = "e2e-account"
+
 var _ = Describe("Manager", Ordered, func() {
 	var controllerPodName string
 
+	// cfAPI is the fake Cloudflare API which the controller-manager in the cluster talks to,
+	// so that the tests run without a Cloudflare account.
+	var cfAPI *fake.Server
+	var zone cloudflare.Zone
+
 	// Before running the tests, set up the environment by creating the namespace,
 	// installing CRDs, and deploying the controller.
 	BeforeAll(func() {
@@ -37,6 +49,27 @@ var _ = Describe("Manager", Ordered, func() {
 		_, err := utils.Run(cmd)
 		Expect(err).NotTo(HaveOccurred(), "Failed to create namespace")
 
+		By("starting the fake Cloudflare API")
+		hostAddress, err := utils.GetKindHostAddress()
+		Expect(err).NotTo(HaveOccurred(), "Failed to get the host address in the kind network")
+		listener, err := net.Listen("tcp", "0.0.0.0:0")
+		Expect(err).NotTo(HaveOccurred(), "Failed to listen for the fake Cloudflare API")
+		cfAPI = fake.NewUnstartedServer(fakeAccountID)
+		cfAPI.Listener.Close()
+		cfAPI.Listener = listener
+		cfAPI.Start()
+		zone = cfAPI.AddZone("example.com")
+
+		By("creating the Cloudflare credentials for the fake Cloudflare API")
+		port := listener.Addr().(*net.TCPAddr).Port
+		cmd = exec.Command("kubectl", "create", "secret", "generic", "cloudflare-secrets", "-n", namespace,
+			"--from-literal=CLOUDFLARE_API_TOKEN=fake-token",
+			"--from-literal=CLOUDFLARE_ACCOUNT_ID="+fakeAccountID,
+			fmt.Sprintf("--from-literal=CLOUDFLARE_API_BASE_URL=http://%s:%d", hostAddress, port),
+		)
+		_, err = utils.Run(cmd)
+		Expect(err).NotTo(HaveOccurred(), "Failed to create the Cloudflare credentials")
+
 		By("installing CRDs")
 		cmd = exec.Command("make", "install")
 		_, err = utils.Run(cmd)
@@ -66,6 +99,9 @@ var _ = Describe("Manager", Ordered, func() {
 		By("removing manager namespace")
 		cmd = exec.Command("kubectl", "delete", "ns", namespace)
 		_, _ = utils.Run(cmd)
+
+		By("stopping the fake Cloudflare API")
+		cfAPI.Close()
 	})
 
 	// After each test, check for failures and collect logs, events,
@@ -258,6 +294,67 @@ var _ = Describe("Manager", Ordered, func() {
 
 		// +kubebuilder:scaffold:e2e-webhooks-checks
 
+		It("should publish a TunnelRoute through the fake Cloudflare API", func() {
+			By("creating a CloudflareTunnel and a TunnelRoute")
+			cmd := exec.Command("kubectl", "apply", "-n", "default",
+				"-f", "config/samples/cf-tunnel-operator_v1beta1_cloudflaretunnel.yaml",
+				"-f", "config/samples/cf-tunnel-operator_v1beta1_tunnelroute.yaml")
+			_, err := utils.Run(cmd)
+			Expect(err).NotTo(HaveOccurred(), "Failed to create the CloudflareTunnel and the TunnelRoute")
+
+			var tunnelID string
+			verifyTunnelCreated := func(g Gomega) {
+				cmd := exec.Command("kubectl", "get", "cloudflaretunnel", "cloudflaretunnel-sample",
+					"-n", "default", "-o", "jsonpath={.status.tunnelID}")
+				output, err := utils.Run(cmd)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(output).NotTo(BeEmpty(), "Tunnel ID is not set")
+				tunnelID = output
+				g.Expect(cfAPI.Tunnels()).To(ContainElement(HaveField("ID", tunnelID)))
+			}
+			Eventually(verifyTunnelCreated).Should(Succeed())
+
+			By("validating that the rule and the DNS record are published")
+			verifyRoutePublished := func(g Gomega) {
+				config, _, ok := cfAPI.TunnelConfiguration(tunnelID)
+				g.Expect(ok).To(BeTrue())
+				g.Expect(config.Ingress).To(ContainElement(SatisfyAll(
+					HaveField("Hostname", "ssh.example.com"),
+					HaveField("Service", "ssh://bastion.default.svc:22"),
+				)))
+				g.Expect(cfAPI.DNSRecords(zone.ID)).To(ContainElement(SatisfyAll(
+					HaveField("Name", "ssh.example.com"),
+					HaveField("Content", tunnelID+".cfargotunnel.com"),
+				)))
+			}
+			Eventually(verifyRoutePublished).Should(Succeed())
+
+			By("deleting the tunnel outside of the operator")
+			Expect(cfAPI.DeleteTunnel(tunnelID)).To(BeTrue())
+			previousID := tunnelID
+			Eventually(func(g Gomega) {
+				verifyTunnelCreated(g)
+				g.Expect(tunnelID).NotTo(Equal(previousID), "Tunnel is not recreated")
+			}).Should(Succeed())
+			Eventually(verifyRoutePublished).Should(Succeed())
+
+			By("deleting the CloudflareTunnel and the TunnelRoute")
+			cmd = exec.Command("kubectl", "delete", "-n", "default",
+				"-f", "config/samples/cf-tunnel-operator_v1beta1_tunnelroute.yaml",
+				"-f", "config/samples/cf-tunnel-operator_v1beta1_cloudflaretunnel.yaml")
+			_, err = utils.Run(cmd)
+			Expect(err).NotTo(HaveOccurred(), "Failed to delete the CloudflareTunnel and the TunnelRoute")
+
+			verifyTunnelDeleted := func(g Gomega) {
+				tunnels := cfAPI.Tunnels()
+				i := slices.IndexFunc(tunnels, func(t cloudflare.Tunnel) bool { return t.ID == tunnelID })
+				g.Expect(i).NotTo(Equal(-1))
+				g.Expect(tunnels[i].DeletedAt).NotTo(BeNil(), "Tunnel is not deleted")
+				g.Expect(cfAPI.DNSRecords(zone.ID)).To(BeEmpty())
+			}
+			Eventually(verifyTunnelDeleted).Should(Succeed())
+		})
+
 		// TODO: Customize the e2e test suite with scenarios specific to your project.
 		// Consider applying sample/CR(s) and check their status and/or verifying
 		// the reconciliation by using the metrics, i.e.:
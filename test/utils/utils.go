@@ -195,6 +195,27 @@ func LoadImageToKindClusterWithName(name string) error {
 	return err
 }
 
+// GetKindHostAddress returns the address of the host as seen from the pods in the kind cluster,
+// which is the gateway of the "kind" docker network. It can be overridden by KIND_HOST_ADDRESS.
+func GetKindHostAddress() (string, error) {
+	if v, ok := os.LookupEnv("KIND_HOST_ADDRESS"); ok {
+		return v, nil
+	}
+	cmd := exec.Command("docker", "network", "inspect", "kind",
+		"-f", "{{ range .IPAM.Config }}{{ .Gateway }}{{ \"\\n\" }}{{ end }}")
+	output, err := Run(cmd)
+	if err != nil {
+		return "", err
+	}
+	for _, gateway := range GetNonEmptyLines(output) {
+		// Podから到達できるIPv4のゲートウェイを使う
+		if !strings.Contains(gateway, ":") {
+			return gateway, nil
+		}
+	}
+	return "", fmt.Errorf("no IPv4 gateway found in the kind network: %q", output)
+}
+
 // GetNonEmptyLines converts given command output string into individual objects
 // according to line breakers, and ignores the empty elements in it.
 func GetNonEmptyLines(output string) []string {